@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"pwsafe"
+)
+
+// A credential description as exchanged with git
+type credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// Read key=value lines up to a blank line or EOF
+func readCredential(r io.Reader) (credential, error) {
+	var c credential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return c, fmt.Errorf("invalid input line %q", line)
+		}
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return c, err
+			}
+			c.Protocol = u.Scheme
+			c.Host = u.Host
+			c.Path = strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				c.Username = u.User.Username()
+				if pw, ok := u.User.Password(); ok {
+					c.Password = pw
+				}
+			}
+		}
+	}
+	return c, scanner.Err()
+}
+
+func (c credential) write(w io.Writer) {
+	if c.Username != "" {
+		fmt.Fprintf(w, "username=%s\n", c.Username)
+	}
+	if c.Password != "" {
+		fmt.Fprintf(w, "password=%s\n", c.Password)
+	}
+}
+
+// URL stored in records written by the helper
+func (c credential) url() string {
+	u := url.URL{Scheme: c.Protocol, Host: c.Host}
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+	return u.String()
+}
+
+// Score how well record matches the credential request.
+//
+// Protocol and host must be equal and the record's path, if any, must be a
+// prefix of the requested path. A record without a scheme matches any
+// protocol. Longer matching paths score higher; -1 means no match.
+func matchRecord(c credential, record pwsafe.Record) int {
+	if record.Url == "" {
+		return -1
+	}
+	raw := record.Url
+	anyScheme := !strings.Contains(raw, "://")
+	if anyScheme {
+		raw = "//" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return -1
+	}
+	if !anyScheme && !strings.EqualFold(u.Scheme, c.Protocol) {
+		return -1
+	}
+	if !strings.EqualFold(u.Host, c.Host) {
+		return -1
+	}
+	if c.Username != "" && record.Username != c.Username {
+		return -1
+	}
+
+	recPath := trimPath(u.Path)
+	if recPath == "" {
+		return 0
+	}
+	reqPath := trimPath(c.Path)
+	if reqPath != recPath && !strings.HasPrefix(reqPath, recPath+"/") {
+		return -1
+	}
+	return len(recPath)
+}
+
+// Repository paths are compared without slashes and .git suffix
+func trimPath(p string) string {
+	return strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+}
+
+// Returns the best matching record, or nil
+func findCredential(c credential, records []pwsafe.Record) *pwsafe.Record {
+	var best *pwsafe.Record
+	bestScore := -1
+	for i := range records {
+		if score := matchRecord(c, records[i]); score > bestScore {
+			best = &records[i]
+			bestScore = score
+		}
+	}
+	return best
+}
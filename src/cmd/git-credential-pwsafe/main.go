@@ -0,0 +1,141 @@
+// git-credential-pwsafe is a git credential helper backed by a Password Safe file.
+//
+// Configure it with
+//
+//	git config --global credential.helper "pwsafe -f ~/passwords.psafe3 -group Git"
+//
+// Records are matched against the requested protocol, host and path using
+// their Url and Username fields. With -group, credentials git asks to store
+// are written to records in that group, and erase only removes records there.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"pwsafe"
+
+	"github.com/satori/go.uuid"
+)
+
+func main() {
+	pfile := flag.String("f", "", "psafe3 file")
+	group := flag.String("group", "", "store new credentials in `group`")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] get|store|erase\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetPrefix("git-credential-pwsafe: ")
+	log.SetFlags(0)
+
+	if flag.NArg() != 1 || *pfile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cred, err := readCredential(os.Stdin)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch flag.Arg(0) {
+	case "get":
+		err = get(*pfile, cred)
+	case "store":
+		err = store(*pfile, *group, cred)
+	case "erase":
+		err = erase(*pfile, *group, cred)
+	default:
+		// Unknown actions must be ignored
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func get(file string, cred credential) error {
+	safe, err := openSafe(file)
+	if err != nil {
+		return err
+	}
+	defer safe.Close()
+
+	records, err := safe.List()
+	if err != nil {
+		return err
+	}
+	record := findCredential(cred, records)
+	if record == nil {
+		return nil
+	}
+	credential{Username: record.Username, Password: record.Password}.write(os.Stdout)
+	return nil
+}
+
+func store(file, group string, cred credential) error {
+	if group == "" || cred.Username == "" || cred.Password == "" {
+		return nil
+	}
+	safe, err := openSafe(file)
+	if err != nil {
+		return err
+	}
+	defer safe.Close()
+
+	records, err := safe.List()
+	if err != nil {
+		return err
+	}
+	if record := findCredential(cred, groupRecords(records, group)); record != nil {
+		if record.Password == cred.Password {
+			return nil
+		}
+		record.Password = cred.Password
+		return safe.Update(*record)
+	}
+
+	return safe.Add(pwsafe.Record{
+		UUID:         uuid.NewV4(),
+		Group:        group,
+		Title:        cred.Username + "@" + cred.Host,
+		Username:     cred.Username,
+		Password:     cred.Password,
+		Url:          cred.url(),
+		CreationTime: time.Now(),
+	})
+}
+
+func erase(file, group string, cred credential) error {
+	if group == "" {
+		return nil
+	}
+	safe, err := openSafe(file)
+	if err != nil {
+		return err
+	}
+	defer safe.Close()
+
+	records, err := safe.List()
+	if err != nil {
+		return err
+	}
+	record := findCredential(cred, groupRecords(records, group))
+	if record == nil {
+		return nil
+	}
+	return safe.Delete(*record)
+}
+
+func groupRecords(records []pwsafe.Record, group string) []pwsafe.Record {
+	var in []pwsafe.Record
+	for _, record := range records {
+		if record.Group == group {
+			in = append(in, record)
+		}
+	}
+	return in
+}
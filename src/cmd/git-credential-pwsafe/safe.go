@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pwsafe"
+)
+
+// Git owns stdin, so the password can't be read from the terminal.
+var (
+	passwordFile = flag.String("password-file", "", "read master password from first line of `file`")
+	askPass      = flag.String("askpass", firstEnv("PWSAFE_ASKPASS", "GIT_ASKPASS", "SSH_ASKPASS"), "ask for master password with external `program`")
+	pinentry     = flag.String("pinentry", "pinentry", "ask for master password with pinentry `program`")
+)
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Access to the safe through a running agent or the file itself
+type credentialSafe interface {
+	List() ([]pwsafe.Record, error)
+	Add(record pwsafe.Record) error
+	Update(record pwsafe.Record) error
+	Delete(record pwsafe.Record) error
+	Close() error
+}
+
+func openSafe(file string) (credentialSafe, error) {
+	if client, err := pwsafe.DialAgent(file); err == nil {
+		if _, lerr := client.List(); lerr == nil {
+			return agentSafe{client}, nil
+		}
+		client.Close()
+	}
+
+	vault, err := openVault(file)
+	if err != nil {
+		return nil, err
+	}
+	vault.OnHistoryError = func(err error) {
+		log.Printf("history: %v", err)
+	}
+	for _, problem := range vault.Safe.Headers.Problems {
+		log.Printf("%s: %s", file, problem)
+	}
+	return &fileSafe{vault}, nil
+}
+
+// Open the safe with the cached key or ask for the master password
+func openVault(file string) (*pwsafe.Vault, error) {
+	storage, err := pwsafe.OpenStorage(file)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := pwsafe.CachedKey(storage.String()); err == nil {
+		if vault, err := pwsafe.OpenVaultKey(storage, key); err == nil {
+			return vault, nil
+		}
+	}
+
+	var source pwsafe.PasswordSource
+	switch {
+	case *passwordFile != "":
+		source = pwsafe.PasswordFile(*passwordFile)
+	case *askPass != "":
+		source = pwsafe.AskPass(*askPass)
+	default:
+		if _, ok := os.LookupEnv("PWSAFE_PASSWORD"); ok {
+			source = pwsafe.PasswordEnv("PWSAFE_PASSWORD")
+		} else {
+			source = pwsafe.Pinentry{Program: *pinentry, Title: "git-credential-pwsafe"}
+		}
+	}
+	pw, err := source.Password("Password for " + filepath.Base(file) + ": ")
+	if err != nil {
+		return nil, err
+	}
+	return pwsafe.OpenVault(storage, pw)
+}
+
+type agentSafe struct {
+	client *pwsafe.AgentClient
+}
+
+func (s agentSafe) List() ([]pwsafe.Record, error) { return s.client.List() }
+func (s agentSafe) Update(r pwsafe.Record) error   { return s.client.Update(r) }
+func (s agentSafe) Delete(r pwsafe.Record) error   { return s.client.Delete(r) }
+func (s agentSafe) Close() error                   { return s.client.Close() }
+
+func (s agentSafe) Add(r pwsafe.Record) error {
+	_, err := s.client.Add(r)
+	return err
+}
+
+type fileSafe struct {
+	vault *pwsafe.Vault
+}
+
+func (s *fileSafe) List() ([]pwsafe.Record, error) {
+	return s.vault.Safe.Records, nil
+}
+
+func (s *fileSafe) Add(record pwsafe.Record) error {
+	s.vault.Safe.Records = append(s.vault.Safe.Records, record)
+	return s.vault.Save()
+}
+
+func (s *fileSafe) Update(record pwsafe.Record) error {
+	old, err := s.vault.Safe.FindUUID(record.UUID)
+	if err != nil {
+		return err
+	}
+	record.MarkEdited(*old, time.Now())
+	*old = record
+	return s.vault.Save()
+}
+
+func (s *fileSafe) Delete(record pwsafe.Record) error {
+	if err := s.vault.Safe.Delete(record.UUID); err != nil {
+		return err
+	}
+	return s.vault.Save()
+}
+
+func (s *fileSafe) Close() error {
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"pwsafe"
+)
+
+const agentPidEnv = "PWSAFE_AGENT_PID"
+
+func runAgent(file string, args []string) error {
+	fs := commandFlags("agent")
+	timeout := fs.Duration("t", 15*time.Minute, "lock after `timeout` without requests (0 disables)")
+	sock := fs.String("s", "", "listen on unix socket `path`")
+	foreground := fs.Bool("d", false, "stay in the foreground")
+	kill := fs.Bool("k", false, "kill the agent named by "+agentPidEnv)
+	listenFD := fs.Int("listen-fd", -1, "serve an inherited listening socket (internal)")
+	fs.Parse(args)
+
+	if *kill {
+		return killAgent()
+	}
+
+	pw, err := getPassword(filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	storage, err := pwsafe.OpenStorage(file)
+	if err != nil {
+		return err
+	}
+	agent, err := pwsafe.NewAgent(storage, pw, *timeout)
+	if err != nil {
+		return err
+	}
+	agent.OnHistoryError = reportHistoryError
+
+	if *listenFD >= 0 {
+		l, lerr := net.FileListener(os.NewFile(uintptr(*listenFD), "agent.sock"))
+		if lerr != nil {
+			return lerr
+		}
+		return serveAgent(agent, l, *sock)
+	}
+
+	if !*foreground && !canDetach {
+		return errors.New("the agent cannot detach on this system, run it with -d")
+	}
+	if *sock == "" {
+		dir, derr := ioutil.TempDir(os.Getenv("XDG_RUNTIME_DIR"), "pwsafe-")
+		if derr != nil {
+			return derr
+		}
+		*sock = filepath.Join(dir, "agent.sock")
+	}
+	l, err := pwsafe.ListenAgent(*sock)
+	if err != nil {
+		return err
+	}
+
+	if *foreground {
+		printAgentEnv(*sock, agent.Token, os.Getpid())
+		return serveAgent(agent, l, *sock)
+	}
+
+	// Start a detached copy of ourselves that inherits the socket and
+	// reads the password from a pipe.
+	l.SetUnlinkOnClose(false)
+	lf, err := l.File()
+	if err != nil {
+		return err
+	}
+	passr, passw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	child := exec.Command(self, "-f", file, "-password-fd", "3",
+		"agent", "-listen-fd", "4", "-s", *sock, "-t", timeout.String())
+	child.Env = append(os.Environ(), pwsafe.AgentTokenEnv+"="+agent.Token)
+	child.ExtraFiles = []*os.File{passr, lf}
+	detach(child)
+	if err := child.Start(); err != nil {
+		return err
+	}
+	passr.Close()
+	lf.Close()
+	fmt.Fprintln(passw, pw)
+	passw.Close()
+
+	printAgentEnv(*sock, agent.Token, child.Process.Pid)
+	return nil
+}
+
+// Print shell commands setting up the environment for clients, like ssh-agent
+func printAgentEnv(sock, token string, pid int) {
+	fmt.Printf("%s=%s; export %s;\n", pwsafe.AgentSockEnv, sock, pwsafe.AgentSockEnv)
+	fmt.Printf("%s=%s; export %s;\n", pwsafe.AgentTokenEnv, token, pwsafe.AgentTokenEnv)
+	fmt.Printf("%s=%d; export %s;\n", agentPidEnv, pid, agentPidEnv)
+	fmt.Printf("echo Agent pid %d;\n", pid)
+}
+
+func serveAgent(agent *pwsafe.Agent, l net.Listener, sock string) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, lockSignals...)...)
+	go func() {
+	Signals:
+		for sig := range sigs {
+			agent.Lock()
+			for _, lock := range lockSignals {
+				if sig == lock {
+					continue Signals
+				}
+			}
+			removeAgentSocket(sock)
+			os.Exit(0)
+		}
+	}()
+
+	err := agent.Serve(l)
+	removeAgentSocket(sock)
+	return err
+}
+
+func removeAgentSocket(sock string) {
+	if sock == "" {
+		return
+	}
+	os.Remove(sock)
+	if dir := filepath.Dir(sock); strings.HasPrefix(filepath.Base(dir), "pwsafe-") {
+		os.Remove(dir)
+	}
+}
+
+func killAgent() error {
+	pid, err := strconv.Atoi(os.Getenv(agentPidEnv))
+	if err != nil {
+		return fmt.Errorf("%s not set", agentPidEnv)
+	}
+	if err := terminate(pid); err != nil {
+		return err
+	}
+	fmt.Printf("unset %s;\n", pwsafe.AgentSockEnv)
+	fmt.Printf("unset %s;\n", pwsafe.AgentTokenEnv)
+	fmt.Printf("unset %s;\n", agentPidEnv)
+	fmt.Printf("echo Agent pid %d killed;\n", pid)
+	return nil
+}
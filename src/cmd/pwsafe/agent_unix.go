@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// The agent and the clipboard clearer run detached from the terminal
+const canDetach = true
+
+// Signals making the agent lock itself rather than exit
+var lockSignals = []os.Signal{syscall.SIGUSR1}
+
+// Run cmd in a session of its own so it outlives the terminal
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
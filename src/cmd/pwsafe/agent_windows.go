@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Windows cannot pass files other than stdin, stdout and stderr to a child
+// process, which a detached agent or clipboard clearer needs
+const canDetach = false
+
+// Windows has no signal to lock the agent with
+var lockSignals []os.Signal
+
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+func terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
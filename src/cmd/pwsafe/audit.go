@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pwsafe"
+)
+
+// A duration flag that also accepts days and weeks, as in "30d" or "2w"
+type daysFlag time.Duration
+
+func (d *daysFlag) Set(s string) error {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit == 0 {
+		v, err := time.ParseDuration(s)
+		*d = daysFlag(v)
+		return err
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = daysFlag(time.Duration(n) * unit)
+	return nil
+}
+
+func (d *daysFlag) String() string {
+	if *d != 0 && time.Duration(*d)%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", time.Duration(*d)/(24*time.Hour))
+	}
+	return time.Duration(*d).String()
+}
+
+func runAudit(file string, args []string) error {
+	opts := pwsafe.DefaultAuditOptions()
+	fs := commandFlags("audit")
+	fs.IntVar(&opts.MinStrength, "min-strength", opts.MinStrength, "report passwords with fewer `bits` of estimated entropy as weak")
+	maxAge := daysFlag(opts.MaxAge)
+	fs.Var(&maxAge, "max-age", "report passwords unchanged for longer than `duration` (0 disables)")
+	expiring := daysFlag(opts.Expiring)
+	fs.Var(&expiring, "expiring", "report passwords expiring within `duration`")
+	asJSON := fs.Bool("json", false, "print the findings as JSON")
+	quiet := fs.Bool("q", false, "print nothing, only exit with status 1 if anything was found")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts.MaxAge = time.Duration(maxAge)
+	opts.Expiring = time.Duration(expiring)
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	records, err := store.List()
+	store.Close()
+	if err != nil {
+		return err
+	}
+	findings := pwsafe.Audit(&pwsafe.Safe{Records: records}, opts)
+
+	switch {
+	case *quiet:
+	case *asJSON:
+		if findings == nil {
+			findings = []pwsafe.AuditFinding{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			return err
+		}
+	default:
+		for _, f := range findings {
+			fmt.Printf("%s: %s: %s\n", f.Ref, f.Kind, f.Detail)
+		}
+	}
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
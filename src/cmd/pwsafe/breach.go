@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"pwsafe"
+)
+
+func runBreachCheck(file string, args []string) error {
+	fs := commandFlags("breach-check")
+	db := fs.String("db", "", "sorted Pwned Passwords SHA-1 or NTLM hash `file`")
+	mark := fs.Bool("mark", false, "record the date of the check in the notes of each record checked")
+	fs.Parse(args)
+	if *db == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	breaches, err := pwsafe.OpenBreachDB(*db)
+	if err != nil {
+		return err
+	}
+	defer breaches.Close()
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	records, err := store.List()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		records = nil
+		for _, ref := range fs.Args() {
+			record, err := store.Get(ref)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+	}
+	sort.Sort(ByGroupTitle(records))
+
+	today := time.Now().Format("2006-01-02")
+	breached := 0
+	for _, record := range records {
+		if record.Password == "" {
+			continue
+		}
+		count, err := breaches.Count(record.Password)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			breached++
+			fmt.Printf("%s: seen %d times in breaches\n", record.Ref(), count)
+		}
+		if *mark {
+			record.Notes = pwsafe.SetNoteField(record.Notes, pwsafe.BreachCheckField, today)
+			if err := store.Update(record); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d passwords found in breaches\n", breached, len(records))
+	if breached > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
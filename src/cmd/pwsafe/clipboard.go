@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"pwsafe"
+)
+
+// Copying to the clipboard uses wl-copy or xclip when they are installed
+// and a display is available, and OSC 52 terminal escapes otherwise, which
+// also reach the local clipboard over SSH and from inside tmux (with
+// set-clipboard on). A detached copy of pwsafe clears the clipboard after
+// -clip-timeout unless something else was copied meanwhile; with OSC 52 the
+// clipboard cannot be read back and is cleared regardless.
+
+var clipTimeout = flag.Duration("clip-timeout", 45*time.Second, "clear copied fields from the clipboard after `duration` (0 keeps them)")
+
+// Commands to copy stdin to the clipboard, print the clipboard and clear it
+type clipboard struct {
+	copy, paste, clear []string
+}
+
+// The clipboard tool to use, or nil for OSC 52
+func findClipboard() *clipboard {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return &clipboard{
+				copy:  []string{"wl-copy"},
+				paste: []string{"wl-paste", "--no-newline"},
+				clear: []string{"wl-copy", "--clear"},
+			}
+		}
+	}
+	if os.Getenv("DISPLAY") != "" {
+		if _, err := exec.LookPath("xclip"); err == nil {
+			return &clipboard{
+				copy:  []string{"xclip", "-selection", "clipboard"},
+				paste: []string{"xclip", "-selection", "clipboard", "-o"},
+				clear: []string{"xclip", "-selection", "clipboard"},
+			}
+		}
+	}
+	return nil
+}
+
+func (c *clipboard) write(value string) error {
+	cmd := exec.Command(c.copy[0], c.copy[1:]...)
+	cmd.Stdin = strings.NewReader(value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", c.copy[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Write value to the clipboard with an OSC 52 escape, wrapped for GNU screen
+func writeOSC52(w io.Writer, value string) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(value)) + "\a"
+	if strings.HasPrefix(os.Getenv("TERM"), "screen") && os.Getenv("TMUX") == "" {
+		seq = "\x1bP" + seq + "\x1b\\"
+	}
+	_, err := io.WriteString(w, seq)
+	return err
+}
+
+func clipHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// The value of a record field to copy; "totp" is the current two-factor code
+func clipValue(record pwsafe.Record, field string) (string, error) {
+	if strings.ToLower(field) == "totp" {
+		code, _, err := record.TOTP(time.Now())
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", record.Ref(), err)
+		}
+		return code, nil
+	}
+	return record.Field(field)
+}
+
+// Copy value to the clipboard and start a detached copy of pwsafe that
+// clears it after the timeout
+func copyToClipboard(value string) error {
+	if *clipTimeout > 0 && !canDetach {
+		return errors.New("the clipboard cannot be cleared later on this system, use -clip-timeout 0")
+	}
+	var tty *os.File
+	if c := findClipboard(); c != nil {
+		if err := c.write(value); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if tty, err = os.OpenFile("/dev/tty", os.O_WRONLY, 0); err != nil {
+			return fmt.Errorf("no clipboard tool and no terminal for OSC 52: %v", err)
+		}
+		defer tty.Close()
+		if err := writeOSC52(tty, value); err != nil {
+			return err
+		}
+	}
+	if *clipTimeout <= 0 {
+		return nil
+	}
+
+	// Only the hash of the value is passed on, to tell whether the
+	// clipboard still holds it
+	hashr, hashw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer hashw.Close()
+	self, err := os.Executable()
+	if err != nil {
+		hashr.Close()
+		return err
+	}
+	child := exec.Command(self, "-clip-timeout", clipTimeout.String(), "get", "-clear-clip-fd", "3")
+	child.ExtraFiles = []*os.File{hashr}
+	if tty != nil {
+		child.ExtraFiles = append(child.ExtraFiles, tty)
+	}
+	detach(child)
+	err = child.Start()
+	hashr.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(hashw, clipHash(value))
+	return nil
+}
+
+// Tell what was copied and when it will be cleared
+func clipMessage(field, ref string) string {
+	if *clipTimeout <= 0 {
+		return fmt.Sprintf("Copied %s of %s", field, ref)
+	}
+	return fmt.Sprintf("Copied %s of %s, clearing the clipboard in %v", field, ref, *clipTimeout)
+}
+
+// Wait for the timeout and clear the clipboard if it still holds the value
+// whose hash is read from fd. Writes OSC 52 escapes to fd+1 if there is no
+// clipboard tool.
+func clearClipboardLater(fd int) error {
+	line, err := bufio.NewReader(os.NewFile(uintptr(fd), "hash")).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	hash := strings.TrimSpace(line)
+	time.Sleep(*clipTimeout)
+
+	c := findClipboard()
+	if c == nil {
+		return writeOSC52(os.NewFile(uintptr(fd+1), "tty"), "")
+	}
+	current, err := exec.Command(c.paste[0], c.paste[1:]...).Output()
+	if err == nil && clipHash(string(current)) != hash {
+		// Something else was copied since
+		return nil
+	}
+	cmd := exec.Command(c.clear[0], c.clear[1:]...)
+	cmd.Stdin = strings.NewReader("")
+	return cmd.Run()
+}
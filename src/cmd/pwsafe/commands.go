@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"pwsafe"
+)
+
+// A subcommand of pwsafe
+type command struct {
+	args  string
+	short string
+	run   func(file string, args []string) error
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"agent":        {"[-t timeout] [-s socket] [-d] [-k]", "hold the unlocked safe for other pwsafe commands", runAgent},
+		"audit":        {"[-min-strength bits] [-max-age 365d] [-expiring 30d] [-json | -q]", "report reused, weak, old and expiring passwords", runAudit},
+		"breach-check": {"-db file [-mark] [Group/Title]...", "look up passwords in a local Have I Been Pwned hash file", runBreachCheck},
+		"convert":      {"[-cipher aes|chacha20] [-kdf argon2d|argon2id|aes] input output", "convert between psafe3 and KeePass KDBX 4 files", runConvert},
+		"expiring":     {"[-within 30d]", "list passwords that expired or expire soon", runExpiring},
+		"export":       {"format [arguments]", "export records to another format", runExport},
+		"import":       {"format [arguments]", "import records from another format", runImport},
+		"inject":       {"[-i template] [-o output] [-mode 0600] [-n]", "render a template with secrets from the safe", runInject},
+		"list":         {"", "list records", runList},
+		"log":          {"[-v]", "list versions of the safe in its git repository", runLog},
+		"lock":         {"", "forget the cached key and lock the agent", runLock},
+		"qr":           {"[-invert] [-level L|M|Q|H] Group/Title [field]", "show a field or the two-factor key of a record as QR code", runQR},
+		"restore":      {"revision", "restore the safe to a version from its git repository", runRestore},
+		"rotate":       {"[-hook program] [-n] Group/Title | -group group [-within 30d]", "replace a password, or the expired ones of a group, with a generated one", runRotate},
+		"run":          {"[-env NAME=Group/Title#field]... [-env-file file] [-mask] -- command [args]", "run a command with secrets in its environment", runRun},
+		"serve":        {"-tokens file [-listen addr] [-audit file]", "serve the safe as a JSON API over HTTP", runServe},
+		"template":     {"[-set \"Name: field, ...\" | -delete name]", "list record templates or store one in the safe", runTemplate},
+		"show":         {"[-at revision] [Group/Title]", "show records of an older version of the safe", runShow},
+		"totp":         {"[-set] [-uri] Group/Title", "print the current two-factor code of a record", runTOTP},
+		"unlock":       {"[-t timeout]", "cache the key in the kernel keyring", runUnlock},
+		"get":          {"[-field name] [-clip] Group/Title[#field]", "show a record or one of its fields, or copy it to the clipboard", runGet},
+		"add":          {"[-user name] [-url url] [-email addr] [-notes text] [-template name] Group/Title", "add a record, reading the password from stdin", runAdd},
+		"update":       {"Group/Title field [value] | Group/Title#field [value]", "change a record field, reading the value from stdin if not given", runUpdate},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [command [arguments]]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Without a command the safe is opened in the terminal UI.\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func runCommand(file string, args []string) {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(file, args[1:]); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Returns a flag set for the named subcommand
+func commandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] %s %s\n", os.Args[0], name, commands[name].args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runList(file string, args []string) error {
+	fs := commandFlags("list")
+	fs.Parse(args)
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	records, err := store.List()
+	if err != nil {
+		return err
+	}
+	sort.Sort(ByGroupTitle(records))
+	for _, record := range records {
+		fmt.Println(record.Ref())
+	}
+	return nil
+}
+
+func runGet(file string, args []string) error {
+	fs := commandFlags("get")
+	field := fs.String("field", "", "print only this field")
+	clip := fs.Bool("clip", false, "copy the field, by default the password, to the clipboard instead of printing it")
+	clearFD := fs.Int("clear-clip-fd", -1, "clear the clipboard after -clip-timeout (internal)")
+	fs.Parse(args)
+	if *clearFD >= 0 {
+		return clearClipboardLater(*clearFD)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	record, refField, err := getFieldRef(store, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if refField != "" {
+		if *field != "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+		*field = refField
+	}
+	if *clip {
+		if *field == "" {
+			*field = "password"
+		}
+		value, err := clipValue(record, *field)
+		if err != nil {
+			return err
+		}
+		if err := copyToClipboard(value); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, clipMessage(*field, record.Ref()))
+		return nil
+	}
+	if *field == "" {
+		safeTemplates, err := store.Templates()
+		if err != nil {
+			return err
+		}
+		templates, err := availableTemplates(safeTemplates)
+		if err != nil {
+			return err
+		}
+		fmt.Println(getRecordDetail(record, templates))
+		return nil
+	}
+	value, err := record.Field(*field)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+func runAdd(file string, args []string) error {
+	fs := commandFlags("add")
+	user := fs.String("user", "", "username")
+	url := fs.String("url", "", "url")
+	email := fs.String("email", "", "email address")
+	notes := fs.String("notes", "", "notes")
+	template := fs.String("template", "", "make the record from the `named` template, with its fields empty")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var record pwsafe.Record
+	record.Group, record.Title = pwsafe.ParseRef(fs.Arg(0))
+	record.Username = *user
+	record.Url = *url
+	record.Email = *email
+	record.Notes = *notes
+
+	password, err := readValue("Record password: ")
+	if err != nil {
+		return err
+	}
+	record.Password = password
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *template != "" {
+		safeTemplates, err := store.Templates()
+		if err != nil {
+			return err
+		}
+		templates, err := availableTemplates(safeTemplates)
+		if err != nil {
+			return err
+		}
+		t, err := pwsafe.FindTemplate(templates, *template)
+		if err != nil {
+			return err
+		}
+		record.ApplyTemplate(t)
+	}
+	return store.Add(record)
+}
+
+func runUpdate(file string, args []string) error {
+	fs := commandFlags("update")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	record, field, err := getFieldRef(store, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	rest := fs.Args()[1:]
+	if field == "" && len(rest) > 0 {
+		field, rest = rest[0], rest[1:]
+	}
+	if field == "" || len(rest) > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var value string
+	if len(rest) == 1 {
+		value = rest[0]
+	} else if value, err = readValue(field + ": "); err != nil {
+		return err
+	}
+	if err := record.SetField(field, value); err != nil {
+		return err
+	}
+	return store.Update(record)
+}
+
+// Get the record of a "Group/Title#field" reference and the field, or ""
+// if the reference names no field. A record whose title contains '#' is
+// found by its full reference first.
+func getFieldRef(store recordStore, ref string) (pwsafe.Record, string, error) {
+	record, err := store.Get(ref)
+	if err == nil || !strings.Contains(ref, "#") {
+		return record, "", err
+	}
+	recordRef, field := pwsafe.ParseFieldRef(ref)
+	record, err = store.Get(recordRef)
+	if err != nil {
+		return record, "", err
+	}
+	return record, field, nil
+}
+
+// Read one line from stdin, prompting without echo when stdin is a terminal
+func readValue(prompt string) (string, error) {
+	if isTerminal(os.Stdin) {
+		return terminalPassword(prompt)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pwsafe"
+)
+
+func isKDBX(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".kdbx")
+}
+
+// Flags choosing how KDBX files are encrypted
+func kdbxFlags(fs *flag.FlagSet) (cipher, kdf *string) {
+	cipher = fs.String("cipher", pwsafe.DefaultKDBXOptions.Cipher, "KDBX cipher: aes or chacha20")
+	kdf = fs.String("kdf", pwsafe.DefaultKDBXOptions.KDF, "KDBX key derivation: argon2d, argon2id or aes")
+	return cipher, kdf
+}
+
+// Read a psafe3 or KDBX file, depending on its extension
+func readSafeFile(name, password string) (*pwsafe.Safe, error) {
+	if !isKDBX(name) {
+		return pwsafe.ParseFile(name, password)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pwsafe.ReadKDBX(f, password)
+}
+
+// Write a psafe3 or KDBX file, depending on its extension
+func writeSafeFile(name, password string, safe *pwsafe.Safe, opts pwsafe.KDBXOptions) error {
+	if !isKDBX(name) {
+		return pwsafe.OutputFile(name, password, *safe)
+	}
+	var buf bytes.Buffer
+	if err := pwsafe.WriteKDBX(&buf, safe, password, opts); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, buf.Bytes(), 0600)
+}
+
+func runConvert(file string, args []string) error {
+	fs := commandFlags("convert")
+	cipher, kdf := kdbxFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input, output := fs.Arg(0), fs.Arg(1)
+	if isKDBX(input) == isKDBX(output) {
+		return fmt.Errorf("convert between a .kdbx file and a .psafe3 file")
+	}
+	if _, err := os.Stat(output); err == nil {
+		return fmt.Errorf("%s already exists", output)
+	}
+
+	safeFile, kdbxFile := input, output
+	if isKDBX(input) {
+		safeFile, kdbxFile = output, input
+	}
+	// The database keeps the master password unless given one of its own,
+	// in which case a new safe's password is not checked by reading the
+	// input and has to be confirmed
+	getSafePassword := getPassword
+	if kdbxPasswordGiven() && safeFile == output {
+		getSafePassword = getNewPassword
+	}
+	password, err := getSafePassword(filepath.Base(safeFile))
+	if err != nil {
+		return err
+	}
+	kdbxPassword := password
+	if kdbxPasswordGiven() {
+		if kdbxPassword, err = getKDBXPassword(filepath.Base(kdbxFile), kdbxFile == output); err != nil {
+			return err
+		}
+	}
+	inputPassword, outputPassword := password, kdbxPassword
+	if input == kdbxFile {
+		inputPassword, outputPassword = kdbxPassword, password
+	}
+
+	safe, err := readSafeFile(input, inputPassword)
+	if err != nil {
+		return err
+	}
+	opts := pwsafe.KDBXOptions{Cipher: *cipher, KDF: *kdf}
+	if err := writeSafeFile(output, outputPassword, safe, opts); err != nil {
+		return err
+	}
+	fmt.Printf("Converted %d records to %s\n", len(safe.Records), output)
+	return nil
+}
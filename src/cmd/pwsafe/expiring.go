@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"pwsafe"
+)
+
+func runExpiring(file string, args []string) error {
+	fs := commandFlags("expiring")
+	within := daysFlag(30 * 24 * time.Hour)
+	fs.Var(&within, "within", "list passwords expiring within `duration`, such as 30d or 2w")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	records, err := store.List()
+	store.Close()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var due []pwsafe.Record
+	for _, record := range records {
+		if expiry := record.PasswordExpiry(); !expiry.IsZero() && expiry.Sub(now) <= time.Duration(within) {
+			due = append(due, record)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].PasswordExpiry().Before(due[j].PasswordExpiry())
+	})
+	for _, record := range due {
+		expiry := record.PasswordExpiry()
+		state := "expires"
+		if !expiry.After(now) {
+			state = "expired"
+		}
+		fmt.Printf("%s: %s on %s\n", record.Ref(), state, expiry.Local().Format("2006-01-02"))
+	}
+	if len(due) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pwsafe"
+)
+
+// Formats written by the export command
+var exportFormats map[string]*command
+
+func init() {
+	exportFormats = map[string]*command{
+		"bitwarden": {"[-fields list] [-filter field=value]... [-o file]", "an unencrypted Bitwarden JSON export", runExportBitwarden},
+		"ics":       {"[-filter field=value]... [-o file]", "an iCalendar feed of password expiry dates, without secrets", runExportICS},
+		"json":      {"[-reveal] [-fields list] [-filter field=value]... [-o file]", "the whole safe as JSON", runExportJSON},
+		"kdbx":      {"[-cipher aes|chacha20] [-kdf argon2d|argon2id|aes] [-fields list] [-filter field=value]... file.kdbx", "a KeePass 4 database with its own password", runExportKDBX},
+		"xml":       {"[-fields list] [-filter field=value]... [-o file]", "the XML format of the Password Safe desktop client", runExportXML},
+		"yaml":      {"[-reveal] [-fields list] [-filter field=value]... [-o file]", "the whole safe as YAML", runExportYAML},
+	}
+}
+
+func runExport(file string, args []string) error {
+	return runFormat("export", exportFormats, file, args)
+}
+
+// Flags choosing which records and fields are exported or imported
+type selection struct {
+	fields  *string
+	filters stringList
+}
+
+func selectionFlags(fs *flag.FlagSet) *selection {
+	s := &selection{}
+	s.fields = fs.String("fields", "", "comma separated `list` of the fields to include (default all)")
+	fs.Var(&s.filters, "filter", "only include records matching `field=value`, field!=value, field~text or field!~text (repeatable)")
+	return s
+}
+
+// The selected records with the selected fields
+func (s *selection) apply(records []pwsafe.Record) ([]pwsafe.Record, error) {
+	var filters []pwsafe.RecordFilter
+	for _, spec := range s.filters {
+		f, err := pwsafe.ParseRecordFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	records = pwsafe.FilterRecords(records, filters)
+	if *s.fields == "" {
+		return records, nil
+	}
+
+	fields := strings.Split(*s.fields, ",")
+	for i := range records {
+		selected, err := pwsafe.SelectFields(records[i], fields)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = selected
+	}
+	return records, nil
+}
+
+// Write exported data to stdout or to a file only the user can read
+func writeExport(output string, data []byte) error {
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(output, data, 0600)
+}
+
+func runExportBitwarden(file string, args []string) error {
+	fs := formatFlags("export", "bitwarden", exportFormats)
+	sel := selectionFlags(fs)
+	output := fs.String("o", "", "output `file` (default stdout)")
+	fs.Parse(args)
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	safe := *vault.Safe
+	if safe.Records, err = sel.apply(safe.Records); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := pwsafe.WriteBitwarden(&buf, &safe); err != nil {
+		return err
+	}
+	return writeExport(*output, buf.Bytes())
+}
+
+func runExportJSON(file string, args []string) error {
+	return runExportDump("json", file, args)
+}
+
+func runExportYAML(file string, args []string) error {
+	return runExportDump("yaml", file, args)
+}
+
+func runExportDump(format, file string, args []string) error {
+	fs := formatFlags("export", format, exportFormats)
+	reveal := fs.Bool("reveal", false, "include passwords")
+	sel := selectionFlags(fs)
+	output := fs.String("o", "", "output `file` (default stdout)")
+	fs.Parse(args)
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	safe := *vault.Safe
+	if safe.Records, err = sel.apply(safe.Records); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if format == "json" {
+		err = pwsafe.WriteJSON(&buf, &safe, *reveal)
+	} else {
+		err = pwsafe.WriteYAML(&buf, &safe, *reveal)
+	}
+	if err != nil {
+		return err
+	}
+	return writeExport(*output, buf.Bytes())
+}
+
+func runExportICS(file string, args []string) error {
+	fs := formatFlags("export", "ics", exportFormats)
+	sel := selectionFlags(fs)
+	output := fs.String("o", "", "output `file` (default stdout)")
+	fs.Parse(args)
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	safe := *vault.Safe
+	if safe.Records, err = sel.apply(safe.Records); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := pwsafe.WriteICS(&buf, &safe); err != nil {
+		return err
+	}
+	return writeExport(*output, buf.Bytes())
+}
+
+func runExportXML(file string, args []string) error {
+	fs := formatFlags("export", "xml", exportFormats)
+	sel := selectionFlags(fs)
+	output := fs.String("o", "", "output `file` (default stdout)")
+	fs.Parse(args)
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	safe := *vault.Safe
+	if safe.Records, err = sel.apply(safe.Records); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := pwsafe.WriteXML(&buf, &safe, filepath.Base(file)); err != nil {
+		return err
+	}
+	return writeExport(*output, buf.Bytes())
+}
+
+func runExportKDBX(file string, args []string) error {
+	fs := formatFlags("export", "kdbx", exportFormats)
+	cipher, kdf := kdbxFlags(fs)
+	sel := selectionFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	safe := *vault.Safe
+	if safe.Records, err = sel.apply(safe.Records); err != nil {
+		return err
+	}
+
+	output := fs.Arg(0)
+	password, err := getKDBXPassword(filepath.Base(output), true)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := pwsafe.WriteKDBX(&buf, &safe, password, pwsafe.KDBXOptions{Cipher: *cipher, KDF: *kdf}); err != nil {
+		return err
+	}
+	return writeExport(output, buf.Bytes())
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"pwsafe"
+)
+
+// Returns a password source that asks source once and then repeats the answer
+func onceSource(source pwsafe.PasswordSource) pwsafe.PasswordSource {
+	var pw string
+	var err error
+	asked := false
+	return pwsafe.PasswordFunc(func(prompt string) (string, error) {
+		if !asked {
+			pw, err = source.Password(prompt)
+			asked = true
+		}
+		return pw, err
+	})
+}
+
+func openHistory(file string) (*pwsafe.Vault, *pwsafe.GitHistory, error) {
+	vault, err := openVault(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	history, err := vault.History()
+	if err != nil {
+		return nil, nil, err
+	}
+	return vault, history, nil
+}
+
+func runLog(file string, args []string) error {
+	fs := commandFlags("log")
+	verbose := fs.Bool("v", false, "decrypt each version and summarize the record changes")
+	fs.Parse(args)
+
+	vault, history, err := openHistory(file)
+	if err != nil {
+		return err
+	}
+	versions, err := history.Log()
+	if err != nil {
+		return err
+	}
+	source := onceSource(passwordSource())
+
+	for i, version := range versions {
+		fmt.Printf("%.12s %s %s\n", version.Rev, version.Time.Format("2006-01-02 15:04:05"), version.Subject)
+		if !*verbose {
+			continue
+		}
+
+		safe, err := openVersion(vault, history, version.Rev, source)
+		if err != nil {
+			fmt.Printf("    %v\n", err)
+			continue
+		}
+		older := &pwsafe.Safe{}
+		if i+1 < len(versions) {
+			if older, err = openVersion(vault, history, versions[i+1].Rev, source); err != nil {
+				older = &pwsafe.Safe{}
+			}
+		}
+		fmt.Printf("    Last saved %s by %s@%s\n", safe.Headers.LastSave.Format("2006-01-02 15:04:05"),
+			safe.Headers.User, safe.Headers.Host)
+		printChanges(pwsafe.Diff(older, safe))
+	}
+	return nil
+}
+
+func printChanges(changes []pwsafe.RecordChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Record.Ref() < changes[j].Record.Ref()
+	})
+	for _, change := range changes {
+		switch change.Kind {
+		case pwsafe.RecordAdded:
+			fmt.Printf("    + %s\n", change.Record.Ref())
+		case pwsafe.RecordRemoved:
+			fmt.Printf("    - %s\n", change.Record.Ref())
+		case pwsafe.RecordModified:
+			fmt.Printf("    ~ %s %v\n", change.Record.Ref(), change.Fields)
+		}
+	}
+}
+
+func openVersion(vault *pwsafe.Vault, history *pwsafe.GitHistory, rev string, source pwsafe.PasswordSource) (*pwsafe.Safe, error) {
+	data, err := history.Read(rev)
+	if err != nil {
+		return nil, err
+	}
+	return vault.OpenVersion(data, source)
+}
+
+func runShow(file string, args []string) error {
+	fs := commandFlags("show")
+	at := fs.String("at", "HEAD", "git `revision` of the safe")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	vault, history, err := openHistory(file)
+	if err != nil {
+		return err
+	}
+	safe, err := openVersion(vault, history, *at, passwordSource())
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		sort.Sort(ByGroupTitle(safe.Records))
+		for _, record := range safe.Records {
+			fmt.Println(record.Ref())
+		}
+		return nil
+	}
+	record, err := safe.Find(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	templates, err := availableTemplates(safe.Headers.Templates)
+	if err != nil {
+		return err
+	}
+	fmt.Println(getRecordDetail(*record, templates))
+	return nil
+}
+
+func runRestore(file string, args []string) error {
+	fs := commandFlags("restore")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	return vault.Restore(fs.Arg(0), passwordSource())
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"pwsafe"
+)
+
+// Formats read by the import command
+var importFormats map[string]*command
+
+func init() {
+	importFormats = map[string]*command{
+		"bitwarden": {"[-fields list] [-filter field=value]... [-group group] [-duplicates skip|update|add] [-n] export.json", "an unencrypted Bitwarden JSON export", runImportBitwarden},
+		"csv":       {"[-preset name | -map spec] [-group group] [-duplicates skip|update|add] [-n] file.csv", "comma separated values from browsers and password managers", runImportCSV},
+		"kdbx":      {"[-fields list] [-filter field=value]... [-group group] [-duplicates skip|update|add] [-n] file.kdbx", "a KeePass 4 database", runImportKDBX},
+		"json":      {"[-fields list] [-filter field=value]... [-group group] [-duplicates skip|update|add] [-n] file.json", "the whole safe as JSON, exported with -reveal", runImportJSON},
+		"pass":      {"[-gpg program] [-group group] [-duplicates skip|update|add] [-n] [directory]", "a pass password store, decrypted with gpg", runImportPass},
+		"xml":       {"[-fields list] [-filter field=value]... [-group group] [-duplicates skip|update|add] [-n] file.xml", "the XML format of the Password Safe desktop client", runImportXML},
+		"yaml":      {"[-fields list] [-filter field=value]... [-group group] [-duplicates skip|update|add] [-n] file.yaml", "the whole safe as YAML, exported with -reveal", runImportYAML},
+	}
+}
+
+func runImport(file string, args []string) error {
+	return runFormat("import", importFormats, file, args)
+}
+
+// Run the subcommand of cmd named by the first argument
+func runFormat(cmd string, formats map[string]*command, file string, args []string) error {
+	if len(args) == 0 || formats[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] %s format [arguments]\n\nFormats:\n", os.Args[0], cmd)
+		names := make([]string, 0, len(formats))
+		for name := range formats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, formats[name].short)
+		}
+		os.Exit(2)
+	}
+	return formats[args[0]].run(file, args[1:])
+}
+
+// Returns a flag set for a format of the import or export command
+func formatFlags(cmd, format string, formats map[string]*command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd+" "+format, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] %s %s %s\n", os.Args[0], cmd, format, formats[format].args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Flags shared by all import formats
+type importOptions struct {
+	group      *string
+	duplicates *string
+	dryRun     *bool
+}
+
+func importFlags(fs *flag.FlagSet) importOptions {
+	return importOptions{
+		group:      fs.String("group", "", "put the imported records below `group`"),
+		duplicates: fs.String("duplicates", "skip", "what to do with records of the same group, title and username: skip, update or add"),
+		dryRun:     fs.Bool("n", false, "only report what would be imported"),
+	}
+}
+
+// Add the records of imported to the safe, print what was done with each
+// and save. Password policies and empty groups the safe lacks are added
+// as well. A safe that does not exist yet is created, taking the name,
+// description and templates of the imported one.
+func importRecords(file string, imported *pwsafe.Safe, opts importOptions) error {
+	records := imported.Records
+	dup, err := pwsafe.ParseDuplicatePolicy(*opts.duplicates)
+	if err != nil {
+		return err
+	}
+	if *opts.group != "" {
+		for i := range records {
+			if records[i].Group == "" {
+				records[i].Group = *opts.group
+			} else {
+				records[i].Group = *opts.group + "." + records[i].Group
+			}
+		}
+	}
+
+	vault, created, err := openImportVault(file, imported.Headers, *opts.dryRun)
+	if err != nil {
+		return err
+	}
+
+	headers := &vault.Safe.Headers
+	changed := created
+Policies:
+	for _, policy := range imported.Headers.PasswordPolicies {
+		for _, p := range headers.PasswordPolicies {
+			if p.Name == policy.Name {
+				continue Policies
+			}
+		}
+		fmt.Printf("add     password policy %s\n", policy.Name)
+		headers.PasswordPolicies = append(headers.PasswordPolicies, policy)
+		changed = true
+	}
+Groups:
+	for _, group := range imported.Headers.EmptyGroups {
+		for _, g := range headers.EmptyGroups {
+			if g == group {
+				continue Groups
+			}
+		}
+		headers.EmptyGroups = append(headers.EmptyGroups, group)
+		changed = true
+	}
+
+	counts := make(map[pwsafe.ImportAction]int)
+	for _, result := range vault.Safe.Import(records, dup) {
+		counts[result.Action]++
+		fmt.Printf("%-7s %s", result.Action, result.Record.Ref())
+		if result.Record.Username != "" {
+			fmt.Printf(" (%s)", result.Record.Username)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d added, %d updated, %d skipped\n",
+		counts[pwsafe.ImportAdded], counts[pwsafe.ImportUpdated], counts[pwsafe.ImportSkipped])
+
+	if *opts.dryRun {
+		fmt.Println("Dry run, nothing was saved")
+		return nil
+	}
+	if !changed && counts[pwsafe.ImportAdded]+counts[pwsafe.ImportUpdated] == 0 {
+		return nil
+	}
+	return vault.Save()
+}
+
+// Open the safe to import into, or start a new one if it does not exist
+func openImportVault(file string, imported pwsafe.Headers, dryRun bool) (vault *pwsafe.Vault, created bool, err error) {
+	storage, err := pwsafe.OpenStorage(file)
+	if err != nil {
+		return nil, false, err
+	}
+	if rev, err := storage.Stat(); err != nil {
+		return nil, false, err
+	} else if rev != "" {
+		vault, err := openVault(file)
+		return vault, false, err
+	}
+
+	fmt.Printf("create  %s\n", file)
+	safe := &pwsafe.Safe{Headers: pwsafe.Headers{
+		Name:        imported.Name,
+		Description: imported.Description,
+		Templates:   imported.Templates,
+	}}
+	if dryRun {
+		// Never saved, so it needs no key
+		return &pwsafe.Vault{Storage: storage, Safe: safe}, true, nil
+	}
+	password, err := getNewPassword(filepath.Base(file))
+	if err != nil {
+		return nil, false, err
+	}
+	if vault, err = pwsafe.NewVault(storage, password, safe); err != nil {
+		return nil, false, err
+	}
+	vault.OnHistoryError = reportHistoryError
+	return vault, true, nil
+}
+
+func runImportBitwarden(file string, args []string) error {
+	fs := formatFlags("import", "bitwarden", importFormats)
+	sel := selectionFlags(fs)
+	opts := importFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	imported, err := pwsafe.ReadBitwarden(f)
+	if err != nil {
+		return err
+	}
+	if imported.Records, err = sel.apply(imported.Records); err != nil {
+		return err
+	}
+	return importRecords(file, imported, opts)
+}
+
+func runImportCSV(file string, args []string) error {
+	fs := formatFlags("import", "csv", importFormats)
+	preset := fs.String("preset", "", "column mapping of a known export: chrome, firefox, lastpass or bitwarden")
+	spec := fs.String("map", "", "column mapping `spec` such as title=name,group=folder+subfolder,password=2")
+	opts := importFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*preset == "") == (*spec == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *preset != "" {
+		s, ok := pwsafe.CSVPresets[*preset]
+		if !ok {
+			return fmt.Errorf("unknown csv preset %q", *preset)
+		}
+		spec = &s
+	}
+	mapping, err := pwsafe.ParseCSVMapping(*spec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := pwsafe.ReadCSV(f, mapping)
+	if err != nil {
+		return err
+	}
+	return importRecords(file, &pwsafe.Safe{Records: records}, opts)
+}
+
+func runImportPass(file string, args []string) error {
+	fs := formatFlags("import", "pass", importFormats)
+	gpg := fs.String("gpg", "gpg", "gpg `program` used to decrypt the entries")
+	opts := importFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dir := fs.Arg(0)
+	if dir == "" {
+		if dir = os.Getenv("PASSWORD_STORE_DIR"); dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			dir = filepath.Join(home, ".password-store")
+		}
+	}
+	records, err := pwsafe.ReadPassStore(dir, *gpg)
+	if err != nil {
+		return err
+	}
+	return importRecords(file, &pwsafe.Safe{Records: records}, opts)
+}
+
+func runImportXML(file string, args []string) error {
+	fs := formatFlags("import", "xml", importFormats)
+	sel := selectionFlags(fs)
+	opts := importFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	imported, err := pwsafe.ReadXML(f)
+	if err != nil {
+		return err
+	}
+	if imported.Records, err = sel.apply(imported.Records); err != nil {
+		return err
+	}
+	return importRecords(file, imported, opts)
+}
+
+func runImportKDBX(file string, args []string) error {
+	fs := formatFlags("import", "kdbx", importFormats)
+	sel := selectionFlags(fs)
+	opts := importFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	password, err := getKDBXPassword(filepath.Base(fs.Arg(0)), false)
+	if err != nil {
+		return err
+	}
+	imported, err := readSafeFile(fs.Arg(0), password)
+	if err != nil {
+		return err
+	}
+	if imported.Records, err = sel.apply(imported.Records); err != nil {
+		return err
+	}
+	return importRecords(file, imported, opts)
+}
+
+func runImportJSON(file string, args []string) error {
+	return runImportDump("json", file, args)
+}
+
+func runImportYAML(file string, args []string) error {
+	return runImportDump("yaml", file, args)
+}
+
+func runImportDump(format, file string, args []string) error {
+	fs := formatFlags("import", format, importFormats)
+	sel := selectionFlags(fs)
+	opts := importFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var imported *pwsafe.Safe
+	if format == "json" {
+		imported, err = pwsafe.ReadJSON(f)
+	} else {
+		imported, err = pwsafe.ReadYAML(f)
+	}
+	if err != nil {
+		return err
+	}
+	if imported.Records, err = sel.apply(imported.Records); err != nil {
+		return err
+	}
+	return importRecords(file, imported, opts)
+}
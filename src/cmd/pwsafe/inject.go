@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/template"
+
+	"pwsafe"
+)
+
+func runInject(file string, args []string) error {
+	fs := commandFlags("inject")
+	input := fs.String("i", "", "template `file` (default stdin)")
+	output := fs.String("o", "", "output `file` (default stdout)")
+	mode := fs.String("mode", "0600", "octal permissions of the output file")
+	dryRun := fs.Bool("n", false, "list referenced records without rendering")
+	fs.Parse(args)
+
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %q", *mode)
+	}
+
+	var text []byte
+	name := *input
+	if name == "" {
+		name = "stdin"
+		text, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		text, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// Lookup is called for every pw placeholder. Errors abort rendering.
+	refs := make(map[string]error)
+	lookup := func(ref string) (string, error) {
+		value, err := resolveRef(store, ref)
+		if *dryRun {
+			record, field := pwsafe.ParseFieldRef(ref)
+			refs[record+"#"+field] = err
+			return "", nil
+		}
+		return value, err
+	}
+	funcs := template.FuncMap{
+		// {{ pw "Group/Title" "field" }}, the field defaults to the password
+		"pw": func(record string, field ...string) (string, error) {
+			ref := record
+			if len(field) > 0 {
+				ref += "#" + field[0]
+			}
+			return lookup(ref)
+		},
+		// {{ ref "Group/Title#field" }}
+		"ref": lookup,
+	}
+
+	tmpl, err := template.New(filepath.Base(name)).Funcs(funcs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		return printRefs(refs)
+	}
+	if *output == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return writeFileAtomic(*output, buf.Bytes(), os.FileMode(perm))
+}
+
+func printRefs(refs map[string]error) error {
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+
+	missing := 0
+	for _, ref := range names {
+		if err := refs[ref]; err != nil {
+			fmt.Printf("%s: %v\n", ref, err)
+			missing++
+		} else {
+			fmt.Printf("%s: ok\n", ref)
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d of %d references can not be resolved", missing, len(refs))
+	}
+	return nil
+}
+
+// Write data to a temporary file with the given permissions and rename it
+// over name, so readers never see a partly written or world readable file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
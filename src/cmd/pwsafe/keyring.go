@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pwsafe"
+)
+
+// Open the safe named by file, a path or storage URL.
+//
+// The key comes from the kernel keyring when cached there and still valid,
+// otherwise the master password is asked for.
+func openVault(file string) (*pwsafe.Vault, error) {
+	vault, err := unlockVault(file)
+	if err != nil {
+		return nil, err
+	}
+	vault.OnHistoryError = reportHistoryError
+	for _, problem := range vault.Safe.Headers.Problems {
+		log.Printf("%s: %s", file, problem)
+	}
+	return vault, nil
+}
+
+// A failed commit to the safe's git history does not fail the save
+func reportHistoryError(err error) {
+	log.Printf("history: %v", err)
+}
+
+func unlockVault(file string) (*pwsafe.Vault, error) {
+	storage, err := pwsafe.OpenStorage(file)
+	if err != nil {
+		return nil, err
+	}
+	if key, kerr := pwsafe.CachedKey(storage.String()); kerr == nil {
+		vault, verr := pwsafe.OpenVaultKey(storage, key)
+		if !errors.Is(verr, pwsafe.ErrKeyMismatch) {
+			return vault, verr
+		}
+		pwsafe.ForgetKey(storage.String())
+	}
+	pw, err := getPassword(filepath.Base(file))
+	if err != nil {
+		return nil, err
+	}
+	return pwsafe.OpenVault(storage, pw)
+}
+
+func runUnlock(file string, args []string) error {
+	fs := commandFlags("unlock")
+	timeout := fs.Duration("t", 15*time.Minute, "forget the key after `timeout`")
+	fs.Parse(args)
+
+	storage, err := pwsafe.OpenStorage(file)
+	if err != nil {
+		return err
+	}
+	pw, err := getPassword(filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	vault, err := pwsafe.OpenVault(storage, pw)
+	if err != nil {
+		return err
+	}
+	return pwsafe.CacheKey(vault.String(), vault.Key, *timeout)
+}
+
+func runLock(file string, args []string) error {
+	fs := commandFlags("lock")
+	fs.Parse(args)
+
+	if client, err := pwsafe.DialAgent(file); err == nil {
+		if lerr := client.Lock(); lerr != nil && !errors.Is(lerr, pwsafe.ErrAgentWrongFile) {
+			fmt.Fprintf(os.Stderr, "agent: %v\n", lerr)
+		}
+		client.Close()
+	}
+
+	storage, err := pwsafe.OpenStorage(file)
+	if err != nil {
+		return err
+	}
+	err = pwsafe.ForgetKey(storage.String())
+	if errors.Is(err, pwsafe.ErrNoCachedKey) {
+		return nil
+	}
+	return err
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -14,7 +18,6 @@ import (
 	"pwsafe"
 
 	"github.com/gizak/termui"
-	"github.com/howeyc/gopass"
 	"github.com/satori/go.uuid"
 )
 
@@ -30,14 +33,51 @@ func (b ByGroupTitle) Less(i, j int) bool {
 	return b[i].Group < b[j].Group
 }
 
+const (
+	commandHelp  = "Select record by typing the index number. Edit field by typing field marker. [v] QR code. [c] Copy field."
+	conflictHelp = "[r] Reload, discarding your changes  [m] Merge by record  [s] Save as new file  [Esc] Ignore"
+	copyHelp     = "Copy which field? Type its field marker, [o] for the two-factor code, [Esc] to cancel."
+	fieldHelp    = "Edit which template field? Type the letter after [f], [Esc] to cancel."
+)
+
+// Fields copied to the clipboard by their field marker
+var copyFields = map[rune]string{
+	'g': "group",
+	't': "title",
+	'u': "username",
+	'p': "password",
+	'n': "notes",
+	'r': "url",
+	'e': "email",
+	'o': "totp",
+}
+
 func main() {
 	pfile := flag.String("f", "", "psafe3 file")
+	readonly := flag.Bool("r", false, "open the safe read-only")
+	flag.Usage = usage
 	flag.Parse()
 
-	fmt.Printf("Password: ")
-	pw := gopass.GetPasswd()
+	if flag.NArg() > 0 {
+		runCommand(*pfile, flag.Args())
+		return
+	}
 
-	safe, err := pwsafe.ParseFile(*pfile, string(pw))
+	vault, err := openVault(*pfile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *readonly {
+		vault.ReadOnly = true
+	} else if lerr := vault.LockFile(); lerr != nil {
+		var locked *pwsafe.LockedError
+		if !errors.As(lerr, &locked) || !confirm(fmt.Sprintf("%v. Open read-only? [y/N] ", lerr)) {
+			log.Fatalln(lerr)
+		}
+		vault.ReadOnly = true
+	}
+	safe := vault.Safe
+	templates, err := availableTemplates(safe.Headers.Templates)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -55,14 +95,18 @@ func main() {
 	rightpar.Height = 2
 	rightpar.HasBorder = false
 
+	filename := filepath.Base(*pfile)
+	if vault.ReadOnly {
+		filename += " (read-only)"
+	}
 	leftpar := termui.NewPar(fmt.Sprintf("File Name: %s\nLast Program: %s",
-		filepath.Base(*pfile),
+		filename,
 		safe.Headers.ProgramSave))
 	leftpar.Height = 2
 	leftpar.HasBorder = false
 
 	recordlist := termui.NewList()
-	recordlist.Height = termui.TermHeight() - 5
+	recordlist.Height = termui.TermHeight() - 8
 	recordlist.Items = getRecordList(safe)
 	recordlist.Border.Label = fmt.Sprintf("Records (%d)", len(safe.Records))
 
@@ -70,18 +114,28 @@ func main() {
 	recorddetail.Height = recordlist.Height
 	recorddetail.Border.Label = "Record Information"
 
+	totpgauge := termui.NewGauge()
+	totpgauge.Height = 3
+	totpgauge.Border.Label = "Two-factor Code"
+	updateTOTPGauge(totpgauge, nil)
+
+	qrpopup := termui.NewPar("")
+
 	inputbox := termui.NewPar("")
 	inputbox.Height = 3
 	inputbox.Border.Label = "Input Box ([Enter] to save, [Esc] to cancel)"
 	inputrow := termui.NewRow(termui.NewCol(12, 0, inputbox))
 
-	commandinfo := termui.NewPar(strings.Join([]string{
-		"Select record by typing the index number. Edit field by typing field marker.",
-	}, "\n"))
+	commandinfo := termui.NewPar(commandHelp)
 	commandinfo.Height = 3
 	commandinfo.Border.Label = "Help"
 	commandrow := termui.NewRow(termui.NewCol(12, 0, commandinfo))
 
+	conflictinfo := termui.NewPar("")
+	conflictinfo.Height = 3
+	conflictinfo.Border.Label = "Safe changed on disk"
+	conflictrow := termui.NewRow(termui.NewCol(12, 0, conflictinfo))
+
 	termui.Body.AddRows(
 		termui.NewRow(
 			termui.NewCol(6, 0, leftpar),
@@ -92,6 +146,7 @@ func main() {
 			termui.NewCol(6, 0, recorddetail),
 		),
 		commandrow,
+		termui.NewRow(termui.NewCol(12, 0, totpgauge)),
 	)
 
 	termui.Body.Align()
@@ -99,20 +154,132 @@ func main() {
 
 	evt := termui.EventCh()
 
+	stopWatch := make(chan struct{})
+	changes := pwsafe.WatchStorage(vault.Storage, vault.Revision, 2*time.Second, stopWatch)
+	totpTick := time.NewTicker(time.Second)
+	defer totpTick.Stop()
+
+	// Called after the records were replaced by a reload or merge
+	refresh := func() {
+		sort.Sort(ByGroupTitle(safe.Records))
+		recordlist.Items = getRecordList(safe)
+		recordlist.Border.Label = fmt.Sprintf("Records (%d)", len(safe.Records))
+		rightpar.Text = fmt.Sprintf("Last Saved: %s\nLast Saved By %s @ %s",
+			safe.Headers.LastSave.Format("2006-01-02 15:04:05"),
+			safe.Headers.User, safe.Headers.Host)
+	}
+
 	inputMode := false
+	conflictMode := false
+	qrMode := false
+	copyMode := false
+	fieldMode := false
+	templateMode := false
+	noteTextMode := false
+	saveAsMode := false
+	var saveAsName string
 	valBuffer := bytes.Buffer{}
 	numBuffer := bytes.Buffer{}
 	var selRecord *pwsafe.Record
 	var selField *string
+	// Template fields and the free text of templated notes are edited in
+	// noteValue and written back to the notes on [Enter]
+	var selNoteKey, noteValue string
 	var inputPrompt string
 	var startIndex int
 Main:
 	for {
 		select {
+		case <-totpTick.C:
+			if selRecord != nil && selRecord.HasTOTP() && !qrMode {
+				updateTOTPGauge(totpgauge, selRecord)
+				termui.Render(termui.Body)
+			}
+		case rev := <-changes:
+			if rev == vault.Revision || vault.ReadOnly {
+				continue
+			}
+			if changed, _ := vault.Changed(); !changed {
+				// Touched but not modified
+				vault.Revision = rev
+				continue
+			}
+			conflictMode = true
+			conflictinfo.Text = conflictHelp
 		case e := <-evt:
-			if !inputMode && e.Type == termui.EventKey {
+			if qrMode && e.Type == termui.EventKey {
+				// Any key closes the popup and the next render clears it
+				qrMode = false
+				qrpopup.Text = ""
+			} else if copyMode && e.Type == termui.EventKey {
+				copyMode = false
+				commandinfo.Text = commandHelp
+				if field, ok := copyFields[e.Ch]; ok {
+					value, err := clipValue(*selRecord, field)
+					if err == nil {
+						err = copyToClipboard(value)
+					}
+					if err != nil {
+						commandinfo.Text = err.Error()
+					} else {
+						commandinfo.Text = clipMessage(field, selRecord.Ref())
+					}
+				}
+			} else if fieldMode && e.Type == termui.EventKey {
+				fieldMode = false
+				commandinfo.Text = commandHelp
+				fields := selRecord.TemplateFields(templates)
+				if i := strings.IndexRune(templateFieldKeys, e.Ch); i >= 0 && i < len(fields) {
+					selNoteKey = fields[i].Key
+					noteValue = fields[i].Value
+					selField = &noteValue
+					inputPrompt = selNoteKey + ": "
+					inputMode = true
+					valBuffer.WriteString(noteValue)
+					inputbox.Text = inputPrompt + valBuffer.String()
+				}
+			} else if conflictMode && !inputMode && e.Type == termui.EventKey {
+				switch {
+				case e.Ch == 'r' || e.Ch == 'm':
+					var conflicts []pwsafe.Record
+					var err error
+					if e.Ch == 'r' {
+						err = vault.Reload()
+					} else {
+						conflicts, err = vault.Merge()
+					}
+					if err != nil {
+						conflictinfo.Text = fmt.Sprintf("%v [Esc]", err)
+						break
+					}
+					safe = vault.Safe
+					selRecord = nil
+					selField = nil
+					startIndex = 0
+					refresh()
+					conflictMode = len(conflicts) > 0
+					conflictinfo.Text = fmt.Sprintf("%d records changed on both sides were kept as \"(conflict)\" copies [Esc]", len(conflicts))
+				case e.Ch == 's':
+					saveAsMode = true
+					selField = &saveAsName
+					inputPrompt = "Save as: "
+					inputMode = true
+					inputbox.Text = inputPrompt
+				case e.Key == termui.KeyEsc:
+					conflictMode = false
+				}
+			} else if !inputMode && e.Type == termui.EventKey {
+				commandinfo.Text = commandHelp
 				switch e.Ch {
 				case 'q':
+					if vault.ReadOnly {
+						break Main
+					}
+					if changed, err := vault.Changed(); err == nil && changed {
+						conflictMode = true
+						conflictinfo.Text = conflictHelp
+						break
+					}
 					break Main
 				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 					numBuffer.WriteRune(e.Ch)
@@ -141,6 +308,31 @@ Main:
 					rlist := getRecordList(safe)
 					recordlist.Items = rlist[startIndex:]
 					recordlist.Border.Label = fmt.Sprintf("Records (%d)", len(safe.Records))
+				case 'c':
+					if selRecord != nil {
+						copyMode = true
+						commandinfo.Text = copyHelp
+					}
+				case 'v':
+					if selRecord != nil {
+						updateQRPopup(qrpopup, *selRecord)
+						qrMode = true
+					}
+				case 'f':
+					if selRecord != nil && len(selRecord.TemplateFields(templates)) > 0 {
+						fieldMode = true
+						commandinfo.Text = fieldHelp
+					}
+				case 'm':
+					if selRecord != nil {
+						noteValue = selRecord.Template()
+						selField = &noteValue
+						templateMode = true
+						inputPrompt = "Template: "
+						inputMode = true
+						valBuffer.WriteString(noteValue)
+						inputbox.Text = inputPrompt + valBuffer.String()
+					}
 				case 'g':
 					selField = &selRecord.Group
 					inputPrompt = "Group: "
@@ -173,9 +365,14 @@ Main:
 					inputbox.Text = inputPrompt + valBuffer.String()
 				case 'n':
 					selField = &selRecord.Notes
+					if selRecord.Template() != "" {
+						noteValue, _ = pwsafe.ParseNoteFields(selRecord.Notes)
+						selField = &noteValue
+						noteTextMode = true
+					}
 					inputPrompt = "Notes: "
 					inputMode = true
-					valBuffer.WriteString(selRecord.Notes)
+					valBuffer.WriteString(*selField)
 					inputbox.Text = inputPrompt + valBuffer.String()
 				case 'e':
 					selField = &selRecord.Email
@@ -186,18 +383,70 @@ Main:
 				}
 			} else if inputMode && e.Type == termui.EventKey {
 				if e.Key == termui.KeyEnter {
+					var old pwsafe.Record
+					if selRecord != nil {
+						old = *selRecord
+					}
 					if selField != nil {
 						*selField = valBuffer.String()
 					}
+					if selNoteKey != "" {
+						selRecord.Notes = pwsafe.SetNoteField(selRecord.Notes, selNoteKey, noteValue)
+						selNoteKey = ""
+						selField = nil
+					} else if noteTextMode {
+						_, fields := pwsafe.ParseNoteFields(selRecord.Notes)
+						selRecord.Notes = pwsafe.FormatNoteFields(noteValue, fields)
+						noteTextMode = false
+						selField = nil
+					} else if templateMode {
+						templateMode = false
+						selField = nil
+						if t, err := pwsafe.FindTemplate(templates, noteValue); err != nil {
+							commandinfo.Text = err.Error()
+						} else {
+							selRecord.ApplyTemplate(t)
+						}
+					}
+					if selRecord != nil && !saveAsMode && len(pwsafe.ChangedFields(old, *selRecord)) > 0 {
+						selRecord.MarkEdited(old, time.Now())
+					}
 					valBuffer.Reset()
 					inputMode = false
 					inputbox.Text = ""
 					rlist := getRecordList(safe)
 					recordlist.Items = rlist[startIndex:]
+					if saveAsMode {
+						saveAsMode = false
+						selField = nil
+						storage, err := pwsafe.OpenStorage(saveAsName)
+						if err == nil {
+							err = vault.SaveAs(storage)
+						}
+						if err != nil {
+							conflictinfo.Text = fmt.Sprintf("%v [Esc]", err)
+						} else {
+							conflictMode = false
+							*pfile = saveAsName
+							leftpar.Text = fmt.Sprintf("File Name: %s\nLast Program: %s",
+								filepath.Base(saveAsName), safe.Headers.ProgramSave)
+							refresh()
+							close(stopWatch)
+							stopWatch = make(chan struct{})
+							changes = pwsafe.WatchStorage(vault.Storage, vault.Revision, 2*time.Second, stopWatch)
+						}
+					}
 				} else if e.Key == termui.KeyEsc {
 					valBuffer.Reset()
 					inputMode = false
 					inputbox.Text = ""
+					selNoteKey = ""
+					noteTextMode = false
+					templateMode = false
+					if saveAsMode {
+						saveAsMode = false
+						selField = nil
+					}
 				} else if e.Key == termui.KeySpace {
 					valBuffer.WriteRune(' ')
 				} else if e.Key == termui.KeyBackspace || e.Ch == '' {
@@ -219,38 +468,116 @@ Main:
 			}
 
 			if selRecord != nil {
-				recorddetail.Text = getRecordDetail(*selRecord)
+				recorddetail.Text = getRecordDetail(*selRecord, templates)
 			}
+			updateTOTPGauge(totpgauge, selRecord)
 
 			if inputMode {
 				termui.Body.Rows[2] = inputrow
+			} else if conflictMode {
+				termui.Body.Rows[2] = conflictrow
 			} else {
 				termui.Body.Rows[2] = commandrow
 			}
 			termui.Body.Align()
-			termui.Render(termui.Body)
+			if qrMode {
+				termui.Render(termui.Body, qrpopup)
+			} else {
+				termui.Render(termui.Body)
+			}
 		}
 	}
 
-	oerr := pwsafe.OutputFile(*pfile, string(pw), *safe)
+	termui.Close()
+	close(stopWatch)
+
+	if vault.ReadOnly {
+		return
+	}
+	oerr := vault.Save()
+	if oerr == pwsafe.ErrRevisionChanged {
+		// Keep the edits in a copy rather than losing them
+		conflict, cerr := saveConflictCopy(vault)
+		vault.UnlockFile()
+		if cerr != nil {
+			log.Fatalf("%v, and the changes could not be saved elsewhere: %v\n", oerr, cerr)
+		}
+		log.Fatalf("%v, changes saved to %s instead\n", oerr, conflict)
+	}
+	vault.UnlockFile()
 	if oerr != nil {
 		log.Fatalln(oerr)
 	}
 
 }
 
-func getRecordDetail(record pwsafe.Record) string {
-	return strings.Join([]string{
+// Save the vault to a new file next to its safe, or in the temporary
+// directory if that fails, and return the file's name. Safes that are not
+// local files are copied to the working directory.
+func saveConflictCopy(vault *pwsafe.Vault) (string, error) {
+	dir, base := ".", path.Base(vault.Storage.String())
+	if file, ok := vault.Storage.(pwsafe.FileStorage); ok {
+		dir, base = filepath.Split(string(file))
+	}
+	prefix := strings.TrimSuffix(base, ".psafe3") + ".conflict-" + time.Now().Format("20060102-150405")
+
+	var err error
+	for _, dir := range []string{dir, os.TempDir()} {
+		for n := 1; n <= 100; n++ {
+			name := prefix + ".psafe3"
+			if n > 1 {
+				name = fmt.Sprintf("%s-%d.psafe3", prefix, n)
+			}
+			name = filepath.Join(dir, name)
+			err = vault.SaveAs(pwsafe.FileStorage(name))
+			if err == nil {
+				return name, nil
+			}
+			var locked *pwsafe.LockedError
+			if !errors.Is(err, os.ErrExist) && err != pwsafe.ErrRevisionChanged && !errors.As(err, &locked) {
+				// Not a name taken by another file, try the next directory
+				break
+			}
+		}
+	}
+	return "", err
+}
+
+// Ask a yes or no question on the terminal
+func confirm(prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+func getRecordDetail(record pwsafe.Record, templates []pwsafe.Template) string {
+	// The fields of templated records are listed on their own
+	notes := record.Notes
+	fields := record.TemplateFields(templates)
+	if record.Template() != "" {
+		notes, _ = pwsafe.ParseNoteFields(record.Notes)
+	}
+	lines := []string{
 		fmt.Sprintf("    UUID: %v", record.UUID.String()),
 		fmt.Sprintf("[g] Group: %s", record.Group),
 		fmt.Sprintf("[t] Title: %s", record.Title),
 		fmt.Sprintf("[u] Username: %s", record.Username),
 		fmt.Sprintf("[p] Password: %s", record.Password),
-		fmt.Sprintf("[n] Notes: %s", record.Notes),
+		fmt.Sprintf("[n] Notes: %s", notes),
 		fmt.Sprintf("[r] Url: %s", record.Url),
 		fmt.Sprintf("[e] Email: %s", record.Email),
-		fmt.Sprintf("    Create Time: %s", record.CreationTime.Format("2006-01-02 15:04:05")),
-	}, "\n")
+		fmt.Sprintf("[m] Template: %s", record.Template()),
+	}
+	for i, f := range fields {
+		marker := "    "
+		if i < len(templateFieldKeys) {
+			marker = fmt.Sprintf("[f%c]", templateFieldKeys[i])
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s", marker, f.Key, f.Value))
+	}
+	lines = append(lines, fmt.Sprintf("    Create Time: %s", record.CreationTime.Format("2006-01-02 15:04:05")))
+	return strings.Join(lines, "\n")
 }
 
 func getRecordList(safe *pwsafe.Safe) []string {
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"pwsafe"
+
+	"github.com/howeyc/gopass"
+)
+
+// Environment variable consulted for the master password when set
+const passwordEnvVar = "PWSAFE_PASSWORD"
+
+var (
+	passwordFD   = flag.Int("password-fd", -1, "read master password from file descriptor `N`")
+	passwordFile = flag.String("password-file", "", "read master password from first line of `file`")
+	askPass      = flag.String("askpass", os.Getenv("PWSAFE_ASKPASS"), "ask for master password with external `program` (SSH_ASKPASS style)")
+	pinentry     = flag.String("pinentry", "", "ask for master password with pinentry `program`")
+
+	kdbxPasswordFD   = flag.Int("kdbx-password-fd", -1, "read the password of KeePass databases from file descriptor `N`")
+	kdbxPasswordFile = flag.String("kdbx-password-file", "", "read the password of KeePass databases from first line of `file`")
+)
+
+// Returns the password source selected on the command line.
+//
+// The order of preference is file descriptor, file, askpass, pinentry, environment
+// and finally the terminal.
+func passwordSource() pwsafe.PasswordSource {
+	switch {
+	case *passwordFD >= 0:
+		return pwsafe.PasswordFD(*passwordFD)
+	case *passwordFile != "":
+		return pwsafe.PasswordFile(*passwordFile)
+	case *askPass != "":
+		return pwsafe.AskPass(*askPass)
+	case *pinentry != "":
+		return pwsafe.Pinentry{Program: *pinentry, Title: "pwsafe"}
+	}
+	if _, ok := os.LookupEnv(passwordEnvVar); ok {
+		return pwsafe.PasswordEnv(passwordEnvVar)
+	}
+	return pwsafe.PasswordFunc(terminalPassword)
+}
+
+func terminalPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	return string(gopass.GetPasswd()), nil
+}
+
+// Ask for the master password of file
+func getPassword(file string) (string, error) {
+	return passwordSource().Password(fmt.Sprintf("Password for %s: ", file))
+}
+
+// Reports whether the password of KeePass databases has a source of its own
+func kdbxPasswordGiven() bool {
+	return *kdbxPasswordFD >= 0 || *kdbxPasswordFile != ""
+}
+
+// Ask for the password of a KeePass database, twice if the database is
+// being created. Without -kdbx-password-fd or -kdbx-password-file it must
+// be asked for interactively, since the other sources give the master
+// password of the safe.
+func getKDBXPassword(file string, create bool) (string, error) {
+	switch {
+	case *kdbxPasswordFD >= 0:
+		return pwsafe.PasswordFD(*kdbxPasswordFD).Password("")
+	case *kdbxPasswordFile != "":
+		return pwsafe.PasswordFile(*kdbxPasswordFile).Password("")
+	}
+	source := passwordSource()
+	if !interactive(source) {
+		return "", fmt.Errorf("give the password for %s with -kdbx-password-fd or -kdbx-password-file", file)
+	}
+	if create {
+		return confirmedPassword(source, file)
+	}
+	return source.Password(fmt.Sprintf("Password for %s: ", file))
+}
+
+// Ask for the master password of a safe being created, twice when it is
+// typed, since a mistake would lock its records away
+func getNewPassword(file string) (string, error) {
+	source := passwordSource()
+	if !interactive(source) {
+		return source.Password("")
+	}
+	return confirmedPassword(source, file)
+}
+
+// Reports whether the source asks someone for the password
+func interactive(source pwsafe.PasswordSource) bool {
+	switch source.(type) {
+	case pwsafe.PasswordFD, pwsafe.PasswordFile, pwsafe.PasswordEnv:
+		return false
+	}
+	return true
+}
+
+func confirmedPassword(source pwsafe.PasswordSource, file string) (string, error) {
+	password, err := source.Password(fmt.Sprintf("Password for %s: ", file))
+	if err != nil {
+		return "", err
+	}
+	again, err := source.Password(fmt.Sprintf("Repeat password for %s: ", file))
+	if err != nil {
+		return "", err
+	}
+	if again != password {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"pwsafe"
+
+	"github.com/gizak/termui"
+)
+
+// Modules of quiet zone drawn around QR codes
+const qrQuietZone = 2
+
+// The value of a record shown as QR code: the named field, or the
+// otpauth:// URI if the record has a two-factor key and the password
+// otherwise
+func qrValue(record pwsafe.Record, field string) (string, error) {
+	switch strings.ToLower(field) {
+	case "":
+		if record.HasTOTP() {
+			return record.OTPAuthURI(), nil
+		}
+		return record.Password, nil
+	case "totp", "otpauth":
+		if !record.HasTOTP() {
+			return "", fmt.Errorf("%s: %w", record.Ref(), pwsafe.ErrNoTwoFactorKey)
+		}
+		return record.OTPAuthURI(), nil
+	}
+	return record.Field(field)
+}
+
+// Draw a QR code with Unicode half blocks, two rows of modules per line.
+//
+// Light modules are drawn as blocks for terminals with a dark background,
+// dark modules if invert is set.
+func qrLines(code *pwsafe.QRCode, invert bool) []string {
+	light := func(x, y int) bool {
+		return code.Black(x, y) == invert
+	}
+	var lines []string
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		var line strings.Builder
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top := light(x, y)
+			bottom := light(x, y+1) && y+1 < code.Size+qrQuietZone
+			switch {
+			case top && bottom:
+				line.WriteRune('█')
+			case top:
+				line.WriteRune('▀')
+			case bottom:
+				line.WriteRune('▄')
+			default:
+				line.WriteRune(' ')
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// Fill the popup with the QR code of record, centred on the screen
+func updateQRPopup(p *termui.Par, record pwsafe.Record) {
+	p.Border.Label = "QR Code (any key to close)"
+	value, err := qrValue(record, "")
+	var code *pwsafe.QRCode
+	if err == nil {
+		code, err = pwsafe.EncodeQR([]byte(value), pwsafe.QRLevelL)
+	}
+	var lines []string
+	if err == nil {
+		lines = qrLines(code, false)
+		if len(lines)+2 > termui.TermHeight() || len([]rune(lines[0]))+2 > termui.TermWidth() {
+			lines, p.Border.Label = []string{"Terminal too small for the QR code"}, "QR Code"
+		}
+	} else {
+		lines = []string{err.Error()}
+	}
+	p.Text = strings.Join(lines, "\n")
+	p.Width = len([]rune(lines[0])) + 2
+	if p.Width < len(p.Border.Label)+4 {
+		p.Width = len(p.Border.Label) + 4
+	}
+	p.Height = len(lines) + 2
+	p.X = (termui.TermWidth() - p.Width) / 2
+	p.Y = (termui.TermHeight() - p.Height) / 2
+}
+
+func runQR(file string, args []string) error {
+	fs := commandFlags("qr")
+	invert := fs.Bool("invert", false, "draw for a terminal with a light background")
+	level := fs.String("level", "M", "error correction level, one of L, M, Q and H")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	l := strings.Index("LMQH", strings.ToUpper(*level))
+	if len(*level) != 1 || l < 0 {
+		return fmt.Errorf("unknown error correction level %q", *level)
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	record, err := store.Get(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	value, err := qrValue(record, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	code, err := pwsafe.EncodeQR([]byte(value), pwsafe.QRLevel(l))
+	if err != nil {
+		return err
+	}
+
+	lines := qrLines(code, *invert)
+	fmt.Println(strings.Join(lines, "\n"))
+	if !isTerminal(os.Stdout) || !isTerminal(os.Stdin) {
+		return nil
+	}
+	// Wipe the code from the screen once it was scanned
+	fmt.Print("Press Enter to clear")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Printf("\x1b[%dA\x1b[J", len(lines)+1)
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"pwsafe"
+)
+
+func runRotate(file string, args []string) error {
+	fs := commandFlags("rotate")
+	hook := fs.String("hook", "", "run `program` with the old and new password on stdin, one per line, and keep the new one only if it succeeds")
+	group := fs.String("group", "", "rotate every expired record in `group` and its subgroups")
+	within := daysFlag(0)
+	fs.Var(&within, "within", "with -group, also rotate passwords expiring within `duration`")
+	dryRun := fs.Bool("n", false, "only list the records that would be rotated")
+	fs.Parse(args)
+	if (*group == "") == (fs.NArg() == 0) || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	safe := vault.Safe
+
+	var due []*pwsafe.Record
+	if *group != "" {
+		now := time.Now()
+		for i := range safe.Records {
+			r := &safe.Records[i]
+			if r.Group != *group && !strings.HasPrefix(r.Group, *group+".") {
+				continue
+			}
+			if expiry := r.PasswordExpiry(); !expiry.IsZero() && expiry.Sub(now) <= time.Duration(within) {
+				due = append(due, r)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].Ref() < due[j].Ref() })
+	} else {
+		r, err := safe.Find(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		due = append(due, r)
+	}
+
+	failed := 0
+	for _, r := range due {
+		if *dryRun {
+			fmt.Println(r.Ref())
+			continue
+		}
+		if err := rotateRecord(vault, r, *hook); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.Ref(), err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: rotated\n", r.Ref())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rotations failed", failed, len(due))
+	}
+	return nil
+}
+
+// Give the record a new password from its policy and save the safe. The
+// hook must succeed first; if saving fails afterwards it is run again with
+// the passwords swapped to undo the change.
+func rotateRecord(vault *pwsafe.Vault, r *pwsafe.Record, hook string) error {
+	policy, symbols, err := vault.Safe.RecordPolicy(*r)
+	if err != nil {
+		return err
+	}
+	password, err := pwsafe.GeneratePassword(policy, symbols)
+	if err != nil {
+		return err
+	}
+	old := *r
+	if hook != "" {
+		if err := runRotateHook(hook, old, old.Password, password); err != nil {
+			return fmt.Errorf("hook failed, password not changed: %v", err)
+		}
+	}
+
+	r.ChangePassword(password, time.Now())
+	serr := vault.Save()
+	if serr == nil {
+		return nil
+	}
+	*r = old
+	if hook == "" {
+		return serr
+	}
+	if err := runRotateHook(hook, old, password, old.Password); err != nil {
+		return fmt.Errorf("%v; undoing the change with the hook failed too: %v", serr, err)
+	}
+	return fmt.Errorf("%v; the change was undone with the hook", serr)
+}
+
+// Run the hook with the old and new password on stdin and the record's
+// group, title, username and URL in its environment
+func runRotateHook(hook string, r pwsafe.Record, old, new string) error {
+	cmd := exec.Command(hook)
+	cmd.Stdin = strings.NewReader(old + "\n" + new + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PWSAFE_GROUP="+r.Group,
+		"PWSAFE_TITLE="+r.Title,
+		"PWSAFE_USERNAME="+r.Username,
+		"PWSAFE_URL="+r.Url,
+	)
+	return cmd.Run()
+}
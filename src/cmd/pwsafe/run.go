@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"pwsafe"
+)
+
+// A flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+func runRun(file string, args []string) error {
+	fs := commandFlags("run")
+	var envs, envFiles stringList
+	fs.Var(&envs, "env", "set `NAME=Group/Title#field` in the child environment (repeatable)")
+	fs.Var(&envFiles, "env-file", "read NAME=reference lines from `file` (repeatable)")
+	mask := fs.Bool("mask", false, "mask secret values in the child's stdout and stderr")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	mapping := make(map[string]string)
+	for _, name := range envFiles {
+		if err := readEnvFile(name, mapping); err != nil {
+			return err
+		}
+	}
+	for _, env := range envs {
+		if err := parseEnvMapping(env, mapping); err != nil {
+			return err
+		}
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	values, err := resolveRefs(store, mapping)
+	store.Close()
+	if err != nil {
+		return err
+	}
+
+	child := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	child.Env = os.Environ()
+	var secrets []string
+	for name, value := range values {
+		child.Env = append(child.Env, name+"="+value)
+		secrets = append(secrets, value)
+	}
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	var stdout, stderr *maskWriter
+	if *mask {
+		stdout = newMaskWriter(os.Stdout, secrets)
+		stderr = newMaskWriter(os.Stderr, secrets)
+		child.Stdout = stdout
+		child.Stderr = stderr
+	}
+
+	if err := child.Start(); err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			child.Process.Signal(sig)
+		}
+	}()
+	werr := child.Wait()
+	if *mask {
+		stdout.Close()
+		stderr.Close()
+	}
+	if exit, ok := werr.(*exec.ExitError); ok {
+		// Like shells, report death by a signal as 128 plus its number
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(exit.ExitCode())
+	}
+	return werr
+}
+
+// Parse one NAME=reference mapping
+func parseEnvMapping(s string, mapping map[string]string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return fmt.Errorf("invalid mapping %q, want NAME=Group/Title#field", s)
+	}
+	mapping[s[:i]] = s[i+1:]
+	return nil
+}
+
+// Read a .env style file of NAME=reference lines.
+//
+// Blank lines and lines starting with # are ignored. References may be quoted.
+func readEnvFile(name string, mapping map[string]string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return fmt.Errorf("%s:%d: invalid mapping", name, lineno)
+		}
+		key := strings.TrimSpace(line[:i])
+		ref := strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+		if err := parseEnvMapping(key+"="+ref, mapping); err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Look up "Group/Title#field" references, keyed by variable name
+func resolveRefs(store recordStore, mapping map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]string)
+	for _, name := range names {
+		value, err := resolveRef(store, mapping[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Look up the value of a "Group/Title#field" reference
+func resolveRef(store recordStore, ref string) (string, error) {
+	recordRef, field := pwsafe.ParseFieldRef(ref)
+	record, err := store.Get(recordRef)
+	if err != nil {
+		return "", err
+	}
+	return record.Field(field)
+}
+
+const maskText = "******"
+
+// A maskWriter replaces secrets in the data written through it.
+//
+// Output that could be the start of a secret is held back until more
+// data arrives or the writer is closed.
+type maskWriter struct {
+	w       io.Writer
+	secrets [][]byte
+	buf     []byte
+}
+
+func newMaskWriter(w io.Writer, secrets []string) *maskWriter {
+	m := &maskWriter{w: w}
+	for _, s := range secrets {
+		if s != "" {
+			m.secrets = append(m.secrets, []byte(s))
+		}
+	}
+	// Longest first, so a secret containing another is replaced whole
+	sort.SliceStable(m.secrets, func(i, j int) bool {
+		return len(m.secrets[i]) > len(m.secrets[j])
+	})
+	return m
+}
+
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	for _, s := range m.secrets {
+		m.buf = bytes.Replace(m.buf, s, []byte(maskText), -1)
+	}
+
+	hold := 0
+	for _, s := range m.secrets {
+		for k := len(s) - 1; k > hold; k-- {
+			if bytes.HasSuffix(m.buf, s[:k]) {
+				hold = k
+				break
+			}
+		}
+	}
+
+	out := m.buf[:len(m.buf)-hold]
+	if _, err := m.w.Write(out); err != nil {
+		return 0, err
+	}
+	m.buf = append([]byte(nil), m.buf[len(out):]...)
+	return len(p), nil
+}
+
+// Write out any held back data
+func (m *maskWriter) Close() error {
+	_, err := m.w.Write(m.buf)
+	m.buf = nil
+	return err
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"pwsafe"
+
+	"github.com/satori/go.uuid"
+)
+
+// An API token read from the tokens file
+type apiToken struct {
+	Name     string
+	Token    string
+	ReadOnly bool
+}
+
+// Serves the records of one open safe as JSON over HTTP
+type apiServer struct {
+	tokens []apiToken
+	audit  *log.Logger
+
+	mu      sync.Mutex
+	vault   *pwsafe.Vault
+	etagKey [32]byte
+}
+
+func runServe(file string, args []string) error {
+	fs := commandFlags("serve")
+	listen := fs.String("listen", "127.0.0.1:8731", "loopback `address` or unix:/path/to/socket")
+	tokensFile := fs.String("tokens", "", "`file` of \"name ro|rw token\" lines")
+	auditFile := fs.String("audit", "", "append request audit log to `file` (default stderr)")
+	fs.Parse(args)
+
+	if *tokensFile == "" {
+		return errors.New("serve: -tokens is required")
+	}
+	tokens, err := readTokens(*tokensFile)
+	if err != nil {
+		return err
+	}
+
+	auditOut := io.Writer(os.Stderr)
+	if *auditFile != "" {
+		f, ferr := os.OpenFile(*auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		auditOut = f
+	}
+
+	l, err := listenAPI(*listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+
+	s := &apiServer{
+		tokens: tokens,
+		audit:  log.New(auditOut, "", log.LstdFlags),
+		vault:  vault,
+	}
+	if _, err := rand.Read(s.etagKey[:]); err != nil {
+		return err
+	}
+
+	// Close the listener on signals, which also removes a unix socket
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	stopped := make(chan struct{})
+	go func() {
+		<-sigs
+		close(stopped)
+		l.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %s on %s\n", file, l.Addr())
+	err = http.Serve(l, s)
+	select {
+	case <-stopped:
+		return nil
+	default:
+		return err
+	}
+}
+
+// Listen on a unix socket or a loopback TCP address
+func listenAPI(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("serve: refusing to listen on non-loopback address %s", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Remove a socket left behind by a server that did not shut down cleanly
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("serve: %s is in use by a running server", path)
+	}
+	return os.Remove(path)
+}
+
+func readTokens(name string) ([]apiToken, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil && fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s: tokens file must not be accessible by group or others", name)
+	}
+
+	var tokens []apiToken
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 || (parts[1] != "ro" && parts[1] != "rw") {
+			return nil, fmt.Errorf("%s:%d: want \"name ro|rw token\"", name, lineno)
+		}
+		tokens = append(tokens, apiToken{Name: parts[0], ReadOnly: parts[1] == "ro", Token: parts[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", name)
+	}
+	return tokens, nil
+}
+
+// Returns the token presented as a bearer token, or nil
+func (s *apiServer) authenticate(r *http.Request) *apiToken {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	presented := []byte(strings.TrimPrefix(auth, "Bearer "))
+	var found *apiToken
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare(presented, []byte(s.tokens[i].Token)) == 1 {
+			found = &s.tokens[i]
+		}
+	}
+	return found
+}
+
+// Records the status code for the audit log
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (s *apiServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+	start := time.Now()
+	token := s.authenticate(r)
+	defer func() {
+		who := "-"
+		if token != nil {
+			who = token.Name
+		}
+		s.audit.Printf("%s %s %s %s %d %s", r.RemoteAddr, who, r.Method, r.URL.Path, w.status, time.Since(start))
+	}()
+
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pwsafe"`)
+		apiError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && token.ReadOnly {
+		apiError(w, http.StatusForbidden, "token is read-only")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// HEAD is answered like GET; net/http drops the body
+	method := r.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/v1/safe" && method == "GET":
+		s.writeJSON(w, http.StatusOK, s.vault.Safe.Headers)
+	case path == "/v1/records" && method == "GET":
+		s.listRecords(w, r)
+	case path == "/v1/records" && method == "POST":
+		s.createRecord(w, r)
+	case strings.HasPrefix(path, "/v1/records/"):
+		id, err := uuid.FromString(strings.TrimPrefix(path, "/v1/records/"))
+		if err != nil {
+			apiError(w, http.StatusNotFound, "invalid record id")
+			return
+		}
+		switch method {
+		case "GET":
+			record, err := s.vault.Safe.FindUUID(id)
+			if err != nil {
+				apiError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			s.writeJSON(w, http.StatusOK, record)
+		case "PUT":
+			s.updateRecord(w, r, id)
+		case "DELETE":
+			s.deleteRecord(w, r, id)
+		default:
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// GET /v1/records?q=text&group=name lists records without their passwords
+func (s *apiServer) listRecords(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(r.URL.Query().Get("q"))
+	group := r.URL.Query().Get("group")
+
+	records := make([]pwsafe.Record, 0)
+	for _, record := range s.vault.Safe.Records {
+		if group != "" && record.Group != group {
+			continue
+		}
+		if q != "" && !matchesQuery(record, q) {
+			continue
+		}
+		records = append(records, withoutSecrets(record))
+	}
+	s.writeJSON(w, http.StatusOK, records)
+}
+
+// The record without its password, old passwords and two-factor settings
+func withoutSecrets(record pwsafe.Record) pwsafe.Record {
+	record.Password = ""
+	record.PasswordHistory = nil
+	record.TwoFactorKey = nil
+	record.TOTPConfig = 0
+	record.TOTPLength = 0
+	record.TOTPTimeStep = 0
+	record.TOTPStartTime = time.Time{}
+	return record
+}
+
+func matchesQuery(record pwsafe.Record, q string) bool {
+	for _, field := range []string{record.Group, record.Title, record.Username, record.Url, record.Email} {
+		if strings.Contains(strings.ToLower(field), q) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *apiServer) createRecord(w http.ResponseWriter, r *http.Request) {
+	if !s.checkETag(w, r) {
+		return
+	}
+	var record pwsafe.Record
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if uuid.Equal(record.UUID, uuid.Nil) {
+		record.UUID = uuid.NewV4()
+	} else if _, err := s.vault.Safe.FindUUID(record.UUID); err == nil {
+		apiError(w, http.StatusConflict, "record already exists")
+		return
+	}
+	if record.CreationTime.IsZero() {
+		record.CreationTime = time.Now()
+	}
+
+	old := s.vault.Safe.Records
+	s.vault.Safe.Records = append(append([]pwsafe.Record(nil), old...), record)
+	if err := s.save(); err != nil {
+		s.vault.Safe.Records = old
+		saveError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, record)
+}
+
+func (s *apiServer) updateRecord(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	if !s.checkETag(w, r) {
+		return
+	}
+	existing, err := s.vault.Safe.FindUUID(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	// Fields missing from the body keep their value. The copy keeps the
+	// decoder from writing through to the history or unknown fields of the
+	// existing record.
+	record := existing.Copy()
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record.UUID = id
+	record.MarkEdited(*existing, time.Now())
+
+	old := *existing
+	*existing = record
+	if err := s.save(); err != nil {
+		*existing = old
+		saveError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, record)
+}
+
+func (s *apiServer) deleteRecord(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	if !s.checkETag(w, r) {
+		return
+	}
+	old := append([]pwsafe.Record(nil), s.vault.Safe.Records...)
+	if err := s.vault.Safe.Delete(id); err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err := s.save(); err != nil {
+		s.vault.Safe.Records = old
+		saveError(w, err)
+		return
+	}
+	w.Header().Set("ETag", s.etag())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) save() error {
+	return s.vault.Save()
+}
+
+// Read the safe again if something else changed it since it was read
+func (s *apiServer) reload() error {
+	changed, err := s.vault.Changed()
+	if err != nil || !changed {
+		return err
+	}
+	return s.vault.Reload()
+}
+
+// A safe changed by someone else between the reload and the save fails
+// the precondition like a stale ETag
+func saveError(w http.ResponseWriter, err error) {
+	if err == pwsafe.ErrRevisionChanged {
+		apiError(w, http.StatusPreconditionFailed, "safe was modified")
+		return
+	}
+	apiError(w, http.StatusInternalServerError, err.Error())
+}
+
+// The ETag is a keyed hash of the revision of the stored safe and the
+// whole safe. The key is random per server so the tag reveals nothing
+// about the records.
+func (s *apiServer) etag() string {
+	mac := hmac.New(sha256.New, s.etagKey[:])
+	io.WriteString(mac, string(s.vault.Revision))
+	json.NewEncoder(mac).Encode(s.vault.Safe)
+	return `"` + hex.EncodeToString(mac.Sum(nil)[:16]) + `"`
+}
+
+// Modifications must carry an If-Match header with the current ETag
+func (s *apiServer) checkETag(w http.ResponseWriter, r *http.Request) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		apiError(w, http.StatusPreconditionRequired, "If-Match header required")
+		return false
+	}
+	if match != "*" && match != s.etag() {
+		apiError(w, http.StatusPreconditionFailed, "safe was modified")
+		return false
+	}
+	return true
+}
+
+func (s *apiServer) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("ETag", s.etag())
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"pwsafe"
+
+	"github.com/satori/go.uuid"
+)
+
+// Access to the records of a safe for subcommands.
+//
+// A running agent serving the same file is used when available,
+// otherwise the file is opened directly.
+type recordStore interface {
+	List() ([]pwsafe.Record, error)
+	Get(ref string) (pwsafe.Record, error)
+	Add(record pwsafe.Record) error
+	Update(record pwsafe.Record) error
+	Templates() ([]pwsafe.Template, error)
+	Close() error
+}
+
+func openStore(file string) (recordStore, error) {
+	client, err := pwsafe.DialAgent(file)
+	if err == nil {
+		if _, lerr := client.List(); lerr == nil || errors.Is(lerr, pwsafe.ErrAgentLocked) {
+			return &agentStore{client}, nil
+		}
+		client.Close()
+	}
+	return openFileStore(file)
+}
+
+type agentStore struct {
+	client *pwsafe.AgentClient
+}
+
+// Call fn, unlocking the agent first if it has locked itself
+func (s *agentStore) do(fn func() error) error {
+	err := fn()
+	if !errors.Is(err, pwsafe.ErrAgentLocked) {
+		return err
+	}
+	pw, perr := getPassword(filepath.Base(s.client.File))
+	if perr != nil {
+		return perr
+	}
+	if uerr := s.client.Unlock(pw); uerr != nil {
+		return uerr
+	}
+	return fn()
+}
+
+func (s *agentStore) List() (records []pwsafe.Record, err error) {
+	err = s.do(func() error {
+		records, err = s.client.List()
+		return err
+	})
+	return
+}
+
+func (s *agentStore) Get(ref string) (record pwsafe.Record, err error) {
+	err = s.do(func() error {
+		record, err = s.client.Get(ref)
+		return err
+	})
+	return
+}
+
+func (s *agentStore) Add(record pwsafe.Record) error {
+	return s.do(func() error {
+		_, err := s.client.Add(record)
+		return err
+	})
+}
+
+func (s *agentStore) Update(record pwsafe.Record) error {
+	return s.do(func() error {
+		return s.client.Update(record)
+	})
+}
+
+func (s *agentStore) Templates() (templates []pwsafe.Template, err error) {
+	err = s.do(func() error {
+		templates, err = s.client.Templates()
+		return err
+	})
+	return
+}
+
+func (s *agentStore) Close() error {
+	return s.client.Close()
+}
+
+type fileStore struct {
+	vault *pwsafe.Vault
+}
+
+func openFileStore(file string) (*fileStore, error) {
+	vault, err := openVault(file)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{vault}, nil
+}
+
+func (s *fileStore) List() ([]pwsafe.Record, error) {
+	return s.vault.Safe.Records, nil
+}
+
+func (s *fileStore) Get(ref string) (pwsafe.Record, error) {
+	record, err := s.vault.Safe.Find(ref)
+	if err != nil {
+		return pwsafe.Record{}, err
+	}
+	return *record, nil
+}
+
+func (s *fileStore) Add(record pwsafe.Record) error {
+	if uuid.Equal(record.UUID, uuid.Nil) {
+		record.UUID = uuid.NewV4()
+	}
+	if record.CreationTime.IsZero() {
+		record.CreationTime = time.Now()
+	}
+	s.vault.Safe.Records = append(s.vault.Safe.Records, record)
+	return s.vault.Save()
+}
+
+func (s *fileStore) Update(record pwsafe.Record) error {
+	old, err := s.vault.Safe.FindUUID(record.UUID)
+	if err != nil {
+		return err
+	}
+	record.MarkEdited(*old, time.Now())
+	*old = record
+	return s.vault.Save()
+}
+
+func (s *fileStore) Templates() ([]pwsafe.Template, error) {
+	return s.vault.Safe.Headers.Templates, nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
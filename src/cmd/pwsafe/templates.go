@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"pwsafe"
+)
+
+var templatesFile = flag.String("templates", defaultTemplatesFile(), "read record templates from `file`, one \"Name: field, field\" line each")
+
+// Letters selecting template fields after [f] in the terminal UI
+const templateFieldKeys = "abcdefghijklmnopqrstuvwxyz"
+
+func defaultTemplatesFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pwsafe", "templates")
+}
+
+// The templates available for a safe: the built-in ones, those of the
+// templates file and those in the safe's header, each replacing templates
+// of the same name before it
+func availableTemplates(safeTemplates []pwsafe.Template) ([]pwsafe.Template, error) {
+	var fileTemplates []pwsafe.Template
+	if *templatesFile != "" {
+		data, err := ioutil.ReadFile(*templatesFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if fileTemplates, err = pwsafe.ParseTemplates(string(data)); err != nil {
+			return nil, fmt.Errorf("%s: %v", *templatesFile, err)
+		}
+	}
+	return pwsafe.MergeTemplates(pwsafe.BuiltinTemplates, fileTemplates, safeTemplates), nil
+}
+
+func runTemplate(file string, args []string) error {
+	fs := commandFlags("template")
+	set := fs.String("set", "", "store the template `definition`, such as \"Server: host, port\", in the safe")
+	del := fs.String("delete", "", "remove the `named` template from the safe")
+	fs.Parse(args)
+	if fs.NArg() != 0 || (*set != "" && *del != "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *set == "" && *del == "" {
+		store, err := openStore(file)
+		if err != nil {
+			return err
+		}
+		safeTemplates, err := store.Templates()
+		store.Close()
+		if err != nil {
+			return err
+		}
+		templates, err := availableTemplates(safeTemplates)
+		if err != nil {
+			return err
+		}
+		for _, t := range templates {
+			fmt.Println(t)
+		}
+		return nil
+	}
+
+	vault, err := openVault(file)
+	if err != nil {
+		return err
+	}
+	headers := &vault.Safe.Headers
+	if *set != "" {
+		templates, err := pwsafe.ParseTemplates(*set)
+		if err != nil {
+			return err
+		}
+		headers.Templates = pwsafe.MergeTemplates(headers.Templates, templates)
+	} else {
+		t, err := pwsafe.FindTemplate(headers.Templates, *del)
+		if err != nil {
+			return err
+		}
+		var kept []pwsafe.Template
+		for _, other := range headers.Templates {
+			if other.Name != t.Name {
+				kept = append(kept, other)
+			}
+		}
+		headers.Templates = kept
+	}
+	return vault.Save()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"pwsafe"
+
+	"github.com/gizak/termui"
+)
+
+// Show the current code of record and the time left until it changes
+func updateTOTPGauge(g *termui.Gauge, record *pwsafe.Record) {
+	if record == nil || !record.HasTOTP() {
+		g.Percent = 0
+		g.Label = "No two-factor key"
+		return
+	}
+	code, remaining, err := record.TOTP(time.Now())
+	if err != nil {
+		g.Percent = 0
+		g.Label = err.Error()
+		return
+	}
+	g.Percent = int(100 * remaining / record.TOTPPeriod())
+	g.Label = fmt.Sprintf("%s  %ds", code, int(remaining/time.Second))
+}
+
+func runTOTP(file string, args []string) error {
+	fs := commandFlags("totp")
+	set := fs.Bool("set", false, "set the two-factor key from an otpauth:// URI or base32 secret read from stdin")
+	uri := fs.Bool("uri", false, "print the otpauth:// URI instead of the code")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store, err := openStore(file)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	record, err := store.Get(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *set {
+		value, err := readValue("otpauth URI or secret: ")
+		if err != nil {
+			return err
+		}
+		if err := record.SetOTPAuth(value); err != nil {
+			return err
+		}
+		return store.Update(record)
+	}
+	if *uri {
+		if !record.HasTOTP() {
+			return fmt.Errorf("%s: no two-factor key", record.Ref())
+		}
+		fmt.Println(record.OTPAuthURI())
+		return nil
+	}
+
+	code, remaining, err := record.TOTP(time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", record.Ref(), err)
+	}
+	fmt.Println(code)
+	if isTerminal(os.Stderr) {
+		fmt.Fprintf(os.Stderr, "valid for %ds\n", int(remaining/time.Second))
+	}
+	return nil
+}
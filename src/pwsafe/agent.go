@@ -0,0 +1,435 @@
+package pwsafe
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Environment variables used to find a running agent
+const (
+	AgentSockEnv  = "PWSAFE_AUTH_SOCK"
+	AgentTokenEnv = "PWSAFE_AUTH_TOKEN"
+)
+
+// Agent operations
+const (
+	AgentOpList      = "list"
+	AgentOpGet       = "get"
+	AgentOpAdd       = "add"
+	AgentOpUpdate    = "update"
+	AgentOpDelete    = "delete"
+	AgentOpUnlock    = "unlock"
+	AgentOpLock      = "lock"
+	AgentOpTemplates = "templates"
+)
+
+var (
+	ErrNoAgent        = errors.New("no agent running")
+	ErrAgentLocked    = errors.New("agent is locked")
+	ErrAgentAuth      = errors.New("agent authentication failed")
+	ErrAgentWrongFile = errors.New("agent serves a different safe")
+)
+
+// Error codes used on the wire for errors callers may want to match
+var agentErrors = map[string]error{
+	"locked":    ErrAgentLocked,
+	"auth":      ErrAgentAuth,
+	"file":      ErrAgentWrongFile,
+	"notfound":  ErrNotFound,
+	"ambiguous": ErrAmbiguous,
+	"password":  ErrInvalidPassword,
+}
+
+// A request sent to the agent, one JSON object per line
+type AgentRequest struct {
+	Token    string
+	Op       string
+	File     string
+	Ref      string  `json:",omitempty"`
+	Record   *Record `json:",omitempty"`
+	Password string  `json:",omitempty"`
+}
+
+// The agent's reply to an AgentRequest
+type AgentResponse struct {
+	Error     string     `json:",omitempty"`
+	Code      string     `json:",omitempty"`
+	Records   []Record   `json:",omitempty"`
+	Templates []Template `json:",omitempty"`
+}
+
+// An Agent holds an unlocked safe in memory and serves it to clients
+// over a Unix socket.
+//
+// Clients must run as the same user and present the agent's token.
+// The agent locks itself, forgetting the password and records, after
+// Timeout without requests or when Lock is called.
+type Agent struct {
+	File    string
+	Timeout time.Duration
+	Token   string
+
+	// Called when committing a save to the git history fails
+	OnHistoryError func(error)
+
+	mu      sync.Mutex
+	storage Storage
+	vault   *Vault
+	timer   *time.Timer
+}
+
+// Returns a new agent holding the safe in storage unlocked with password.
+func NewAgent(storage Storage, password string, timeout time.Duration) (*Agent, error) {
+	a := &Agent{File: storage.String(), Timeout: timeout, Token: os.Getenv(AgentTokenEnv), storage: storage}
+	if a.Token == "" {
+		a.Token = NewAgentToken()
+	}
+	if err := a.unlock(password); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Returns a new random token for authenticating agent clients
+func NewAgentToken() string {
+	var token [32]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(token[:])
+}
+
+// Create a Unix socket at path readable only by the current user
+func ListenAgent(path string) (*net.UnixListener, error) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Forget the password and records
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lock()
+}
+
+func (a *Agent) lock() {
+	a.vault = nil
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+func (a *Agent) unlock(password string) error {
+	vault, err := OpenVault(a.storage, password)
+	if err != nil {
+		return err
+	}
+	vault.OnHistoryError = func(err error) {
+		if a.OnHistoryError != nil {
+			a.OnHistoryError(err)
+		}
+	}
+	a.vault = vault
+	a.touch()
+	return nil
+}
+
+// Restart the idle timer
+func (a *Agent) touch() {
+	if a.Timeout <= 0 {
+		return
+	}
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.Timeout, a.Lock)
+		return
+	}
+	a.timer.Reset(a.Timeout)
+}
+
+// Accept connections on l until it is closed
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	if err := checkPeer(conn); err != nil {
+		log.Printf("agent: rejected connection: %v", err)
+		return
+	}
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req AgentRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp := a.handle(&req)
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		if resp.Code == "auth" {
+			return
+		}
+	}
+}
+
+func (a *Agent) handle(req *AgentRequest) *AgentResponse {
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(a.Token)) != 1 {
+		return agentError(ErrAgentAuth)
+	}
+	if req.File != a.File {
+		return agentError(ErrAgentWrongFile)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch req.Op {
+	case AgentOpLock:
+		a.lock()
+		return &AgentResponse{}
+	case AgentOpUnlock:
+		if err := a.unlock(req.Password); err != nil {
+			return agentError(err)
+		}
+		return &AgentResponse{}
+	}
+
+	if a.vault == nil {
+		return agentError(ErrAgentLocked)
+	}
+	a.touch()
+	safe := a.vault.Safe
+
+	switch req.Op {
+	case AgentOpList:
+		return &AgentResponse{Records: safe.Records}
+	case AgentOpTemplates:
+		return &AgentResponse{Templates: safe.Headers.Templates}
+	case AgentOpGet:
+		rec, err := safe.Find(req.Ref)
+		if err != nil {
+			return agentError(err)
+		}
+		return &AgentResponse{Records: []Record{*rec}}
+	case AgentOpAdd:
+		if req.Record == nil {
+			return agentError(errors.New("missing record"))
+		}
+		rec := *req.Record
+		if uuid.Equal(rec.UUID, uuid.Nil) {
+			rec.UUID = uuid.NewV4()
+		}
+		if rec.CreationTime.IsZero() {
+			rec.CreationTime = time.Now()
+		}
+		if _, err := safe.FindUUID(rec.UUID); err == nil {
+			return agentError(fmt.Errorf("record %s already exists", rec.UUID))
+		}
+		safe.Records = append(safe.Records, rec)
+		if err := a.save(); err != nil {
+			safe.Records = safe.Records[:len(safe.Records)-1]
+			return agentError(err)
+		}
+		return &AgentResponse{Records: []Record{rec}}
+	case AgentOpUpdate:
+		if req.Record == nil {
+			return agentError(errors.New("missing record"))
+		}
+		rec, err := safe.FindUUID(req.Record.UUID)
+		if err != nil {
+			return agentError(err)
+		}
+		old := *rec
+		*rec = *req.Record
+		rec.MarkEdited(old, time.Now())
+		if err := a.save(); err != nil {
+			*rec = old
+			return agentError(err)
+		}
+		return &AgentResponse{Records: []Record{*rec}}
+	case AgentOpDelete:
+		if req.Record == nil {
+			return agentError(errors.New("missing record"))
+		}
+		old := append([]Record(nil), safe.Records...)
+		if err := safe.Delete(req.Record.UUID); err != nil {
+			return agentError(err)
+		}
+		if err := a.save(); err != nil {
+			safe.Records = old
+			return agentError(err)
+		}
+		return &AgentResponse{}
+	}
+	return agentError(fmt.Errorf("unknown agent operation %q", req.Op))
+}
+
+func (a *Agent) save() error {
+	return a.vault.Save()
+}
+
+func agentError(err error) *AgentResponse {
+	resp := &AgentResponse{Error: err.Error()}
+	for code, e := range agentErrors {
+		if errors.Is(err, e) {
+			resp.Code = code
+		}
+	}
+	return resp
+}
+
+// An AgentClient talks to a running agent on behalf of one safe
+type AgentClient struct {
+	File string
+
+	conn  net.Conn
+	token string
+	enc   *json.Encoder
+	dec   *json.Decoder
+}
+
+// Connect to the agent named by the environment for the safe called name,
+// a path or storage URL.
+//
+// Returns ErrNoAgent when no agent is configured.
+func DialAgent(name string) (*AgentClient, error) {
+	sock := os.Getenv(AgentSockEnv)
+	token := os.Getenv(AgentTokenEnv)
+	if sock == "" || token == "" {
+		return nil, ErrNoAgent
+	}
+	storage, err := OpenStorage(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentClient{
+		File:  storage.String(),
+		conn:  conn,
+		token: token,
+		enc:   json.NewEncoder(conn),
+		dec:   json.NewDecoder(conn),
+	}, nil
+}
+
+func (c *AgentClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *AgentClient) call(req AgentRequest) ([]Record, error) {
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Records, nil
+}
+
+func (c *AgentClient) request(req AgentRequest) (*AgentResponse, error) {
+	req.Token = c.token
+	req.File = c.File
+	if err := c.enc.Encode(&req); err != nil {
+		return nil, err
+	}
+	var resp AgentResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		if e, ok := agentErrors[resp.Code]; ok {
+			return nil, fmt.Errorf("%w%s", e, strings.TrimPrefix(resp.Error, e.Error()))
+		}
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// All records in the safe
+func (c *AgentClient) List() ([]Record, error) {
+	return c.call(AgentRequest{Op: AgentOpList})
+}
+
+// The record templates kept in the safe's header
+func (c *AgentClient) Templates() ([]Template, error) {
+	resp, err := c.request(AgentRequest{Op: AgentOpTemplates})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Templates, nil
+}
+
+// The record matching a "Group/Title" reference
+func (c *AgentClient) Get(ref string) (Record, error) {
+	recs, err := c.call(AgentRequest{Op: AgentOpGet, Ref: ref})
+	if err != nil {
+		return Record{}, err
+	}
+	if len(recs) != 1 {
+		return Record{}, ErrNotFound
+	}
+	return recs[0], nil
+}
+
+// Add a new record and save the safe
+func (c *AgentClient) Add(rec Record) (Record, error) {
+	recs, err := c.call(AgentRequest{Op: AgentOpAdd, Record: &rec})
+	if err != nil {
+		return Record{}, err
+	}
+	return recs[0], nil
+}
+
+// Replace the record with the same UUID and save the safe
+func (c *AgentClient) Update(rec Record) error {
+	_, err := c.call(AgentRequest{Op: AgentOpUpdate, Record: &rec})
+	return err
+}
+
+// Remove the record with the same UUID and save the safe
+func (c *AgentClient) Delete(rec Record) error {
+	_, err := c.call(AgentRequest{Op: AgentOpDelete, Record: &rec})
+	return err
+}
+
+// Unlock a locked agent with the master password
+func (c *AgentClient) Unlock(password string) error {
+	_, err := c.call(AgentRequest{Op: AgentOpUnlock, Password: password})
+	return err
+}
+
+// Make the agent forget the password and records
+func (c *AgentClient) Lock() error {
+	_, err := c.call(AgentRequest{Op: AgentOpLock})
+	return err
+}
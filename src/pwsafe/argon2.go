@@ -0,0 +1,231 @@
+package pwsafe
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Argon2 (RFC 9106) in the d and id variants used by KeePass.
+//
+// golang.org/x/crypto/argon2 only offers Argon2i and Argon2id, while
+// KeePass databases are mostly protected with Argon2d.
+
+const (
+	argon2d  = 0
+	argon2id = 2
+
+	argon2Version    = 0x13
+	argon2SyncPoints = 4
+	argon2BlockWords = 128
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// Derive a keyLen byte key. Memory is in KiB.
+func argon2Key(mode int, password, salt, secret, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	if time < 1 {
+		time = 1
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	lanes := uint32(threads)
+
+	h := argon2H0(mode, password, salt, secret, data, time, memory, lanes, keyLen)
+
+	if memory < 2*argon2SyncPoints*lanes {
+		memory = 2 * argon2SyncPoints * lanes
+	}
+	memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+	columns := memory / lanes
+	segment := columns / argon2SyncPoints
+
+	B := make([]argon2Block, memory)
+	var buf [1024]byte
+	for lane := uint32(0); lane < lanes; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			argon2Hash(buf[:], h[:], le32(i), le32(lane))
+			for k := range B[lane*columns+i] {
+				B[lane*columns+i][k] = binary.LittleEndian.Uint64(buf[k*8:])
+			}
+		}
+	}
+
+	var addresses, input, zero argon2Block
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < lanes; lane++ {
+				independent := mode == argon2id && pass == 0 && slice < argon2SyncPoints/2
+				if independent {
+					input = argon2Block{uint64(pass), uint64(lane), uint64(slice), uint64(memory), uint64(time), uint64(mode)}
+				}
+				index := uint32(0)
+				if pass == 0 && slice == 0 {
+					index = 2
+					if independent {
+						argon2NextAddresses(&addresses, &input, &zero)
+					}
+				}
+				for ; index < segment; index++ {
+					offset := lane*columns + slice*segment + index
+					prev := offset - 1
+					if slice == 0 && index == 0 {
+						prev += columns
+					}
+
+					var random uint64
+					if independent {
+						if index%argon2BlockWords == 0 {
+							argon2NextAddresses(&addresses, &input, &zero)
+						}
+						random = addresses[index%argon2BlockWords]
+					} else {
+						random = B[prev][0]
+					}
+
+					refLane := uint32(random>>32) % lanes
+					if pass == 0 && slice == 0 {
+						refLane = lane
+					}
+					// Size and start of the area reference blocks are taken from
+					area, start := 3*segment, ((slice+1)%argon2SyncPoints)*segment
+					if pass == 0 {
+						area, start = slice*segment, 0
+					}
+					if refLane == lane {
+						area += index
+					}
+					if refLane == lane || index == 0 {
+						area--
+					}
+					x := (random & 0xffffffff) * (random & 0xffffffff) >> 32
+					y := uint64(area) * x >> 32
+					ref := refLane*columns + (start+area-1-uint32(y))%columns
+
+					argon2Compress(&B[offset], &B[prev], &B[ref], true)
+				}
+			}
+		}
+	}
+
+	last := B[columns-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		for k, v := range B[lane*columns+columns-1] {
+			last[k] ^= v
+		}
+	}
+	for k, v := range last {
+		binary.LittleEndian.PutUint64(buf[k*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, buf[:])
+	return key
+}
+
+func argon2H0(mode int, password, salt, secret, data []byte, time, memory, lanes, keyLen uint32) [blake2b.Size]byte {
+	h, _ := blake2b.New512(nil)
+	for _, v := range []uint32{lanes, keyLen, memory, time, argon2Version, uint32(mode)} {
+		h.Write(le32(v))
+	}
+	for _, b := range [][]byte{password, salt, secret, data} {
+		h.Write(le32(uint32(len(b))))
+		h.Write(b)
+	}
+	var h0 [blake2b.Size]byte
+	h.Sum(h0[:0])
+	return h0
+}
+
+// The variable length hash H' filling out from the concatenated inputs
+func argon2Hash(out []byte, in ...[]byte) {
+	if len(out) <= blake2b.Size {
+		h, _ := blake2b.New(len(out), nil)
+		h.Write(le32(uint32(len(out))))
+		for _, b := range in {
+			h.Write(b)
+		}
+		h.Sum(out[:0])
+		return
+	}
+
+	h, _ := blake2b.New512(nil)
+	h.Write(le32(uint32(len(out))))
+	for _, b := range in {
+		h.Write(b)
+	}
+	var v [blake2b.Size]byte
+	h.Sum(v[:0])
+	n := copy(out, v[:32])
+	for len(out)-n > blake2b.Size {
+		v = blake2b.Sum512(v[:])
+		n += copy(out[n:], v[:32])
+	}
+	h, _ = blake2b.New(len(out)-n, nil)
+	h.Write(v[:])
+	h.Sum(out[n:n])
+}
+
+func argon2NextAddresses(addresses, input, zero *argon2Block) {
+	input[6]++
+	argon2Compress(addresses, zero, input, false)
+	argon2Compress(addresses, zero, addresses, false)
+}
+
+// The compression function G, xored into out when xor is set
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, q argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	q = r
+	for i := 0; i < 8; i++ {
+		row := q[i*16 : i*16+16]
+		argon2Permute(&row[0], &row[1], &row[2], &row[3], &row[4], &row[5], &row[6], &row[7],
+			&row[8], &row[9], &row[10], &row[11], &row[12], &row[13], &row[14], &row[15])
+	}
+	for i := 0; i < 8; i++ {
+		c := i * 2
+		argon2Permute(&q[c], &q[c+1], &q[c+16], &q[c+17], &q[c+32], &q[c+33], &q[c+48], &q[c+49],
+			&q[c+64], &q[c+65], &q[c+80], &q[c+81], &q[c+96], &q[c+97], &q[c+112], &q[c+113])
+	}
+	for i := range out {
+		if xor {
+			out[i] ^= q[i] ^ r[i]
+		} else {
+			out[i] = q[i] ^ r[i]
+		}
+	}
+}
+
+func argon2Permute(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	argon2Mix(v0, v4, v8, v12)
+	argon2Mix(v1, v5, v9, v13)
+	argon2Mix(v2, v6, v10, v14)
+	argon2Mix(v3, v7, v11, v15)
+	argon2Mix(v0, v5, v10, v15)
+	argon2Mix(v1, v6, v11, v12)
+	argon2Mix(v2, v7, v8, v13)
+	argon2Mix(v3, v4, v9, v14)
+}
+
+func argon2Mix(a, b, c, d *uint64) {
+	fBlaMka := func(x, y uint64) uint64 {
+		return x + y + 2*(x&0xffffffff)*(y&0xffffffff)
+	}
+	*a = fBlaMka(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -32)
+	*c = fBlaMka(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -24)
+	*a = fBlaMka(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -16)
+	*c = fBlaMka(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -63)
+}
+
+func le32(v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return b[:]
+}
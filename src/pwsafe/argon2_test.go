@@ -0,0 +1,29 @@
+package pwsafe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The test vectors of RFC 9106, section 5
+func TestArgon2RFC9106(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+
+	for _, test := range []struct {
+		name string
+		mode int
+		tag  string
+	}{
+		{"argon2d", argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{"argon2id", argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	} {
+		tag := argon2Key(test.mode, password, salt, secret, data, 3, 32, 4, 32)
+		if got := hex.EncodeToString(tag); got != test.tag {
+			t.Errorf("%s = %s, want %s", test.name, got, test.tag)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package pwsafe
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/satori/go.uuid"
+)
+
+// Kind of problem found by an audit
+type AuditKind int
+
+const (
+	AuditReused AuditKind = iota
+	AuditWeak
+	AuditOld
+	AuditExpired
+	AuditExpiring
+	AuditEmptyPassword
+	AuditNoUsername
+)
+
+var auditKinds = []string{"reused", "weak", "old", "expired", "expiring", "empty-password", "no-username"}
+
+func (k AuditKind) String() string {
+	if int(k) < len(auditKinds) {
+		return auditKinds[k]
+	}
+	return "unknown"
+}
+
+func (k AuditKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// A problem with one record
+type AuditFinding struct {
+	Ref    string    `json:"record"`
+	UUID   uuid.UUID `json:"uuid"`
+	Kind   AuditKind `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// Thresholds of an audit
+type AuditOptions struct {
+	MinStrength int           // Passwords with fewer bits of entropy are weak
+	MaxAge      time.Duration // Older passwords are reported, 0 disables the check
+	Expiring    time.Duration // Report passwords expiring this soon
+	Now         time.Time     // Time to audit at, zero for now
+}
+
+// The options used by pwsafe audit without flags
+func DefaultAuditOptions() AuditOptions {
+	return AuditOptions{
+		MinStrength: 50,
+		MaxAge:      365 * 24 * time.Hour,
+		Expiring:    30 * 24 * time.Hour,
+	}
+}
+
+// Passwords too common to be worth more than a dictionary lookup
+var commonPasswords = map[string]bool{
+	"123456": true, "12345678": true, "123456789": true, "1234567890": true,
+	"password": true, "password1": true, "passw0rd": true, "qwerty": true,
+	"qwertyuiop": true, "abc123": true, "111111": true, "letmein": true,
+	"welcome": true, "monkey": true, "dragon": true, "iloveyou": true,
+	"admin": true, "changeme": true, "secret": true, "trustno1": true,
+}
+
+// Estimate the entropy of a password in bits.
+//
+// The estimate is the length times the bits per character of the
+// character classes used, where repeated characters and runs such as
+// "abc" or "321" only count one bit each. Common passwords count zero.
+func PasswordStrength(password string) int {
+	if commonPasswords[strings.ToLower(password)] {
+		return 0
+	}
+	var lower, upper, digit, symbol, other bool
+	for _, c := range password {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < unicode.MaxASCII && unicode.IsPrint(c):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	var prev rune
+	for i, c := range password {
+		if i > 0 && (c == prev || c == prev+1 || c == prev-1) {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = c
+	}
+	return int(bits)
+}
+
+// The time the record's password expires, zero if it does not.
+//
+// Without an expiry time the expiry interval counts from the last
+// password change.
+func (r Record) PasswordExpiry() time.Time {
+	if !r.ExpiryTime.IsZero() || r.ExpiryInterval <= 0 {
+		return r.ExpiryTime
+	}
+	changed := r.PasswordModTime
+	if changed.IsZero() {
+		changed = r.CreationTime
+	}
+	if changed.IsZero() {
+		return time.Time{}
+	}
+	return changed.AddDate(0, 0, r.ExpiryInterval)
+}
+
+// Check the records of a safe for reused, weak, old, expired and empty
+// passwords and for missing usernames.
+//
+// Records holding only notes are skipped. Findings are sorted by record
+// and kind.
+func Audit(safe *Safe, opts AuditOptions) []AuditFinding {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var findings []AuditFinding
+	add := func(r Record, kind AuditKind, format string, args ...interface{}) {
+		findings = append(findings, AuditFinding{r.Ref(), r.UUID, kind, fmt.Sprintf(format, args...)})
+	}
+
+	shared := make(map[[sha256.Size]byte][]Record)
+	for _, r := range safe.Records {
+		if r.Password != "" {
+			sum := sha256.Sum256([]byte(r.Password))
+			shared[sum] = append(shared[sum], r)
+		}
+	}
+
+	for _, r := range safe.Records {
+		if r.Password == "" && r.Username == "" && r.Url == "" && r.Email == "" && !r.HasTOTP() {
+			continue
+		}
+
+		if r.Password == "" {
+			add(r, AuditEmptyPassword, "no password")
+		} else {
+			if others := shared[sha256.Sum256([]byte(r.Password))]; len(others) > 1 {
+				var refs []string
+				for _, o := range others {
+					if o.UUID != r.UUID {
+						refs = append(refs, o.Ref())
+					}
+				}
+				add(r, AuditReused, "password also used by %s", strings.Join(refs, ", "))
+			}
+			if bits := PasswordStrength(r.Password); bits < opts.MinStrength {
+				add(r, AuditWeak, "password strength %d bits", bits)
+			}
+			changed := r.PasswordModTime
+			if changed.IsZero() {
+				changed = r.CreationTime
+			}
+			if opts.MaxAge > 0 && !changed.IsZero() && now.Sub(changed) > opts.MaxAge {
+				add(r, AuditOld, "password unchanged for %d days", int(now.Sub(changed).Hours()/24))
+			}
+		}
+
+		if expiry := r.PasswordExpiry(); !expiry.IsZero() {
+			if !expiry.After(now) {
+				add(r, AuditExpired, "password expired on %s", expiry.Format("2006-01-02"))
+			} else if expiry.Sub(now) <= opts.Expiring {
+				add(r, AuditExpiring, "password expires on %s", expiry.Format("2006-01-02"))
+			}
+		}
+
+		if r.Username == "" {
+			add(r, AuditNoUsername, "no username")
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Ref != findings[j].Ref {
+			return findings[i].Ref < findings[j].Ref
+		}
+		return findings[i].Kind < findings[j].Kind
+	})
+	return findings
+}
@@ -0,0 +1,109 @@
+package pwsafe
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	for _, test := range []struct {
+		password string
+		bits     int
+	}{
+		{"", 0},
+		{"password", 0},
+		{"PassWord", 0},
+		{"aaaa", 7},
+		{"abcd", 7},
+		{"dcba", 7},
+		{"zzzzzzzzzzzzzzzzzzzz", 23},
+		{"0000", 6},
+		{"azaz", 18},
+		{"aZ", 11},
+		{"x8#Kq2!vB7@mZ4$w", 105},
+	} {
+		if got := PasswordStrength(test.password); got != test.bits {
+			t.Errorf("PasswordStrength(%q) = %d, want %d", test.password, got, test.bits)
+		}
+	}
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	changed := created.AddDate(0, 1, 0)
+	fixed := created.AddDate(1, 0, 0)
+	for _, test := range []struct {
+		name   string
+		record Record
+		want   time.Time
+	}{
+		{"none", Record{CreationTime: created}, time.Time{}},
+		{"fixed", Record{ExpiryTime: fixed, ExpiryInterval: 10, PasswordModTime: changed}, fixed},
+		{"interval", Record{ExpiryInterval: 10, CreationTime: created, PasswordModTime: changed}, changed.AddDate(0, 0, 10)},
+		{"interval from creation", Record{ExpiryInterval: 10, CreationTime: created}, created.AddDate(0, 0, 10)},
+		{"interval without times", Record{ExpiryInterval: 10}, time.Time{}},
+	} {
+		if got := test.record.PasswordExpiry(); !got.Equal(test.want) {
+			t.Errorf("%s: expiry %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	days := func(n int) time.Time { return now.AddDate(0, 0, -n) }
+	shared := "shared Pw#1 long enough!"
+	record := func(title, username, password string, changed time.Time) Record {
+		return Record{UUID: uuid.NewV4(), Group: "G", Title: title, Username: username, Password: password, PasswordModTime: changed}
+	}
+	safe := &Safe{Records: []Record{
+		record("a", "bob", "x8#Kq2!vB7@mZ4$w", days(10)),
+		record("b", "bob", shared, days(400)),
+		record("c", "", shared, days(1)),
+		record("d", "bob", "password", days(20)),
+		record("e", "bob", "", time.Time{}),
+		{UUID: uuid.NewV4(), Group: "G", Title: "f", Notes: "only notes"},
+		record("g", "bob", "y9$Lr3?wC8%nA5&x", time.Time{}),
+	}}
+	safe.Records[2].ExpiryTime = days(2)
+	safe.Records[3].ExpiryInterval = 30
+	safe.Records[6].CreationTime = days(366)
+
+	var got []string
+	for _, f := range Audit(safe, AuditOptions{MinStrength: 50, MaxAge: 365 * 24 * time.Hour, Expiring: 30 * 24 * time.Hour, Now: now}) {
+		got = append(got, fmt.Sprintf("%s %s: %s", f.Ref, f.Kind, f.Detail))
+	}
+	want := []string{
+		"G/b reused: password also used by G/c",
+		"G/b old: password unchanged for 400 days",
+		"G/c reused: password also used by G/b",
+		"G/c expired: password expired on 2024-05-30",
+		"G/c no-username: no username",
+		"G/d weak: password strength 0 bits",
+		"G/d expiring: password expires on 2024-06-11",
+		"G/e empty-password: no password",
+		"G/g old: password unchanged for 366 days",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings:\n%q\nwant\n%q", got, want)
+	}
+
+	// Without a maximum age or an expiry window those checks are off
+	got = nil
+	for _, f := range Audit(safe, AuditOptions{Now: now}) {
+		if f.Kind == AuditOld || f.Kind == AuditExpiring || f.Kind == AuditWeak {
+			got = append(got, fmt.Sprintf("%s %s", f.Ref, f.Kind))
+		}
+	}
+	if len(got) != 0 {
+		t.Errorf("findings with checks off: %v", got)
+	}
+
+	if AuditKind(len(auditKinds)).String() != "unknown" {
+		t.Errorf("kind beyond the list = %s, want unknown", AuditKind(len(auditKinds)))
+	}
+}
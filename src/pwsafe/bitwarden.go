@@ -0,0 +1,330 @@
+package pwsafe
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Unencrypted Bitwarden JSON exports.
+//
+// Folders become groups, with "/" separating the levels of nested
+// folders. The first URI of a login is the record's URL and the others
+// are kept as "uri" note fields, as are custom fields. TOTP secrets go to
+// the two-factor fields. Cards and identities become records whose notes hold
+// their fields and a "template" field naming the item type.
+
+var ErrBitwardenEncrypted = errors.New("encrypted Bitwarden exports are not supported")
+
+// Bitwarden item types
+const (
+	bitwardenLogin      = 1
+	bitwardenSecureNote = 2
+	bitwardenCard       = 3
+	bitwardenIdentity   = 4
+)
+
+// Names of the templates of imported cards and identities
+const (
+	CardTemplate     = "Card"
+	IdentityTemplate = "Identity"
+)
+
+type bitwardenExport struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	ID              string                 `json:"id"`
+	FolderID        *string                `json:"folderId"`
+	Type            int                    `json:"type"`
+	Reprompt        int                    `json:"reprompt"`
+	Name            string                 `json:"name"`
+	Notes           *string                `json:"notes"`
+	Favorite        bool                   `json:"favorite"`
+	Fields          []bitwardenField       `json:"fields,omitempty"`
+	Login           *bitwardenLoginData    `json:"login,omitempty"`
+	SecureNote      *bitwardenSecureNoteOf `json:"secureNote,omitempty"`
+	Card            map[string]*string     `json:"card,omitempty"`
+	Identity        map[string]*string     `json:"identity,omitempty"`
+	PasswordHistory []bitwardenPassword    `json:"passwordHistory,omitempty"`
+	RevisionDate    *time.Time             `json:"revisionDate,omitempty"`
+	CreationDate    *time.Time             `json:"creationDate,omitempty"`
+}
+
+type bitwardenField struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"`
+	Type  int     `json:"type"`
+}
+
+type bitwardenLoginData struct {
+	URIs     []bitwardenURI `json:"uris,omitempty"`
+	Username *string        `json:"username"`
+	Password *string        `json:"password"`
+	TOTP     *string        `json:"totp"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+type bitwardenSecureNoteOf struct {
+	Type int `json:"type"`
+}
+
+type bitwardenPassword struct {
+	LastUsedDate time.Time `json:"lastUsedDate"`
+	Password     string    `json:"password"`
+}
+
+// The fields of cards and identities, in the order Bitwarden shows them
+var (
+	bitwardenCardFields = []string{"cardholderName", "brand", "number", "expMonth", "expYear", "code"}
+
+	bitwardenIdentityFields = []string{"title", "firstName", "middleName", "lastName",
+		"address1", "address2", "address3", "city", "state", "postalCode", "country",
+		"company", "email", "phone", "ssn", "username", "passportNumber", "licenseNumber"}
+)
+
+func deref(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Read an unencrypted Bitwarden JSON export
+func ReadBitwarden(r io.Reader) (*Safe, error) {
+	var export bitwardenExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+	if export.Encrypted {
+		return nil, ErrBitwardenEncrypted
+	}
+
+	folders := make(map[string]string)
+	safe := &Safe{}
+	for _, folder := range export.Folders {
+		folders[folder.ID] = joinGroup(strings.Split(folder.Name, "/"))
+	}
+	used := make(map[string]bool)
+
+	for _, item := range export.Items {
+		record := Record{
+			Group: folders[deref(item.FolderID)],
+			Title: item.Name,
+		}
+		if id, err := uuid.FromString(item.ID); err == nil {
+			record.UUID = id
+		} else {
+			record.UUID = uuid.NewV4()
+		}
+		if item.CreationDate != nil {
+			record.CreationTime = *item.CreationDate
+		}
+		if item.RevisionDate != nil {
+			record.ModTime = *item.RevisionDate
+		}
+		if record.CreationTime.IsZero() {
+			record.CreationTime = record.ModTime
+		}
+		record.PasswordModTime = record.CreationTime
+
+		var fields []NoteField
+		switch item.Type {
+		case bitwardenCard:
+			fields = bitwardenTemplateFields(CardTemplate, bitwardenCardFields, item.Card)
+		case bitwardenIdentity:
+			fields = bitwardenTemplateFields(IdentityTemplate, bitwardenIdentityFields, item.Identity)
+			record.Email = deref(item.Identity["email"])
+			record.Username = deref(item.Identity["username"])
+		}
+		if login := item.Login; login != nil {
+			record.Username = deref(login.Username)
+			record.Password = deref(login.Password)
+			for i, uri := range login.URIs {
+				if i == 0 {
+					record.Url = uri.URI
+				} else {
+					fields = append(fields, NoteField{"uri", uri.URI})
+				}
+			}
+			if totp := deref(login.TOTP); totp != "" {
+				if err := record.SetOTPAuth(totp); err != nil {
+					fields = append(fields, NoteField{"totp", totp})
+				}
+			}
+		}
+		for _, f := range item.Fields {
+			fields = append(fields, NoteField{f.Name, deref(f.Value)})
+		}
+		record.Notes = FormatNoteFields(deref(item.Notes), fields)
+
+		if len(item.PasswordHistory) > 0 {
+			history := &PasswordHistory{Enabled: true, Max: len(item.PasswordHistory)}
+			if history.Max < 3 {
+				history.Max = 3
+			}
+			// Bitwarden lists the most recent first
+			for i := len(item.PasswordHistory) - 1; i >= 0; i-- {
+				old := item.PasswordHistory[i]
+				history.Entries = append(history.Entries, PasswordHistoryEntry{old.LastUsedDate, old.Password})
+			}
+			record.PasswordHistory = history
+			record.PasswordModTime = item.PasswordHistory[0].LastUsedDate
+		}
+		safe.Records = append(safe.Records, record)
+		used[deref(item.FolderID)] = true
+	}
+	for _, folder := range export.Folders {
+		if !used[folder.ID] {
+			safe.Headers.EmptyGroups = append(safe.Headers.EmptyGroups, folders[folder.ID])
+		}
+	}
+	return safe, nil
+}
+
+func bitwardenTemplateFields(template string, names []string, values map[string]*string) []NoteField {
+	fields := []NoteField{{TemplateField, template}}
+	for _, name := range names {
+		if value := deref(values[name]); value != "" {
+			fields = append(fields, NoteField{name, value})
+		}
+	}
+	return fields
+}
+
+// Write the safe as an unencrypted Bitwarden JSON export
+func WriteBitwarden(w io.Writer, safe *Safe) error {
+	export := bitwardenExport{Folders: []bitwardenFolder{}, Items: []bitwardenItem{}}
+	folders := make(map[string]string)
+	folderID := func(group string) *string {
+		if group == "" {
+			return nil
+		}
+		if id, ok := folders[group]; ok {
+			return &id
+		}
+		id := uuid.NewV4().String()
+		folders[group] = id
+		export.Folders = append(export.Folders, bitwardenFolder{id, strings.Join(splitGroup(group), "/")})
+		return &id
+	}
+	for _, group := range safe.Headers.EmptyGroups {
+		folderID(group)
+	}
+
+	for _, record := range safe.Records {
+		item := bitwardenItem{
+			ID:       record.UUID.String(),
+			FolderID: folderID(record.Group),
+			Type:     bitwardenLogin,
+			Name:     record.Title,
+		}
+		if !record.CreationTime.IsZero() {
+			t := record.CreationTime.UTC()
+			item.CreationDate = &t
+		}
+		if modTime := record.ModTime; !modTime.IsZero() {
+			t := modTime.UTC()
+			item.RevisionDate = &t
+		}
+
+		text, fields := ParseNoteFields(record.Notes)
+		template := ""
+		for _, f := range fields {
+			if f.Key == TemplateField {
+				template = f.Value
+			}
+		}
+		var templateFields []string
+		switch template {
+		case CardTemplate:
+			item.Type, item.Card, templateFields = bitwardenCard, make(map[string]*string), bitwardenCardFields
+		case IdentityTemplate:
+			item.Type, item.Identity, templateFields = bitwardenIdentity, make(map[string]*string), bitwardenIdentityFields
+		default:
+			if record.Username == "" && record.Password == "" && record.Url == "" && record.Email == "" && !record.HasTOTP() {
+				item.Type, item.SecureNote = bitwardenSecureNote, &bitwardenSecureNoteOf{}
+				break
+			}
+			item.Login = &bitwardenLoginData{
+				Username: optional(record.Username),
+				Password: optional(record.Password),
+			}
+			if record.Url != "" {
+				item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: record.Url})
+			}
+			if record.HasTOTP() {
+				item.Login.TOTP = optional(record.OTPAuthURI())
+			}
+			if record.Email != "" {
+				fields = append(fields, NoteField{"email", record.Email})
+			}
+		}
+		if item.Identity != nil {
+			item.Identity["email"] = optional(record.Email)
+			item.Identity["username"] = optional(record.Username)
+		}
+
+	Fields:
+		for _, f := range fields {
+			switch {
+			case f.Key == TemplateField && template != "":
+				continue
+			case f.Key == "uri" && item.Login != nil:
+				item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: f.Value})
+				continue
+			case f.Key == "totp" && item.Login != nil:
+				item.Login.TOTP = optional(f.Value)
+				continue
+			}
+			for _, name := range templateFields {
+				if f.Key == name {
+					value := f.Value
+					if item.Card != nil {
+						item.Card[name] = &value
+					} else {
+						item.Identity[name] = &value
+					}
+					continue Fields
+				}
+			}
+			value := f.Value
+			item.Fields = append(item.Fields, bitwardenField{Name: f.Key, Value: &value})
+		}
+		item.Notes = optional(text)
+
+		if h := record.PasswordHistory; h != nil {
+			for i := len(h.Entries) - 1; i >= 0; i-- {
+				item.PasswordHistory = append(item.PasswordHistory, bitwardenPassword{h.Entries[i].Time.UTC(), h.Entries[i].Password})
+			}
+		}
+		export.Items = append(export.Items, item)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&export)
+}
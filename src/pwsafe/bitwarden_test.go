@@ -0,0 +1,129 @@
+package pwsafe
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestBitwardenRoundTrip(t *testing.T) {
+	created := time.Unix(1500000000, 0)
+	changed := created.Add(time.Hour)
+	safe := &Safe{
+		Headers: Headers{EmptyGroups: []string{"Empty.Nested"}},
+		Records: []Record{{
+			UUID:            uuid.NewV4(),
+			Group:           "Web.Mail",
+			Title:           "login",
+			Username:        "bob",
+			Password:        "new secret",
+			Url:             "https://example.com",
+			Notes:           "free text\n\nuri: https://example.org\npin: 1234",
+			CreationTime:    created,
+			ModTime:         changed,
+			PasswordModTime: changed,
+			PasswordHistory: &PasswordHistory{Enabled: true, Max: 3, Entries: []PasswordHistoryEntry{
+				{changed, "old secret"},
+			}},
+			TwoFactorKey: []byte("12345678901234567890"),
+			TOTPConfig:   TOTPSHA256,
+			TOTPLength:   8,
+		}, {
+			UUID:            uuid.NewV4(),
+			Group:           "Cards",
+			Title:           "card",
+			Notes:           "template: Card\nnumber: 4111111111111111\ncode: 123",
+			CreationTime:    created,
+			ModTime:         created,
+			PasswordModTime: created,
+		}, {
+			UUID:            uuid.NewV4(),
+			Title:           "note",
+			Notes:           "only text",
+			CreationTime:    created,
+			ModTime:         created,
+			PasswordModTime: created,
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBitwarden(&buf, safe); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"type": 1`, `"type": 3`, `"type": 2`, `"name": "Web/Mail"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("export lacks %s", want)
+		}
+	}
+	read, err := ReadBitwarden(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Headers.EmptyGroups, safe.Headers.EmptyGroups) {
+		t.Errorf("empty groups = %v, want %v", read.Headers.EmptyGroups, safe.Headers.EmptyGroups)
+	}
+	if len(read.Records) != len(safe.Records) {
+		t.Fatalf("read %d records, want %d", len(read.Records), len(safe.Records))
+	}
+	for i, want := range safe.Records {
+		if fields := ChangedFields(want, read.Records[i]); len(fields) > 0 {
+			t.Errorf("%s: fields changed: %v\nwant %+v\ngot  %+v", want.Title, fields, want, read.Records[i])
+		}
+	}
+}
+
+func TestReadBitwarden(t *testing.T) {
+	export := `{
+  "encrypted": false,
+  "folders": [{"id": "f1", "name": "Work/Servers"}],
+  "items": [{
+    "id": "not a uuid",
+    "folderId": "f1",
+    "type": 4,
+    "name": "me",
+    "notes": null,
+    "identity": {"firstName": "Bob", "email": "bob@example.com", "username": "bob"}
+  }, {
+    "id": "0f3a5d5e-9b2c-4e1d-8f6a-7b8c9d0e1f2a",
+    "folderId": null,
+    "type": 1,
+    "name": "site",
+    "login": {"username": "bob", "password": "pw", "totp": "not base32!"},
+    "fields": [{"name": "question", "value": "answer", "type": 0}]
+  }]
+}`
+	safe, err := ReadBitwarden(strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(safe.Records) != 2 {
+		t.Fatalf("read %d records, want 2", len(safe.Records))
+	}
+	identity, login := safe.Records[0], safe.Records[1]
+	for _, test := range []struct{ name, got, want string }{
+		{"group", identity.Group, "Work.Servers"},
+		{"template", identity.Template(), IdentityTemplate},
+		{"email", identity.Email, "bob@example.com"},
+		{"identity notes", identity.Notes, "template: Identity\nfirstName: Bob\nemail: bob@example.com\nusername: bob"},
+		{"uuid", login.UUID.String(), "0f3a5d5e-9b2c-4e1d-8f6a-7b8c9d0e1f2a"},
+		{"login notes", login.Notes, "totp: not base32!\nquestion: answer"},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %q, want %q", test.name, test.got, test.want)
+		}
+	}
+	if uuid.Equal(identity.UUID, uuid.Nil) {
+		t.Errorf("invalid item id gave no UUID")
+	}
+	if len(safe.Headers.EmptyGroups) != 0 {
+		t.Errorf("empty groups = %v, want none", safe.Headers.EmptyGroups)
+	}
+
+	if _, err := ReadBitwarden(strings.NewReader(`{"encrypted": true, "items": []}`)); err != ErrBitwardenEncrypted {
+		t.Errorf("encrypted export: %v, want ErrBitwardenEncrypted", err)
+	}
+}
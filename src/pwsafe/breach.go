@@ -0,0 +1,181 @@
+package pwsafe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Offline checks against the Pwned Passwords lists of Have I Been Pwned.
+//
+// The lists hold one "HASH:COUNT" line per breached password, sorted by
+// the upper case hex SHA-1 or NTLM hash. They are searched in place, so
+// the tens of gigabytes never have to fit in memory.
+
+var ErrBreachFormat = errors.New("not a Pwned Passwords hash file")
+
+// Name of the note field recording when a record was last checked
+const BreachCheckField = "breach-checked"
+
+// Bytes below which the search reads lines in order
+const breachScan = 8192
+
+// A sorted Pwned Passwords hash file
+type BreachDB struct {
+	f    *os.File
+	size int64
+	ntlm bool
+}
+
+// Open a Pwned Passwords file, telling SHA-1 from NTLM lists by the
+// length of the hashes
+func OpenBreachDB(path string) (*BreachDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	db := &BreachDB{f: f, size: fi.Size()}
+	line, err := db.lineAt(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	hash, _, ok := breachLine(line)
+	switch {
+	case ok && len(hash) == 2*sha1.Size:
+	case ok && len(hash) == 2*md4.Size:
+		db.ntlm = true
+	default:
+		f.Close()
+		return nil, ErrBreachFormat
+	}
+	return db, nil
+}
+
+func (db *BreachDB) Close() error {
+	return db.f.Close()
+}
+
+// Reports whether the file lists NTLM rather than SHA-1 hashes
+func (db *BreachDB) NTLM() bool {
+	return db.ntlm
+}
+
+// The hash of password as listed in the file
+func (db *BreachDB) hash(password string) string {
+	if !db.ntlm {
+		sum := sha1.Sum([]byte(password))
+		return strings.ToUpper(hex.EncodeToString(sum[:]))
+	}
+	h := md4.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		binary.Write(h, binary.LittleEndian, c)
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// How often password appears in the breaches, 0 if it does not
+func (db *BreachDB) Count(password string) (int, error) {
+	target := db.hash(password)
+
+	// Narrow down to a range of whole lines by binary search on the
+	// offsets, then read that range line by line
+	lo, hi := int64(0), db.size
+	for hi-lo > breachScan {
+		mid := lo + (hi-lo)/2
+		start, err := db.nextLine(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			break
+		}
+		line, err := db.lineAt(start)
+		if err != nil {
+			return 0, err
+		}
+		hash, _, ok := breachLine(line)
+		if !ok {
+			return 0, ErrBreachFormat
+		}
+		if hash < target {
+			lo = start
+		} else {
+			hi = start
+		}
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(db.f, lo, db.size-lo))
+	for {
+		line, err := r.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+		hash, count, ok := breachLine(line)
+		if !ok {
+			return 0, ErrBreachFormat
+		}
+		if hash == target {
+			return count, nil
+		}
+		if hash > target {
+			return 0, nil
+		}
+	}
+}
+
+// The offset of the first line starting after off
+func (db *BreachDB) nextLine(off int64) (int64, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := db.f.ReadAt(buf, off)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return off + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			return db.size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		off += int64(n)
+	}
+}
+
+// The line starting at off
+func (db *BreachDB) lineAt(off int64) (string, error) {
+	line, err := bufio.NewReaderSize(io.NewSectionReader(db.f, off, db.size-off), 256).ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return line, err
+}
+
+// Split a "HASH:COUNT" line
+func breachLine(line string) (hash string, count int, ok bool) {
+	line = strings.TrimRight(line, "\r\n")
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	count, err := strconv.Atoi(line[i+1:])
+	return strings.ToUpper(line[:i]), count, err == nil
+}
@@ -0,0 +1,114 @@
+package pwsafe
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Write a sorted SHA-1 list of password0, password1, ... where passwordN
+// was seen N+1 times, returning the passwords in the order of the file
+func writeTestBreachDB(t *testing.T, n int, newline string) (string, []string) {
+	hashes := make(map[string]string)
+	var passwords []string
+	for i := 0; i < n; i++ {
+		password := fmt.Sprintf("password%d", i)
+		sum := sha1.Sum([]byte(password))
+		hashes[password] = strings.ToUpper(hex.EncodeToString(sum[:]))
+		passwords = append(passwords, password)
+	}
+	sort.Slice(passwords, func(i, j int) bool { return hashes[passwords[i]] < hashes[passwords[j]] })
+
+	var lines strings.Builder
+	for _, password := range passwords {
+		var i int
+		fmt.Sscanf(password, "password%d", &i)
+		fmt.Fprintf(&lines, "%s:%d%s", hashes[password], i+1, newline)
+	}
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := ioutil.WriteFile(path, []byte(lines.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, passwords
+}
+
+func TestBreachDB(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		n       int
+		newline string
+	}{
+		{"small", 10, "\n"},
+		{"search", 2000, "\n"},
+		{"crlf", 2000, "\r\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path, passwords := writeTestBreachDB(t, test.n, test.newline)
+			db, err := OpenBreachDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if db.NTLM() {
+				t.Errorf("SHA-1 list taken for NTLM")
+			}
+
+			for _, password := range []string{
+				passwords[0],
+				passwords[len(passwords)/2],
+				passwords[len(passwords)-1],
+				"not breached",
+			} {
+				want := 0
+				if _, err := fmt.Sscanf(password, "password%d", &want); err == nil {
+					want++
+				}
+				if got, err := db.Count(password); err != nil || got != want {
+					t.Errorf("Count(%s) = %d, %v, want %d", password, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreachDBNTLM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	data := "0000000000000000000000000000000A:1\n8846F7EAEE8FB117AD06BDD830B7586C:42\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenBreachDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !db.NTLM() {
+		t.Errorf("NTLM list taken for SHA-1")
+	}
+	if got, err := db.Count("password"); err != nil || got != 42 {
+		t.Errorf("Count(password) = %d, %v, want 42", got, err)
+	}
+}
+
+func TestBreachDBFormat(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"not a hash file\n",
+		"ABCDEF:1\n",
+		"8846F7EAEE8FB117AD06BDD830B7586C:many\n",
+	} {
+		path := filepath.Join(t.TempDir(), "pwned.txt")
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if db, err := OpenBreachDB(path); err == nil {
+			db.Close()
+			t.Errorf("opened %q", data)
+		}
+	}
+}
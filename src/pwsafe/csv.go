@@ -0,0 +1,182 @@
+package pwsafe
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column mappings for the CSV exports of common password managers
+var CSVPresets = map[string]string{
+	"chrome":    "title=name,url=url,username=username,password=password,notes=note",
+	"firefox":   "url=url,username=username,password=password,created=timeCreated",
+	"lastpass":  "group=grouping,title=name,url=url,username=username,password=password,notes=extra",
+	"bitwarden": "group=folder,title=name,url=login_uri,username=login_username,password=login_password,notes=notes",
+}
+
+// A CSVMapping maps record fields to the CSV columns they are read from.
+//
+// Besides the record fields it maps "created", the creation time as
+// seconds or milliseconds since the epoch or in RFC 3339 format.
+type CSVMapping map[string][]string
+
+// Parse a mapping spec of the form "field=column,field=column+column".
+//
+// Columns are header names, matched case insensitively, or 1-based column
+// numbers. Several columns for the group are folder levels; for other
+// fields their values are joined by newlines.
+func ParseCSVMapping(spec string) (CSVMapping, error) {
+	m := make(CSVMapping)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid column mapping %q", item)
+		}
+		field := strings.ToLower(strings.TrimSpace(item[:i]))
+		if field != "created" {
+			if _, err := (&Record{}).fieldPtr(field); err != nil {
+				return nil, err
+			}
+		}
+		var columns []string
+		for _, column := range strings.Split(item[i+1:], "+") {
+			if column = strings.TrimSpace(column); column != "" {
+				columns = append(columns, column)
+			}
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("invalid column mapping %q", item)
+		}
+		m[field] = columns
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("empty column mapping")
+	}
+	return m, nil
+}
+
+func (m CSVMapping) String() string {
+	items := make([]string, 0, len(m))
+	for field, columns := range m {
+		items = append(items, field+"="+strings.Join(columns, "+"))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Read records from CSV with a header row, mapping columns according to m.
+//
+// Records without a title are named after their URL's host. Rows with no
+// mapped values are skipped.
+func ReadCSV(r io.Reader, m CSVMapping) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %v", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	index := make(map[string][]int)
+	for field, columns := range m {
+		for _, column := range columns {
+			i, err := csvColumn(header, column)
+			if err != nil {
+				return nil, err
+			}
+			index[field] = append(index[field], i)
+		}
+	}
+
+	var records []Record
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var record Record
+		empty := true
+		for field, columns := range index {
+			var values []string
+			for _, i := range columns {
+				if i < len(row) && strings.TrimSpace(row[i]) != "" {
+					values = append(values, row[i])
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+			empty = false
+			switch field {
+			case "group":
+				var levels []string
+				for _, value := range values {
+					levels = append(levels, folderLevels(value)...)
+				}
+				record.Group = joinGroup(levels)
+			case "created":
+				t, err := parseCSVTime(values[0])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				record.CreationTime = t
+			default:
+				record.SetField(field, strings.Join(values, "\n"))
+			}
+		}
+		if empty {
+			continue
+		}
+		if record.Title == "" {
+			record.Title = urlTitle(record.Url)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func csvColumn(header []string, column string) (int, error) {
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("invalid column number %d", n)
+		}
+		return n - 1, nil
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column %q in csv header", column)
+}
+
+func parseCSVTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e11 {
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func urlTitle(s string) string {
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package pwsafe
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSVMapping(t *testing.T) {
+	for _, test := range []struct {
+		spec, want string
+	}{
+		{"title=name, url=URL", "title=name,url=URL"},
+		{"group=a+b+3,notes=x+ y", "group=a+b+3,notes=x+y"},
+		{"created=time,,", "created=time"},
+		{"Password=2", "password=2"},
+		{"title", ""},
+		{"title=", ""},
+		{"title=+", ""},
+		{"colour=x", ""},
+		{"", ""},
+	} {
+		m, err := ParseCSVMapping(test.spec)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: accepted as %s", test.spec, m)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+		} else if got := m.String(); got != test.want {
+			t.Errorf("%q = %s, want %s", test.spec, got, test.want)
+		}
+	}
+	for name, spec := range CSVPresets {
+		if _, err := ParseCSVMapping(spec); err != nil {
+			t.Errorf("preset %s: %v", name, err)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		mapping string
+		data    string
+		want    []Record
+	}{
+		{"chrome", CSVPresets["chrome"],
+			"\ufeffname,url,username,password,note\n" +
+				"Example,https://example.com/login,bob,secret,\"two\nlines\"\n" +
+				",https://mail.example.org:8443/,alice,pw,\n" +
+				",,,,\n",
+			[]Record{
+				{Title: "Example", Url: "https://example.com/login", Username: "bob", Password: "secret", Notes: "two\nlines"},
+				{Title: "mail.example.org", Url: "https://mail.example.org:8443/", Username: "alice", Password: "pw"},
+			}},
+		{"lastpass", CSVPresets["lastpass"],
+			"url,username,password,extra,name,grouping,fav\n" +
+				"http://sn,,,note,Wifi,Home\\Net.work,0\n",
+			[]Record{
+				{Group: `Home.Net\.work`, Title: "Wifi", Url: "http://sn", Notes: "note"},
+			}},
+		{"columns", "group=1+2,title=3,notes=4+5,created=6",
+			"a,b,c,d,e,f\n" +
+				"Web,Mail,x,first,second,1500000000\n" +
+				"Web,,y,,only,1500000000000\n" +
+				"short,row\n",
+			[]Record{
+				{Group: "Web.Mail", Title: "x", Notes: "first\nsecond", CreationTime: time.Unix(1500000000, 0)},
+				{Group: "Web", Title: "y", Notes: "only", CreationTime: time.Unix(1500000000, 0)},
+				{Group: "short.row"},
+			}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseCSVMapping(test.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, err := ReadCSV(strings.NewReader(test.data), m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(test.want) {
+				t.Fatalf("read %d records, want %d", len(records), len(test.want))
+			}
+			for i, want := range test.want {
+				if fields := ChangedFields(want, records[i]); len(fields) > 0 {
+					t.Errorf("record %d: fields changed: %v\nwant %+v\ngot  %+v", i+1, fields, want, records[i])
+				}
+			}
+		})
+	}
+
+	for _, test := range []struct{ mapping, data string }{
+		{"title=missing", "name,url\nx,y\n"},
+		{"title=0", "name,url\nx,y\n"},
+		{"title=name,created=when", "name,when\nx,yesterday\n"},
+		{"title=name", ""},
+	} {
+		m, err := ParseCSVMapping(test.mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadCSV(strings.NewReader(test.data), m); err == nil {
+			t.Errorf("%s: accepted %q", test.mapping, test.data)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pwsafe
+
+import (
+	"bytes"
+
+	"github.com/satori/go.uuid"
+)
+
+// Kind of change made to a record between two versions of a safe
+type ChangeKind int
+
+const (
+	RecordAdded ChangeKind = iota
+	RecordRemoved
+	RecordModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case RecordAdded:
+		return "added"
+	case RecordRemoved:
+		return "removed"
+	case RecordModified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// A change to one record, matched between versions by UUID
+type RecordChange struct {
+	Kind   ChangeKind
+	Record Record   // The new record, or the old one if it was removed
+	Fields []string // Names of the changed fields when modified
+}
+
+// Compare two versions of a safe record by record
+func Diff(old, new *Safe) []RecordChange {
+	var changes []RecordChange
+	oldRecords := make(map[uuid.UUID]Record)
+	for _, record := range old.Records {
+		oldRecords[record.UUID] = record
+	}
+
+	for _, record := range new.Records {
+		prev, ok := oldRecords[record.UUID]
+		if !ok {
+			changes = append(changes, RecordChange{Kind: RecordAdded, Record: record})
+			continue
+		}
+		delete(oldRecords, record.UUID)
+		if fields := ChangedFields(prev, record); len(fields) > 0 {
+			changes = append(changes, RecordChange{Kind: RecordModified, Record: record, Fields: fields})
+		}
+	}
+	for _, record := range old.Records {
+		if _, ok := oldRecords[record.UUID]; ok {
+			changes = append(changes, RecordChange{Kind: RecordRemoved, Record: record})
+		}
+	}
+	return changes
+}
+
+// Names of the fields that differ between two versions of a record: the
+// names of FieldNames, "totp" for the two-factor settings and the names of
+// the JSON dump for the others.
+func ChangedFields(a, b Record) []string {
+	var fields []string
+	for _, name := range FieldNames {
+		av, _ := a.Field(name)
+		bv, _ := b.Field(name)
+		if av != bv {
+			fields = append(fields, name)
+		}
+	}
+	changed := func(name string, differ bool) {
+		if differ {
+			fields = append(fields, name)
+		}
+	}
+	changed("totp", !bytes.Equal(a.TwoFactorKey, b.TwoFactorKey) || a.TOTPConfig != b.TOTPConfig ||
+		a.TOTPLength != b.TOTPLength || a.TOTPTimeStep != b.TOTPTimeStep || !a.TOTPStartTime.Equal(b.TOTPStartTime))
+	changed("creation_time", !a.CreationTime.Equal(b.CreationTime))
+	changed("password_mod_time", !a.PasswordModTime.Equal(b.PasswordModTime))
+	changed("access_time", !a.AccessTime.Equal(b.AccessTime))
+	changed("expiry_time", !a.ExpiryTime.Equal(b.ExpiryTime))
+	changed("mod_time", !a.ModTime.Equal(b.ModTime))
+	changed("expiry_interval", a.ExpiryInterval != b.ExpiryInterval)
+	changed("autotype", a.Autotype != b.Autotype)
+	changed("run_command", a.RunCommand != b.RunCommand)
+	changed("password_history", !sameHistory(a.PasswordHistory, b.PasswordHistory))
+	changed("password_policy", (a.PasswordPolicy == nil) != (b.PasswordPolicy == nil) ||
+		a.PasswordPolicy != nil && *a.PasswordPolicy != *b.PasswordPolicy)
+	changed("policy_name", a.PolicyName != b.PolicyName)
+	changed("symbols", a.Symbols != b.Symbols)
+	changed("protected", a.Protected != b.Protected)
+	changed("unknown", !sameFields(a.Unknown, b.Unknown))
+	return fields
+}
+
+func sameHistory(a, b *PasswordHistory) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Enabled != b.Enabled || a.Max != b.Max || len(a.Entries) != len(b.Entries) {
+		return false
+	}
+	for i, e := range a.Entries {
+		if !e.Time.Equal(b.Entries[i].Time) || e.Password != b.Entries[i].Password {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFields(a, b []Field) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, f := range a {
+		if f.Type != b[i].Type || !bytes.Equal(f.Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,418 @@
+package pwsafe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"gopkg.in/yaml.v2"
+)
+
+// Plain JSON and YAML dumps of the whole safe, for tools and audits.
+//
+// Times are in RFC 3339 format, two-factor keys base32 encoded and fields
+// kept as read hex encoded. Passwords, including those in the history,
+// and two-factor keys are only written when the dump is revealed.
+
+// Version of the dump schema, increased on incompatible changes
+const DumpVersion = 1
+
+var (
+	ErrDumpVersion     = errors.New("unsupported dump version")
+	ErrDumpNotRevealed = errors.New("dump does not include passwords")
+)
+
+type dumpSafe struct {
+	Version  int          `json:"version" yaml:"version"`
+	Revealed bool         `json:"revealed" yaml:"revealed"`
+	Headers  dumpHeaders  `json:"headers" yaml:"headers"`
+	Records  []dumpRecord `json:"records" yaml:"records"`
+}
+
+type dumpHeaders struct {
+	VersionMajor     uint8        `json:"version_major" yaml:"version_major"`
+	VersionMinor     uint8        `json:"version_minor" yaml:"version_minor"`
+	UUID             string       `json:"uuid,omitempty" yaml:"uuid,omitempty"`
+	Name             string       `json:"name,omitempty" yaml:"name,omitempty"`
+	Description      string       `json:"description,omitempty" yaml:"description,omitempty"`
+	LastSave         string       `json:"last_save,omitempty" yaml:"last_save,omitempty"`
+	ProgramSave      string       `json:"program_save,omitempty" yaml:"program_save,omitempty"`
+	User             string       `json:"user,omitempty" yaml:"user,omitempty"`
+	Host             string       `json:"host,omitempty" yaml:"host,omitempty"`
+	PasswordPolicies []dumpPolicy `json:"password_policies,omitempty" yaml:"password_policies,omitempty"`
+	EmptyGroups      []string     `json:"empty_groups,omitempty" yaml:"empty_groups,omitempty"`
+	Templates        []string     `json:"templates,omitempty" yaml:"templates,omitempty"`
+	Unknown          []dumpField  `json:"unknown,omitempty" yaml:"unknown,omitempty"`
+}
+
+type dumpPolicy struct {
+	Name              string `json:"name,omitempty" yaml:"name,omitempty"`
+	UseLowercase      bool   `json:"use_lowercase" yaml:"use_lowercase"`
+	UseUppercase      bool   `json:"use_uppercase" yaml:"use_uppercase"`
+	UseDigits         bool   `json:"use_digits" yaml:"use_digits"`
+	UseSymbols        bool   `json:"use_symbols" yaml:"use_symbols"`
+	UseHexDigits      bool   `json:"use_hex_digits" yaml:"use_hex_digits"`
+	UseEasyVision     bool   `json:"use_easy_vision" yaml:"use_easy_vision"`
+	MakePronounceable bool   `json:"make_pronounceable" yaml:"make_pronounceable"`
+	Length            int    `json:"length" yaml:"length"`
+	MinLowercase      int    `json:"min_lowercase" yaml:"min_lowercase"`
+	MinUppercase      int    `json:"min_uppercase" yaml:"min_uppercase"`
+	MinDigits         int    `json:"min_digits" yaml:"min_digits"`
+	MinSymbols        int    `json:"min_symbols" yaml:"min_symbols"`
+	Symbols           string `json:"symbols,omitempty" yaml:"symbols,omitempty"`
+}
+
+type dumpRecord struct {
+	UUID            string       `json:"uuid" yaml:"uuid"`
+	Group           string       `json:"group" yaml:"group"`
+	Title           string       `json:"title" yaml:"title"`
+	Username        string       `json:"username,omitempty" yaml:"username,omitempty"`
+	Password        string       `json:"password,omitempty" yaml:"password,omitempty"`
+	Url             string       `json:"url,omitempty" yaml:"url,omitempty"`
+	Email           string       `json:"email,omitempty" yaml:"email,omitempty"`
+	Notes           string       `json:"notes,omitempty" yaml:"notes,omitempty"`
+	CreationTime    string       `json:"creation_time,omitempty" yaml:"creation_time,omitempty"`
+	PasswordModTime string       `json:"password_mod_time,omitempty" yaml:"password_mod_time,omitempty"`
+	AccessTime      string       `json:"access_time,omitempty" yaml:"access_time,omitempty"`
+	ExpiryTime      string       `json:"expiry_time,omitempty" yaml:"expiry_time,omitempty"`
+	ModTime         string       `json:"mod_time,omitempty" yaml:"mod_time,omitempty"`
+	ExpiryInterval  int          `json:"expiry_interval,omitempty" yaml:"expiry_interval,omitempty"`
+	Autotype        string       `json:"autotype,omitempty" yaml:"autotype,omitempty"`
+	RunCommand      string       `json:"run_command,omitempty" yaml:"run_command,omitempty"`
+	PasswordHistory *dumpHistory `json:"password_history,omitempty" yaml:"password_history,omitempty"`
+	PasswordPolicy  *dumpPolicy  `json:"password_policy,omitempty" yaml:"password_policy,omitempty"`
+	PolicyName      string       `json:"policy_name,omitempty" yaml:"policy_name,omitempty"`
+	Symbols         string       `json:"symbols,omitempty" yaml:"symbols,omitempty"`
+	Protected       bool         `json:"protected,omitempty" yaml:"protected,omitempty"`
+	TwoFactorKey    string       `json:"two_factor_key,omitempty" yaml:"two_factor_key,omitempty"`
+	TOTPConfig      uint8        `json:"totp_config,omitempty" yaml:"totp_config,omitempty"`
+	TOTPLength      int          `json:"totp_length,omitempty" yaml:"totp_length,omitempty"`
+	TOTPTimeStep    int          `json:"totp_time_step,omitempty" yaml:"totp_time_step,omitempty"`
+	TOTPStartTime   string       `json:"totp_start_time,omitempty" yaml:"totp_start_time,omitempty"`
+	Unknown         []dumpField  `json:"unknown,omitempty" yaml:"unknown,omitempty"`
+}
+
+type dumpHistory struct {
+	Enabled bool               `json:"enabled" yaml:"enabled"`
+	Max     int                `json:"max" yaml:"max"`
+	Entries []dumpHistoryEntry `json:"entries,omitempty" yaml:"entries,omitempty"`
+}
+
+type dumpHistoryEntry struct {
+	Time     string `json:"time" yaml:"time"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+}
+
+type dumpField struct {
+	Type uint8  `json:"type" yaml:"type"`
+	Data string `json:"data" yaml:"data"`
+}
+
+// Write the safe as JSON, including passwords only if reveal is set
+func WriteJSON(w io.Writer, safe *Safe, reveal bool) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newDump(safe, reveal))
+}
+
+// Write the safe as YAML, including passwords only if reveal is set
+func WriteYAML(w io.Writer, safe *Safe, reveal bool) error {
+	data, err := yaml.Marshal(newDump(safe, reveal))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Read a JSON dump written with passwords revealed
+func ReadJSON(r io.Reader) (*Safe, error) {
+	var dump dumpSafe
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, err
+	}
+	return dump.safe()
+}
+
+// Read a YAML dump written with passwords revealed
+func ReadYAML(r io.Reader) (*Safe, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var dump dumpSafe
+	if err := yaml.UnmarshalStrict(data, &dump); err != nil {
+		return nil, err
+	}
+	return dump.safe()
+}
+
+func dumpTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func dumpFields(fields []Field) []dumpField {
+	var out []dumpField
+	for _, f := range fields {
+		out = append(out, dumpField{uint8(f.Type), hex.EncodeToString(f.Data)})
+	}
+	return out
+}
+
+func newDumpPolicy(name string, p PasswordPolicy, symbols string) *dumpPolicy {
+	return &dumpPolicy{
+		Name:              name,
+		UseLowercase:      p.UseLowercase,
+		UseUppercase:      p.UseUppercase,
+		UseDigits:         p.UseDigits,
+		UseSymbols:        p.UseSymbols,
+		UseHexDigits:      p.UseHexDigits,
+		UseEasyVision:     p.UseEasyVision,
+		MakePronounceable: p.MakePronounceable,
+		Length:            p.Length,
+		MinLowercase:      p.MinLowercase,
+		MinUppercase:      p.MinUppercase,
+		MinDigits:         p.MinDigits,
+		MinSymbols:        p.MinSymbols,
+		Symbols:           symbols,
+	}
+}
+
+func (p *dumpPolicy) policy() PasswordPolicy {
+	return PasswordPolicy{
+		UseLowercase:      p.UseLowercase,
+		UseUppercase:      p.UseUppercase,
+		UseDigits:         p.UseDigits,
+		UseSymbols:        p.UseSymbols,
+		UseHexDigits:      p.UseHexDigits,
+		UseEasyVision:     p.UseEasyVision,
+		MakePronounceable: p.MakePronounceable,
+		Length:            p.Length,
+		MinLowercase:      p.MinLowercase,
+		MinUppercase:      p.MinUppercase,
+		MinDigits:         p.MinDigits,
+		MinSymbols:        p.MinSymbols,
+	}
+}
+
+func newDump(safe *Safe, reveal bool) *dumpSafe {
+	h := safe.Headers
+	dump := &dumpSafe{
+		Version:  DumpVersion,
+		Revealed: reveal,
+		Headers: dumpHeaders{
+			VersionMajor: h.VersionMajor,
+			VersionMinor: h.VersionMinor,
+			Name:         h.Name,
+			Description:  h.Description,
+			LastSave:     dumpTime(h.LastSave),
+			ProgramSave:  h.ProgramSave,
+			User:         h.User,
+			Host:         h.Host,
+			EmptyGroups:  h.EmptyGroups,
+			Unknown:      dumpFields(h.Unknown),
+		},
+		Records: []dumpRecord{},
+	}
+	if !uuid.Equal(h.UUID, uuid.Nil) {
+		dump.Headers.UUID = h.UUID.String()
+	}
+	for _, p := range h.PasswordPolicies {
+		dump.Headers.PasswordPolicies = append(dump.Headers.PasswordPolicies, *newDumpPolicy(p.Name, p.PasswordPolicy, p.Symbols))
+	}
+	for _, t := range h.Templates {
+		dump.Headers.Templates = append(dump.Headers.Templates, t.String())
+	}
+
+	for _, r := range safe.Records {
+		d := dumpRecord{
+			UUID:            r.UUID.String(),
+			Group:           r.Group,
+			Title:           r.Title,
+			Username:        r.Username,
+			Url:             r.Url,
+			Email:           r.Email,
+			Notes:           r.Notes,
+			CreationTime:    dumpTime(r.CreationTime),
+			PasswordModTime: dumpTime(r.PasswordModTime),
+			AccessTime:      dumpTime(r.AccessTime),
+			ExpiryTime:      dumpTime(r.ExpiryTime),
+			ModTime:         dumpTime(r.ModTime),
+			ExpiryInterval:  r.ExpiryInterval,
+			Autotype:        r.Autotype,
+			RunCommand:      r.RunCommand,
+			PolicyName:      r.PolicyName,
+			Symbols:         r.Symbols,
+			Protected:       r.Protected,
+			TOTPConfig:      r.TOTPConfig,
+			TOTPLength:      r.TOTPLength,
+			TOTPTimeStep:    r.TOTPTimeStep,
+			TOTPStartTime:   dumpTime(r.TOTPStartTime),
+			Unknown:         dumpFields(r.Unknown),
+		}
+		if reveal {
+			d.Password = r.Password
+			if r.HasTOTP() {
+				d.TwoFactorKey = EncodeOTPSecret(r.TwoFactorKey)
+			}
+		}
+		if r.PasswordHistory != nil {
+			d.PasswordHistory = &dumpHistory{Enabled: r.PasswordHistory.Enabled, Max: r.PasswordHistory.Max}
+			for _, e := range r.PasswordHistory.Entries {
+				entry := dumpHistoryEntry{Time: dumpTime(e.Time)}
+				if reveal {
+					entry.Password = e.Password
+				}
+				d.PasswordHistory.Entries = append(d.PasswordHistory.Entries, entry)
+			}
+		}
+		if r.PasswordPolicy != nil {
+			d.PasswordPolicy = newDumpPolicy("", *r.PasswordPolicy, "")
+		}
+		dump.Records = append(dump.Records, d)
+	}
+	return dump
+}
+
+// Collects the first error of parsing the values of a dump
+type dumpParser struct {
+	err error
+}
+
+func (p *dumpParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (p *dumpParser) time(where, s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		p.fail("%s: invalid time %q", where, s)
+	}
+	return t
+}
+
+func (p *dumpParser) uuid(where, s string) uuid.UUID {
+	id, err := uuid.FromString(s)
+	if err != nil || uuid.Equal(id, uuid.Nil) {
+		p.fail("%s: invalid UUID %q", where, s)
+	}
+	return id
+}
+
+func (p *dumpParser) fields(where string, fields []dumpField) []Field {
+	var out []Field
+	for _, f := range fields {
+		data, err := hex.DecodeString(f.Data)
+		if err != nil {
+			p.fail("%s: invalid field data %q", where, f.Data)
+		}
+		out = append(out, Field{FieldType(f.Type), data})
+	}
+	return out
+}
+
+func (dump *dumpSafe) safe() (*Safe, error) {
+	if dump.Version != DumpVersion {
+		return nil, fmt.Errorf("%w %d", ErrDumpVersion, dump.Version)
+	}
+	if !dump.Revealed {
+		return nil, ErrDumpNotRevealed
+	}
+
+	var p dumpParser
+	h := dump.Headers
+	safe := &Safe{Headers: Headers{
+		VersionMajor: h.VersionMajor,
+		VersionMinor: h.VersionMinor,
+		Name:         h.Name,
+		Description:  h.Description,
+		LastSave:     p.time("headers last_save", h.LastSave),
+		ProgramSave:  h.ProgramSave,
+		User:         h.User,
+		Host:         h.Host,
+		EmptyGroups:  h.EmptyGroups,
+		Unknown:      p.fields("headers", h.Unknown),
+	}}
+	if h.UUID != "" {
+		safe.Headers.UUID = p.uuid("headers", h.UUID)
+	}
+	for _, policy := range h.PasswordPolicies {
+		safe.Headers.PasswordPolicies = append(safe.Headers.PasswordPolicies, NamedPasswordPolicy{policy.Name, policy.policy(), policy.Symbols})
+	}
+	if templates, err := ParseTemplates(strings.Join(h.Templates, "\n")); err != nil {
+		p.fail("headers templates: %v", err)
+	} else {
+		safe.Headers.Templates = templates
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for i, d := range dump.Records {
+		where := fmt.Sprintf("record %d", i+1)
+		r := Record{
+			UUID:            p.uuid(where, d.UUID),
+			Group:           d.Group,
+			Title:           d.Title,
+			Username:        d.Username,
+			Password:        d.Password,
+			Url:             d.Url,
+			Email:           d.Email,
+			Notes:           d.Notes,
+			CreationTime:    p.time(where+" creation_time", d.CreationTime),
+			PasswordModTime: p.time(where+" password_mod_time", d.PasswordModTime),
+			AccessTime:      p.time(where+" access_time", d.AccessTime),
+			ExpiryTime:      p.time(where+" expiry_time", d.ExpiryTime),
+			ModTime:         p.time(where+" mod_time", d.ModTime),
+			ExpiryInterval:  d.ExpiryInterval,
+			Autotype:        d.Autotype,
+			RunCommand:      d.RunCommand,
+			PolicyName:      d.PolicyName,
+			Symbols:         d.Symbols,
+			Protected:       d.Protected,
+			TOTPConfig:      d.TOTPConfig,
+			TOTPLength:      d.TOTPLength,
+			TOTPTimeStep:    d.TOTPTimeStep,
+			TOTPStartTime:   p.time(where+" totp_start_time", d.TOTPStartTime),
+			Unknown:         p.fields(where, d.Unknown),
+		}
+		if d.TwoFactorKey != "" {
+			key, err := DecodeOTPSecret(d.TwoFactorKey)
+			if err != nil {
+				p.fail("%s: invalid two_factor_key", where)
+			}
+			r.TwoFactorKey = key
+		}
+		if seen[r.UUID] {
+			p.fail("%s: duplicate UUID %s", where, r.UUID)
+		}
+		seen[r.UUID] = true
+		if d.PasswordHistory != nil {
+			r.PasswordHistory = &PasswordHistory{Enabled: d.PasswordHistory.Enabled, Max: d.PasswordHistory.Max}
+			for _, e := range d.PasswordHistory.Entries {
+				r.PasswordHistory.Entries = append(r.PasswordHistory.Entries, PasswordHistoryEntry{p.time(where+" password_history", e.Time), e.Password})
+			}
+		}
+		if d.PasswordPolicy != nil {
+			policy := d.PasswordPolicy.policy()
+			r.PasswordPolicy = &policy
+		}
+		safe.Records = append(safe.Records, r)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return safe, nil
+}
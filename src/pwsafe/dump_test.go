@@ -0,0 +1,109 @@
+package pwsafe
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// A safe using every field the dumps write
+func testDumpSafe() *Safe {
+	safe := testKDBXSafe()
+	safe.Headers = Headers{
+		VersionMajor: 3,
+		VersionMinor: 13,
+		UUID:         uuid.NewV4(),
+		Name:         "Test",
+		Description:  "for the tests",
+		LastSave:     time.Unix(1500000000, 0),
+		PasswordPolicies: []NamedPasswordPolicy{
+			{"pin", PasswordPolicy{UseDigits: true, Length: 6, MinDigits: 6}, ""},
+		},
+		EmptyGroups: []string{"Empty"},
+		Templates:   []Template{{"Server", []string{"host", "port"}}},
+		Unknown:     []Field{{0x30, []byte{1, 2, 3}}},
+	}
+	r := &safe.Records[0]
+	r.TwoFactorKey = []byte("12345678901234567890")
+	r.TOTPConfig, r.TOTPLength, r.TOTPTimeStep = TOTPSHA256, 8, 60
+	r.PasswordPolicy = &PasswordPolicy{UseLowercase: true, Length: 20, MinLowercase: 2}
+	r.Autotype = `\u\t\p\n`
+	r.Protected = true
+	r.Unknown = []Field{{0xdf, []byte("kept")}}
+	return safe
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	for _, format := range []struct {
+		name  string
+		write func(io.Writer, *Safe, bool) error
+		read  func(io.Reader) (*Safe, error)
+	}{
+		{"json", WriteJSON, ReadJSON},
+		{"yaml", WriteYAML, ReadYAML},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			safe := testDumpSafe()
+			var buf bytes.Buffer
+			if err := format.write(&buf, safe, true); err != nil {
+				t.Fatal(err)
+			}
+			read, err := format.read(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, got := safe.Headers, read.Headers
+			if !uuid.Equal(got.UUID, want.UUID) || got.Name != want.Name || got.Description != want.Description ||
+				!got.LastSave.Equal(want.LastSave) || !reflect.DeepEqual(got.PasswordPolicies, want.PasswordPolicies) ||
+				!reflect.DeepEqual(got.EmptyGroups, want.EmptyGroups) || !reflect.DeepEqual(got.Templates, want.Templates) ||
+				!sameFields(got.Unknown, want.Unknown) {
+				t.Errorf("headers changed\nwant %+v\ngot  %+v", want, got)
+			}
+			if len(read.Records) != 1 {
+				t.Fatalf("read %d records, want 1", len(read.Records))
+			}
+			if fields := ChangedFields(safe.Records[0], read.Records[0]); len(fields) > 0 {
+				t.Errorf("fields changed: %v", fields)
+			}
+
+			// Without reveal, no secret is written and the dump cannot be
+			// read back
+			buf.Reset()
+			if err := format.write(&buf, safe, false); err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{"new secret", "old secret", EncodeOTPSecret(safe.Records[0].TwoFactorKey)} {
+				if strings.Contains(buf.String(), secret) {
+					t.Errorf("unrevealed dump contains %q", secret)
+				}
+			}
+			if !strings.Contains(buf.String(), "bob@example.com") {
+				t.Errorf("unrevealed dump lacks the other fields")
+			}
+			if _, err := format.read(&buf); err != ErrDumpNotRevealed {
+				t.Errorf("read unrevealed dump: %v, want ErrDumpNotRevealed", err)
+			}
+		})
+	}
+}
+
+func TestDumpInvalid(t *testing.T) {
+	id := uuid.NewV4().String()
+	for _, dump := range []string{
+		`{"version": 2, "revealed": true, "records": []}`,
+		`{"version": 1, "revealed": true, "records": [{"uuid": "nope"}]}`,
+		`{"version": 1, "revealed": true, "records": [{"uuid": "` + id + `"}, {"uuid": "` + id + `"}]}`,
+		`{"version": 1, "revealed": true, "records": [{"uuid": "` + id + `", "mod_time": "yesterday"}]}`,
+		`{"version": 1, "revealed": true, "records": [{"uuid": "` + id + `", "two_factor_key": "1!"}]}`,
+		`{"version": 1, "revealed": true, "headers": {"templates": ["no colon"]}, "records": []}`,
+	} {
+		if _, err := ReadJSON(strings.NewReader(dump)); err == nil {
+			t.Errorf("accepted %s", dump)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the fields that can be selected for export and import besides
+// FieldNames
+var ExtraFieldNames = []string{
+	"ctime", "atime", "xtime", "pmtime", "rmtime", "xtime_interval",
+	"autotype", "runcommand", "history", "policy", "symbols", "protected", "totp",
+}
+
+// A RecordFilter selects records by the value of a field.
+//
+// The operators are "=" and "!=" for exact matches and "~" and "!~" for
+// substrings ignoring case.
+type RecordFilter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// Parse a filter of the form "field=value", "field!=value", "field~text"
+// or "field!~text".
+func ParseRecordFilter(s string) (RecordFilter, error) {
+	i := strings.IndexAny(s, "=~")
+	if i <= 0 {
+		return RecordFilter{}, fmt.Errorf("invalid filter %q", s)
+	}
+	f := RecordFilter{Field: s[:i], Op: s[i : i+1], Value: s[i+1:]}
+	if strings.HasSuffix(f.Field, "!") {
+		f.Field = strings.TrimSuffix(f.Field, "!")
+		f.Op = "!" + f.Op
+	}
+	if _, err := (&Record{}).fieldPtr(f.Field); err != nil {
+		return RecordFilter{}, err
+	}
+	return f, nil
+}
+
+// Reports whether the record passes the filter
+func (f RecordFilter) Match(r Record) bool {
+	value, _ := r.Field(f.Field)
+	switch f.Op {
+	case "=":
+		return value == f.Value
+	case "!=":
+		return value != f.Value
+	case "~":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(f.Value))
+	case "!~":
+		return !strings.Contains(strings.ToLower(value), strings.ToLower(f.Value))
+	}
+	return false
+}
+
+// The records that pass all filters
+func FilterRecords(records []Record, filters []RecordFilter) []Record {
+	var matched []Record
+Records:
+	for _, record := range records {
+		for _, f := range filters {
+			if !f.Match(record) {
+				continue Records
+			}
+		}
+		matched = append(matched, record)
+	}
+	return matched
+}
+
+// Copy of the record with only the named fields and its UUID.
+//
+// Names are those of FieldNames and ExtraFieldNames.
+func SelectFields(r Record, fields []string) (Record, error) {
+	selected := Record{UUID: r.UUID}
+	for _, name := range fields {
+		switch strings.ToLower(name) {
+		case "ctime":
+			selected.CreationTime = r.CreationTime
+		case "atime":
+			selected.AccessTime = r.AccessTime
+		case "xtime":
+			selected.ExpiryTime = r.ExpiryTime
+		case "pmtime":
+			selected.PasswordModTime = r.PasswordModTime
+		case "rmtime":
+			selected.ModTime = r.ModTime
+		case "xtime_interval":
+			selected.ExpiryInterval = r.ExpiryInterval
+		case "autotype":
+			selected.Autotype = r.Autotype
+		case "runcommand":
+			selected.RunCommand = r.RunCommand
+		case "history":
+			selected.PasswordHistory = r.PasswordHistory
+		case "policy":
+			selected.PasswordPolicy = r.PasswordPolicy
+			selected.PolicyName = r.PolicyName
+		case "symbols":
+			selected.Symbols = r.Symbols
+		case "protected":
+			selected.Protected = r.Protected
+		case "totp":
+			selected.TwoFactorKey = r.TwoFactorKey
+			selected.TOTPConfig = r.TOTPConfig
+			selected.TOTPLength = r.TOTPLength
+			selected.TOTPTimeStep = r.TOTPTimeStep
+			selected.TOTPStartTime = r.TOTPStartTime
+		default:
+			value, err := r.Field(name)
+			if err != nil {
+				return Record{}, err
+			}
+			selected.SetField(name, value)
+		}
+	}
+	return selected, nil
+}
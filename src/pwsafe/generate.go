@@ -0,0 +1,274 @@
+package pwsafe
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Password generation from password policies, with the character sets of
+// the Password Safe desktop client.
+
+var (
+	ErrPolicyEmpty   = errors.New("password policy allows no characters")
+	ErrPolicyUnknown = errors.New("unknown password policy")
+)
+
+// Symbols used when a policy does not list its own
+const (
+	DefaultSymbols    = "+-=_@#$%^&;:,.<>/~\\[](){}?!|*"
+	EasyVisionSymbols = "+-=_@#$%^&<>/~\\?*"
+)
+
+// Number of old passwords kept when a record without a history gets one
+const DefaultHistoryMax = 3
+
+// The policy for records that have none
+var DefaultPasswordPolicy = PasswordPolicy{
+	UseLowercase: true,
+	UseUppercase: true,
+	UseDigits:    true,
+	UseSymbols:   true,
+	Length:       20,
+	MinLowercase: 1,
+	MinUppercase: 1,
+	MinDigits:    1,
+	MinSymbols:   1,
+}
+
+const (
+	lowercaseChars      = "abcdefghijklmnopqrstuvwxyz"
+	uppercaseChars      = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars          = "0123456789"
+	hexChars            = "0123456789abcdef"
+	easyLowercase       = "abcdefghijkmnopqrstuvwxyz"
+	easyUppercase       = "ABCDEFGHJKLMNPQRTUVWXY"
+	easyDigits          = "346789"
+	pronounceVowels     = "aeiou"
+	pronounceConsonants = "bcdfghjklmnpqrstvwxz"
+)
+
+// The policy a record's password is generated with and its symbols: its
+// own policy, the named policy from the safe's headers, or the default
+func (s *Safe) RecordPolicy(r Record) (PasswordPolicy, string, error) {
+	if r.PasswordPolicy != nil {
+		return *r.PasswordPolicy, r.Symbols, nil
+	}
+	if r.PolicyName != "" {
+		for _, p := range s.Headers.PasswordPolicies {
+			if p.Name == r.PolicyName {
+				return p.PasswordPolicy, p.Symbols, nil
+			}
+		}
+		return PasswordPolicy{}, "", fmt.Errorf("%w: %s", ErrPolicyUnknown, r.PolicyName)
+	}
+	return DefaultPasswordPolicy, "", nil
+}
+
+func randIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+func randChar(set string) (rune, error) {
+	chars := []rune(set)
+	i, err := randIndex(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+// Generate a random password following policy.
+//
+// symbols replaces the default symbols if not empty. At least the minimum
+// number of characters of each class in use is included.
+func GeneratePassword(policy PasswordPolicy, symbols string) (string, error) {
+	length := policy.Length
+	if length <= 0 {
+		length = DefaultPasswordPolicy.Length
+	}
+	if policy.UseHexDigits {
+		var b strings.Builder
+		for i := 0; i < length; i++ {
+			c, err := randChar(hexChars)
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(c)
+		}
+		return b.String(), nil
+	}
+
+	lower, upper, digits := lowercaseChars, uppercaseChars, digitChars
+	if symbols == "" {
+		symbols = DefaultSymbols
+		if policy.UseEasyVision {
+			symbols = EasyVisionSymbols
+		}
+	}
+	if policy.UseEasyVision {
+		lower, upper, digits = easyLowercase, easyUppercase, easyDigits
+	}
+	classes := []struct {
+		use   bool
+		chars string
+		min   int
+	}{
+		{policy.UseLowercase, lower, policy.MinLowercase},
+		{policy.UseUppercase, upper, policy.MinUppercase},
+		{policy.UseDigits, digits, policy.MinDigits},
+		{policy.UseSymbols, symbols, policy.MinSymbols},
+	}
+
+	var password, required []rune
+	pool := ""
+	for _, class := range classes {
+		if !class.use {
+			continue
+		}
+		pool += class.chars
+		for i := 0; i < class.min; i++ {
+			c, err := randChar(class.chars)
+			if err != nil {
+				return "", err
+			}
+			required = append(required, c)
+		}
+	}
+	if pool == "" {
+		return "", ErrPolicyEmpty
+	}
+	if len(required) > length {
+		return "", fmt.Errorf("password policy needs %d characters but allows only %d", len(required), length)
+	}
+
+	if policy.MakePronounceable && policy.UseLowercase {
+		// Alternate consonants and vowels, then put the required
+		// characters of the other classes in random places
+		vowel, err := randIndex(2)
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i < length; i++ {
+			set := pronounceConsonants
+			if (i+vowel)%2 == 0 {
+				set = pronounceVowels
+			}
+			c, err := randChar(set)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+		positions, err := randPerm(length)
+		if err != nil {
+			return "", err
+		}
+		for _, class := range classes[1:] {
+			for i := 0; class.use && i < class.min; i++ {
+				j := positions[0]
+				positions = positions[1:]
+				if class.chars == upper {
+					password[j] = unicode.ToUpper(password[j])
+					continue
+				}
+				if password[j], err = randChar(class.chars); err != nil {
+					return "", err
+				}
+			}
+		}
+		return string(password), nil
+	}
+
+	for len(required) < length {
+		c, err := randChar(pool)
+		if err != nil {
+			return "", err
+		}
+		required = append(required, c)
+	}
+	positions, err := randPerm(length)
+	if err != nil {
+		return "", err
+	}
+	password = make([]rune, length)
+	for i, j := range positions {
+		password[j] = required[i]
+	}
+	return string(password), nil
+}
+
+// A random permutation of 0 to n-1
+func randPerm(n int) ([]int, error) {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j, err := randIndex(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	return perm, nil
+}
+
+// Add an old password to the history, dropping the oldest entries beyond
+// the maximum
+func (h *PasswordHistory) Add(t time.Time, password string) {
+	h.Entries = append(h.Entries, PasswordHistoryEntry{t, password})
+	if h.Max > 0 && len(h.Entries) > h.Max {
+		h.Entries = h.Entries[len(h.Entries)-h.Max:]
+	}
+}
+
+// Replace the record's password, keeping the old one in its history
+// unless the history was disabled.
+//
+// The expiry time moves on by the expiry interval, if the record has one,
+// and is cleared if it has passed otherwise.
+func (r *Record) ChangePassword(password string, t time.Time) {
+	if r.PasswordHistory == nil {
+		r.PasswordHistory = &PasswordHistory{Enabled: true, Max: DefaultHistoryMax}
+	} else {
+		// Copies of the record may share the history
+		h := *r.PasswordHistory
+		h.Entries = append([]PasswordHistoryEntry(nil), h.Entries...)
+		r.PasswordHistory = &h
+	}
+	if r.PasswordHistory.Enabled && r.Password != "" {
+		changed := r.PasswordModTime
+		if changed.IsZero() {
+			changed = r.CreationTime
+		}
+		r.PasswordHistory.Add(changed, r.Password)
+	}
+	r.Password = password
+	r.PasswordModTime = t
+	r.ModTime = t
+	if r.ExpiryInterval > 0 {
+		r.ExpiryTime = t.AddDate(0, 0, r.ExpiryInterval)
+	} else if !r.ExpiryTime.After(t) {
+		r.ExpiryTime = time.Time{}
+	}
+}
+
+// Bring the times of a record edited from old up to date. A changed
+// password goes through ChangePassword, keeping the old one in the history.
+func (r *Record) MarkEdited(old Record, t time.Time) {
+	if r.Password != old.Password {
+		password := r.Password
+		r.Password = old.Password
+		r.ChangePassword(password, t)
+	}
+	r.ModTime = t
+}
@@ -0,0 +1,161 @@
+package pwsafe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countIn(s, set string) int {
+	n := 0
+	for _, c := range s {
+		if strings.ContainsRune(set, c) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGeneratePassword(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		policy  PasswordPolicy
+		symbols string
+		length  int
+		allowed string
+		min     map[string]int
+	}{
+		{"default", DefaultPasswordPolicy, "", 20,
+			lowercaseChars + uppercaseChars + digitChars + DefaultSymbols,
+			map[string]int{lowercaseChars: 1, uppercaseChars: 1, digitChars: 1, DefaultSymbols: 1}},
+		{"no length", PasswordPolicy{UseDigits: true}, "", 20, digitChars, nil},
+		{"minimums", PasswordPolicy{UseLowercase: true, UseDigits: true, Length: 8, MinLowercase: 2, MinDigits: 6}, "", 8,
+			lowercaseChars + digitChars,
+			map[string]int{lowercaseChars: 2, digitChars: 6}},
+		{"own symbols", PasswordPolicy{UseLowercase: true, UseSymbols: true, Length: 12, MinSymbols: 3}, "!?", 12,
+			lowercaseChars + "!?",
+			map[string]int{"!?": 3}},
+		{"easy vision", PasswordPolicy{UseLowercase: true, UseUppercase: true, UseDigits: true, UseSymbols: true, UseEasyVision: true, Length: 40, MinDigits: 2}, "", 40,
+			easyLowercase + easyUppercase + easyDigits + EasyVisionSymbols,
+			map[string]int{easyDigits: 2}},
+		{"hex", PasswordPolicy{UseHexDigits: true, UseSymbols: true, Length: 32}, "", 32, hexChars, nil},
+		{"pronounceable", PasswordPolicy{UseLowercase: true, UseUppercase: true, UseDigits: true, MakePronounceable: true, Length: 10, MinUppercase: 2, MinDigits: 1}, "", 10,
+			lowercaseChars + uppercaseChars + digitChars,
+			map[string]int{uppercaseChars: 2, digitChars: 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				password, err := GeneratePassword(test.policy, test.symbols)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n := len([]rune(password)); n != test.length {
+					t.Fatalf("%q has %d characters, want %d", password, n, test.length)
+				}
+				if n := countIn(password, test.allowed); n != test.length {
+					t.Fatalf("%q has characters outside %q", password, test.allowed)
+				}
+				for set, min := range test.min {
+					if n := countIn(password, set); n < min {
+						t.Fatalf("%q has %d of %q, want at least %d", password, n, set, min)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := GeneratePassword(PasswordPolicy{Length: 10}, ""); err != ErrPolicyEmpty {
+		t.Errorf("empty policy: %v, want ErrPolicyEmpty", err)
+	}
+	if _, err := GeneratePassword(PasswordPolicy{UseDigits: true, Length: 4, MinDigits: 5}, ""); err == nil {
+		t.Errorf("accepted minimums longer than the password")
+	}
+}
+
+func TestRecordPolicy(t *testing.T) {
+	pin := PasswordPolicy{UseDigits: true, Length: 4}
+	safe := &Safe{Headers: Headers{PasswordPolicies: []NamedPasswordPolicy{{"pin", pin, "#"}}}}
+	own := PasswordPolicy{UseLowercase: true, Length: 30}
+	for _, test := range []struct {
+		record  Record
+		policy  PasswordPolicy
+		symbols string
+	}{
+		{Record{}, DefaultPasswordPolicy, ""},
+		{Record{PolicyName: "pin"}, pin, "#"},
+		{Record{PasswordPolicy: &own, Symbols: "!", PolicyName: "pin"}, own, "!"},
+	} {
+		policy, symbols, err := safe.RecordPolicy(test.record)
+		if err != nil || policy != test.policy || symbols != test.symbols {
+			t.Errorf("%+v: %+v, %q, %v, want %+v, %q", test.record, policy, symbols, err, test.policy, test.symbols)
+		}
+	}
+	if _, _, err := safe.RecordPolicy(Record{PolicyName: "missing"}); !errors.Is(err, ErrPolicyUnknown) {
+		t.Errorf("unknown policy: %v, want ErrPolicyUnknown", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	created := time.Unix(1500000000, 0)
+	now := created.Add(48 * time.Hour)
+
+	r := Record{Password: "first", CreationTime: created, ExpiryInterval: 10}
+	shared := &PasswordHistory{Enabled: true, Max: 2}
+	r.PasswordHistory = shared
+	r.ChangePassword("second", now)
+	r.ChangePassword("third", now.Add(time.Hour))
+	r.ChangePassword("fourth", now.Add(2*time.Hour))
+	if r.Password != "fourth" || !r.PasswordModTime.Equal(now.Add(2*time.Hour)) || !r.ModTime.Equal(r.PasswordModTime) {
+		t.Errorf("password %q changed %v, modified %v", r.Password, r.PasswordModTime, r.ModTime)
+	}
+	want := []PasswordHistoryEntry{{now, "second"}, {now.Add(time.Hour), "third"}}
+	if h := r.PasswordHistory.Entries; len(h) != 2 || h[0] != want[0] || h[1] != want[1] {
+		t.Errorf("history = %v, want %v", h, want)
+	}
+	if len(shared.Entries) != 0 {
+		t.Errorf("shared history changed: %v", shared.Entries)
+	}
+	if want := now.Add(2*time.Hour).AddDate(0, 0, 10); !r.ExpiryTime.Equal(want) {
+		t.Errorf("expiry = %v, want %v", r.ExpiryTime, want)
+	}
+
+	// Passed expiry times are cleared, future ones kept
+	r = Record{Password: "old", ExpiryTime: now.Add(-time.Hour)}
+	r.ChangePassword("new", now)
+	if !r.ExpiryTime.IsZero() {
+		t.Errorf("passed expiry kept: %v", r.ExpiryTime)
+	}
+	if h := r.PasswordHistory; h == nil || !h.Enabled || h.Max != DefaultHistoryMax || len(h.Entries) != 1 {
+		t.Errorf("new history = %+v", h)
+	}
+	r = Record{Password: "old", ExpiryTime: now.Add(time.Hour), PasswordHistory: &PasswordHistory{Max: 3}}
+	r.ChangePassword("new", now)
+	if !r.ExpiryTime.Equal(now.Add(time.Hour)) {
+		t.Errorf("future expiry changed: %v", r.ExpiryTime)
+	}
+	if len(r.PasswordHistory.Entries) != 0 {
+		t.Errorf("disabled history kept %v", r.PasswordHistory.Entries)
+	}
+}
+
+func TestMarkEdited(t *testing.T) {
+	created := time.Unix(1500000000, 0)
+	now := created.Add(time.Hour)
+	old := Record{Title: "x", Password: "old", CreationTime: created}
+
+	edited := old
+	edited.Title = "y"
+	edited.MarkEdited(old, now)
+	if !edited.ModTime.Equal(now) || !edited.PasswordModTime.IsZero() || edited.PasswordHistory != nil {
+		t.Errorf("title edit: modified %v, password changed %v, history %+v", edited.ModTime, edited.PasswordModTime, edited.PasswordHistory)
+	}
+
+	edited = old
+	edited.Password = "new"
+	edited.MarkEdited(old, now)
+	if edited.Password != "new" || !edited.PasswordModTime.Equal(now) || edited.PasswordHistory == nil ||
+		len(edited.PasswordHistory.Entries) != 1 || edited.PasswordHistory.Entries[0] != (PasswordHistoryEntry{created, "old"}) {
+		t.Errorf("password edit: %q changed %v, history %+v", edited.Password, edited.PasswordModTime, edited.PasswordHistory)
+	}
+}
@@ -0,0 +1,47 @@
+package pwsafe
+
+import "strings"
+
+// Split a folder path with '/' or '\' separators into its levels
+func folderLevels(path string) []string {
+	var levels []string
+	split := func(r rune) bool { return r == '/' || r == '\\' }
+	for _, level := range strings.FieldsFunc(path, split) {
+		if level = strings.TrimSpace(level); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// Join group levels into a Password Safe group path, where '.' separates
+// the levels and dots in names are escaped with a backslash.
+func joinGroup(levels []string) string {
+	escaped := make([]string, len(levels))
+	for i, level := range levels {
+		escaped[i] = strings.Replace(level, ".", "\\.", -1)
+	}
+	return strings.Join(escaped, ".")
+}
+
+// Split a Password Safe group path into its levels
+func splitGroup(group string) []string {
+	if group == "" {
+		return nil
+	}
+	var levels []string
+	var level strings.Builder
+	for i := 0; i < len(group); i++ {
+		switch {
+		case group[i] == '\\' && i+1 < len(group) && group[i+1] == '.':
+			level.WriteByte('.')
+			i++
+		case group[i] == '.':
+			levels = append(levels, level.String())
+			level.Reset()
+		default:
+			level.WriteByte(group[i])
+		}
+	}
+	return append(levels, level.String())
+}
@@ -0,0 +1,114 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoHistory is returned for safes that are not tracked in a git
+// repository.
+var ErrNoHistory = errors.New("safe is not tracked in a git repository")
+
+// GitHistory keeps versions of an encrypted safe as commits in the git
+// repository containing it. Only the encrypted file is ever committed, and
+// only once it is tracked; pwsafe never adds a safe to a repository itself.
+type GitHistory struct {
+	Dir  string // Top level of the work tree
+	File string // Path of the safe relative to Dir
+}
+
+// A Version is one commit of the safe
+type Version struct {
+	Rev     string
+	Time    time.Time
+	Subject string
+}
+
+// Returns the history of the safe at path, or ErrNoHistory if the file
+// is not tracked in a git work tree.
+func NewGitHistory(path string) (*GitHistory, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("git", "-C", filepath.Dir(abs), "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, ErrNoHistory
+	}
+	top := strings.TrimSpace(string(out))
+	rel, err := filepath.Rel(top, abs)
+	if err != nil {
+		return nil, err
+	}
+	h := &GitHistory{Dir: top, File: filepath.ToSlash(rel)}
+	if _, err := h.git("ls-files", "--error-unmatch", "--", h.File); err != nil {
+		return nil, ErrNoHistory
+	}
+	return h, nil
+}
+
+func (h *GitHistory) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", h.Dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Commit the current contents of the safe if they changed
+func (h *GitHistory) Commit(message string) error {
+	if _, err := h.git("add", "--", h.File); err != nil {
+		return err
+	}
+	if _, err := h.git("diff", "--cached", "--quiet", "--", h.File); err == nil {
+		return nil
+	}
+
+	_, err := h.git("commit", "-q", "-m", message, "--", h.File)
+	return err
+}
+
+// Versions of the safe, newest first
+func (h *GitHistory) Log() ([]Version, error) {
+	out, err := h.git("log", "--format=%H%x00%ct%x00%s", "--", h.File)
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.SplitN(line, "\x00", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		ts, _ := strconv.ParseInt(parts[1], 10, 64)
+		versions = append(versions, Version{Rev: parts[0], Time: time.Unix(ts, 0), Subject: parts[2]})
+	}
+	return versions, nil
+}
+
+// The encrypted safe as of rev. The rev is resolved to a commit first so
+// it can never be taken for an option of git show.
+func (h *GitHistory) Read(rev string) ([]byte, error) {
+	if strings.HasPrefix(rev, "-") {
+		return nil, fmt.Errorf("invalid revision %q", rev)
+	}
+	out, err := h.git("rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	return h.git("show", strings.TrimSpace(string(out))+":"+h.File)
+}
+
+// Commit message describing a save of safe
+func saveMessage(safe *Safe) string {
+	return fmt.Sprintf("Save by %s@%s with %s", safe.Headers.User, safe.Headers.Host, safe.Headers.ProgramSave)
+}
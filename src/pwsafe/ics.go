@@ -0,0 +1,61 @@
+package pwsafe
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// Password expiry dates as an iCalendar feed (RFC 5545), one all-day event
+// per expiring record. Only groups and titles are written, no secrets.
+
+// Write an iCalendar feed of the records' password expiry dates
+func WriteICS(w io.Writer, safe *Safe) error {
+	bw := bufio.NewWriter(w)
+	line := func(s string) {
+		// Fold lines longer than 75 octets without splitting UTF-8 sequences
+		for len(s) > 75 {
+			i := 75
+			for i > 0 && s[i]&0xc0 == 0x80 {
+				i--
+			}
+			bw.WriteString(s[:i] + "\r\n")
+			s = " " + s[i:]
+		}
+		bw.WriteString(s + "\r\n")
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//pwsafe//Password expiry//EN")
+	line("CALSCALE:GREGORIAN")
+	if safe.Headers.Name != "" {
+		line("X-WR-CALNAME:" + icsEscape(safe.Headers.Name))
+	}
+	for _, r := range safe.Records {
+		expiry := r.PasswordExpiry()
+		if expiry.IsZero() {
+			continue
+		}
+		day := expiry.Local()
+		line("BEGIN:VEVENT")
+		line("UID:" + r.UUID.String() + "-expiry@pwsafe")
+		line("DTSTAMP:" + stamp)
+		line("DTSTART;VALUE=DATE:" + day.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + day.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + icsEscape("Password expires: "+r.Ref()))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// Escape a TEXT value
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
@@ -0,0 +1,63 @@
+package pwsafe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestWriteICS(t *testing.T) {
+	changed := time.Date(2020, 1, 10, 12, 0, 0, 0, time.Local)
+	fixed := uuid.NewV4()
+	safe := &Safe{
+		Headers: Headers{Name: "Work; Home"},
+		Records: []Record{
+			{UUID: fixed, Group: "Web", Title: "fixed", Password: "secret", ExpiryTime: time.Date(2020, 3, 1, 18, 0, 0, 0, time.Local)},
+			{UUID: uuid.NewV4(), Group: "Web", Title: "interval", PasswordModTime: changed, ExpiryInterval: 30},
+			{UUID: uuid.NewV4(), Group: "Web", Title: "never", PasswordModTime: changed},
+			{UUID: uuid.NewV4(), Group: "Ünïcode", Title: strings.Repeat("long, title é ", 8)},
+		},
+	}
+	safe.Records[3].ExpiryTime = changed
+
+	var buf bytes.Buffer
+	if err := WriteICS(&buf, safe); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.String()
+	if !strings.HasSuffix(data, "END:VCALENDAR\r\n") {
+		t.Errorf("feed does not end with END:VCALENDAR")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if strings.Contains(line, "\n") {
+			t.Errorf("bare newline in %q", line)
+		}
+	}
+	if strings.Contains(data, "secret") {
+		t.Errorf("feed contains the password")
+	}
+	if strings.Count(data, "BEGIN:VEVENT") != 3 {
+		t.Errorf("%d events, want 3", strings.Count(data, "BEGIN:VEVENT"))
+	}
+
+	unfolded := strings.Replace(data, "\r\n ", "", -1)
+	for _, want := range []string{
+		`X-WR-CALNAME:Work\; Home`,
+		"UID:" + fixed.String() + "-expiry@pwsafe",
+		"DTSTART;VALUE=DATE:20200301\r\nDTEND;VALUE=DATE:20200302",
+		"SUMMARY:Password expires: Web/fixed",
+		"DTSTART;VALUE=DATE:20200209\r\nDTEND;VALUE=DATE:20200210",
+		"SUMMARY:Password expires: Web/interval",
+		`SUMMARY:Password expires: Ünïcode/` + strings.Repeat(`long\, title é `, 8),
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("feed lacks %q", want)
+		}
+	}
+}
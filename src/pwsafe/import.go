@@ -0,0 +1,102 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// What to do with an imported record that duplicates an existing one
+type DuplicatePolicy int
+
+const (
+	SkipDuplicates   DuplicatePolicy = iota // Keep the existing record
+	UpdateDuplicates                        // Overwrite the existing record
+	AddDuplicates                           // Add the imported record as well
+)
+
+// Parse a duplicate policy named "skip", "update" or "add"
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	switch name {
+	case "skip":
+		return SkipDuplicates, nil
+	case "update":
+		return UpdateDuplicates, nil
+	case "add":
+		return AddDuplicates, nil
+	}
+	return 0, fmt.Errorf("unknown duplicate policy %q", name)
+}
+
+// What happened to one imported record
+type ImportAction int
+
+const (
+	ImportAdded ImportAction = iota
+	ImportSkipped
+	ImportUpdated
+)
+
+func (a ImportAction) String() string {
+	switch a {
+	case ImportAdded:
+		return "add"
+	case ImportSkipped:
+		return "skip"
+	case ImportUpdated:
+		return "update"
+	}
+	return "unknown"
+}
+
+// The outcome of importing one record
+type ImportResult struct {
+	Action ImportAction
+	Record Record
+}
+
+// Add records to the safe.
+//
+// Records with the same group, title and username as an existing one, or
+// as one imported before them, are duplicates handled according to dup.
+// Missing UUIDs and creation times are filled in.
+func (s *Safe) Import(records []Record, dup DuplicatePolicy) []ImportResult {
+	results := make([]ImportResult, 0, len(records))
+	for _, record := range records {
+		if uuid.Equal(record.UUID, uuid.Nil) {
+			record.UUID = uuid.NewV4()
+		}
+		if record.CreationTime.IsZero() {
+			record.CreationTime = time.Now()
+		}
+
+		existing := s.findDuplicate(record)
+		switch {
+		case existing == nil || dup == AddDuplicates:
+			if _, err := s.FindUUID(record.UUID); err == nil {
+				record.UUID = uuid.NewV4()
+			}
+			s.Records = append(s.Records, record)
+			results = append(results, ImportResult{ImportAdded, record})
+		case dup == UpdateDuplicates:
+			record.UUID = existing.UUID
+			*existing = record
+			results = append(results, ImportResult{ImportUpdated, record})
+		default:
+			results = append(results, ImportResult{ImportSkipped, record})
+		}
+	}
+	return results
+}
+
+func (s *Safe) findDuplicate(record Record) *Record {
+	for i := range s.Records {
+		r := &s.Records[i]
+		if r.Group == record.Group && r.Title == record.Title && strings.EqualFold(r.Username, record.Username) {
+			return r
+		}
+	}
+	return nil
+}
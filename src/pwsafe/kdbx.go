@@ -0,0 +1,659 @@
+package pwsafe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// KeePass KDBX 4 databases, protected by a password only.
+//
+// The outer layer is a header followed by HMAC protected blocks of the
+// encrypted, optionally gzipped payload. The payload holds an inner
+// header with the key of the stream that protects passwords inside the
+// XML document that follows it.
+
+var ErrKDBXFormat = errors.New("not a KDBX 4 database")
+
+const (
+	kdbxSignature1 = 0x9AA2D903
+	kdbxSignature2 = 0xB54BFB67
+	kdbxVersion4   = 0x00040000
+
+	kdbxBlockSize = 1 << 20
+)
+
+// Outer header fields
+const (
+	kdbxHdrEnd         = 0
+	kdbxHdrCipher      = 2
+	kdbxHdrCompression = 3
+	kdbxHdrMasterSeed  = 4
+	kdbxHdrIV          = 7
+	kdbxHdrKDF         = 11
+)
+
+// Inner header fields
+const (
+	kdbxInnerEnd       = 0
+	kdbxInnerStreamID  = 1
+	kdbxInnerStreamKey = 2
+
+	kdbxStreamSalsa20  = 2
+	kdbxStreamChaCha20 = 3
+)
+
+// Cipher and key derivation UUIDs
+const (
+	kdbxCipherAES      = "31c1f2e6bf714350be5805216afc5aff"
+	kdbxCipherChaCha20 = "d6038a2b8b6f4cb5a524339a31dbb59a"
+	kdbxKDFAES         = "c9d9f39a628a4460bf740d08c18a4fea"
+	kdbxKDFAESLegacy   = "7c02bb8279a74ac0927d114a00648238"
+	kdbxKDFArgon2d     = "ef636ddf8c29444b91f7a9a403e30a0c"
+	kdbxKDFArgon2id    = "9e298b1956db4773b23dfc3ec6f0a1e6"
+)
+
+// Value types of a variant dictionary
+const (
+	kdbxVarUInt32 = 0x04
+	kdbxVarUInt64 = 0x05
+	kdbxVarBool   = 0x08
+	kdbxVarInt32  = 0x0c
+	kdbxVarInt64  = 0x0d
+	kdbxVarString = 0x18
+	kdbxVarBytes  = 0x42
+)
+
+// Limits on what a file may ask for before its HMAC is checked, well above
+// what KeePass and KeePassXC write
+const (
+	kdbxMaxHeaderField      = 1 << 20
+	kdbxMaxBlock            = 64 << 20
+	kdbxMaxArgon2Memory     = 1 << 30
+	kdbxMaxArgon2Iterations = 1 << 16
+)
+
+// KDBXOptions choose how a KDBX file is encrypted
+type KDBXOptions struct {
+	Cipher      string // "aes" or "chacha20"
+	KDF         string // "argon2d", "argon2id" or "aes"
+	Iterations  uint64 // Argon2 iterations or AES-KDF rounds
+	Memory      uint64 // Argon2 memory in bytes
+	Parallelism uint32 // Argon2 lanes
+}
+
+// The defaults of KeePassXC
+var DefaultKDBXOptions = KDBXOptions{
+	Cipher:      "aes",
+	KDF:         "argon2d",
+	Iterations:  10,
+	Memory:      64 << 20,
+	Parallelism: 2,
+}
+
+type kdbxHeader struct {
+	cipher     string
+	compressed bool
+	masterSeed []byte
+	iv         []byte
+	kdf        map[string]interface{}
+}
+
+// Read a KDBX 4 database protected by password
+func ReadKDBX(r io.Reader, password string) (*Safe, error) {
+	var raw bytes.Buffer
+	tr := io.TeeReader(r, &raw)
+
+	var sig [3]uint32
+	if err := binary.Read(tr, binary.LittleEndian, &sig); err != nil {
+		return nil, ErrKDBXFormat
+	}
+	if sig[0] != kdbxSignature1 || sig[1] != kdbxSignature2 || sig[2]>>16 != kdbxVersion4>>16 {
+		return nil, ErrKDBXFormat
+	}
+
+	var header kdbxHeader
+	for {
+		var id uint8
+		var length uint32
+		if err := binary.Read(tr, binary.LittleEndian, &id); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(tr, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		if length > kdbxMaxHeaderField {
+			return nil, fmt.Errorf("%w: header field of %d bytes", ErrKDBXFormat, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(tr, data); err != nil {
+			return nil, err
+		}
+		if id == kdbxHdrEnd {
+			break
+		}
+		switch id {
+		case kdbxHdrCipher:
+			header.cipher = hex.EncodeToString(data)
+		case kdbxHdrCompression:
+			header.compressed = len(data) == 4 && binary.LittleEndian.Uint32(data) == 1
+		case kdbxHdrMasterSeed:
+			header.masterSeed = data
+		case kdbxHdrIV:
+			header.iv = data
+		case kdbxHdrKDF:
+			kdf, err := readVariantDict(data)
+			if err != nil {
+				return nil, err
+			}
+			header.kdf = kdf
+		}
+	}
+	headerData := raw.Bytes()
+
+	var hash, mac [32]byte
+	if _, err := io.ReadFull(r, hash[:]); err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(headerData); !hmac.Equal(sum[:], hash[:]) {
+		return nil, fmt.Errorf("%w: corrupt header", ErrKDBXFormat)
+	}
+	if _, err := io.ReadFull(r, mac[:]); err != nil {
+		return nil, err
+	}
+
+	transformed, err := kdbxTransformKey(kdbxCompositeKey(password), header.kdf)
+	if err != nil {
+		return nil, err
+	}
+	encKey, hmacKey := kdbxKeys(header.masterSeed, transformed)
+	if !hmac.Equal(mac[:], kdbxBlockMAC(hmacKey, math.MaxUint64, headerData)) {
+		return nil, ErrInvalidPassword
+	}
+
+	var payload bytes.Buffer
+	for index := uint64(0); ; index++ {
+		var blockMAC [32]byte
+		var length int32
+		if _, err := io.ReadFull(r, blockMAC[:]); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		if length < 0 || length > kdbxMaxBlock {
+			return nil, fmt.Errorf("%w: invalid block length", ErrKDBXFormat)
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		var lenBytes [4]byte
+		binary.LittleEndian.PutUint32(lenBytes[:], uint32(length))
+		if !hmac.Equal(blockMAC[:], kdbxBlockMAC(hmacKey, index, lenBytes[:], block)) {
+			return nil, ErrHMACFailed
+		}
+		if length == 0 {
+			break
+		}
+		payload.Write(block)
+	}
+
+	plain, err := kdbxCrypt(header.cipher, encKey, header.iv, payload.Bytes(), false)
+	if err != nil {
+		return nil, err
+	}
+	var inner io.Reader = bytes.NewReader(plain)
+	if header.compressed {
+		gz, err := gzip.NewReader(inner)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		inner = gz
+	}
+
+	var streamID uint32
+	var streamKey []byte
+	for {
+		var id uint8
+		var length uint32
+		if err := binary.Read(inner, binary.LittleEndian, &id); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(inner, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		// Attachments may be large, so the field only takes the memory of
+		// the data actually there
+		data, err := ioutil.ReadAll(io.LimitReader(inner, int64(length)))
+		if err != nil {
+			return nil, err
+		} else if len(data) != int(length) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if id == kdbxInnerEnd {
+			break
+		}
+		switch id {
+		case kdbxInnerStreamID:
+			if len(data) == 4 {
+				streamID = binary.LittleEndian.Uint32(data)
+			}
+		case kdbxInnerStreamKey:
+			streamKey = data
+		}
+	}
+	var stream cipher.Stream
+	switch streamID {
+	case kdbxStreamChaCha20:
+		stream = kdbxInnerStream(streamKey)
+	case kdbxStreamSalsa20:
+		// Older KeePass versions still write it
+		stream = newKDBXSalsa20(streamKey)
+	default:
+		return nil, fmt.Errorf("unsupported KDBX inner stream %d", streamID)
+	}
+
+	doc, err := ioutil.ReadAll(inner)
+	if err != nil {
+		return nil, err
+	}
+	doc, err = kdbxProtect(doc, stream, false)
+	if err != nil {
+		return nil, err
+	}
+	return kdbxToSafe(doc)
+}
+
+// Write the safe as a KDBX 4 database protected by password
+func WriteKDBX(w io.Writer, safe *Safe, password string, opts KDBXOptions) error {
+	if opts.Cipher == "" {
+		opts.Cipher = DefaultKDBXOptions.Cipher
+	}
+	if opts.KDF == "" {
+		opts.KDF = DefaultKDBXOptions.KDF
+	}
+	if opts.Iterations == 0 {
+		opts.Iterations = DefaultKDBXOptions.Iterations
+		if opts.KDF == "aes" {
+			opts.Iterations = 100000
+		}
+	}
+	if opts.Memory == 0 {
+		opts.Memory = DefaultKDBXOptions.Memory
+	}
+	if opts.Parallelism == 0 {
+		opts.Parallelism = DefaultKDBXOptions.Parallelism
+	}
+
+	header := kdbxHeader{compressed: true, kdf: make(map[string]interface{})}
+	var ivSize int
+	switch opts.Cipher {
+	case "aes":
+		header.cipher, ivSize = kdbxCipherAES, aes.BlockSize
+	case "chacha20":
+		header.cipher, ivSize = kdbxCipherChaCha20, chacha20.NonceSize
+	default:
+		return fmt.Errorf("unsupported KDBX cipher %q", opts.Cipher)
+	}
+
+	random := make([]byte, 32+ivSize+32+64)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	header.masterSeed = random[:32]
+	header.iv = random[32 : 32+ivSize]
+	kdfSeed := random[32+ivSize : 64+ivSize]
+	streamKey := random[64+ivSize:]
+
+	switch opts.KDF {
+	case "aes":
+		header.kdf["$UUID"], _ = hex.DecodeString(kdbxKDFAES)
+		header.kdf["R"] = opts.Iterations
+		header.kdf["S"] = kdfSeed
+	case "argon2d", "argon2id":
+		id := kdbxKDFArgon2d
+		if opts.KDF == "argon2id" {
+			id = kdbxKDFArgon2id
+		}
+		header.kdf["$UUID"], _ = hex.DecodeString(id)
+		header.kdf["S"] = kdfSeed
+		header.kdf["P"] = opts.Parallelism
+		header.kdf["M"] = opts.Memory
+		header.kdf["I"] = opts.Iterations
+		header.kdf["V"] = uint32(argon2Version)
+	default:
+		return fmt.Errorf("unsupported KDBX key derivation %q", opts.KDF)
+	}
+
+	var head bytes.Buffer
+	binary.Write(&head, binary.LittleEndian, [3]uint32{kdbxSignature1, kdbxSignature2, kdbxVersion4})
+	cipherID, _ := hex.DecodeString(header.cipher)
+	writeTLV(&head, kdbxHdrCipher, cipherID)
+	writeTLV(&head, kdbxHdrCompression, le32(1))
+	writeTLV(&head, kdbxHdrMasterSeed, header.masterSeed)
+	writeTLV(&head, kdbxHdrIV, header.iv)
+	writeTLV(&head, kdbxHdrKDF, writeVariantDict(header.kdf))
+	writeTLV(&head, kdbxHdrEnd, []byte("\r\n\r\n"))
+
+	transformed, err := kdbxTransformKey(kdbxCompositeKey(password), header.kdf)
+	if err != nil {
+		return err
+	}
+	encKey, hmacKey := kdbxKeys(header.masterSeed, transformed)
+
+	doc, err := safeToKDBX(safe)
+	if err != nil {
+		return err
+	}
+	if doc, err = kdbxProtect(doc, kdbxInnerStream(streamKey), true); err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	var inner bytes.Buffer
+	writeTLV(&inner, kdbxInnerStreamID, le32(kdbxStreamChaCha20))
+	writeTLV(&inner, kdbxInnerStreamKey, streamKey)
+	writeTLV(&inner, kdbxInnerEnd, nil)
+	gz.Write(inner.Bytes())
+	gz.Write(doc)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	encrypted, err := kdbxCrypt(header.cipher, encKey, header.iv, payload.Bytes(), true)
+	if err != nil {
+		return err
+	}
+
+	out := bytes.NewBuffer(head.Bytes())
+	hash := sha256.Sum256(head.Bytes())
+	out.Write(hash[:])
+	out.Write(kdbxBlockMAC(hmacKey, math.MaxUint64, head.Bytes()))
+	for index := uint64(0); ; index++ {
+		n := len(encrypted)
+		if n > kdbxBlockSize {
+			n = kdbxBlockSize
+		}
+		block := encrypted[:n]
+		encrypted = encrypted[n:]
+		length := le32(uint32(n))
+		out.Write(kdbxBlockMAC(hmacKey, index, length, block))
+		out.Write(length)
+		out.Write(block)
+		if n == 0 {
+			break
+		}
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// Write a header field: type byte, 32-bit length and data
+func writeTLV(w *bytes.Buffer, id uint8, data []byte) {
+	w.WriteByte(id)
+	w.Write(le32(uint32(len(data))))
+	w.Write(data)
+}
+
+func kdbxCompositeKey(password string) []byte {
+	h := sha256.Sum256([]byte(password))
+	composite := sha256.Sum256(h[:])
+	return composite[:]
+}
+
+func kdbxTransformKey(composite []byte, kdf map[string]interface{}) ([]byte, error) {
+	id, _ := kdf["$UUID"].([]byte)
+	salt, _ := kdf["S"].([]byte)
+	switch hex.EncodeToString(id) {
+	case kdbxKDFAES, kdbxKDFAESLegacy:
+		rounds, _ := kdf["R"].(uint64)
+		block, err := aes.NewCipher(salt)
+		if err != nil {
+			return nil, err
+		}
+		key := append([]byte(nil), composite...)
+		for i := uint64(0); i < rounds; i++ {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+		sum := sha256.Sum256(key)
+		return sum[:], nil
+	case kdbxKDFArgon2d, kdbxKDFArgon2id:
+		mode := argon2d
+		if hex.EncodeToString(id) == kdbxKDFArgon2id {
+			mode = argon2id
+		}
+		lanes, _ := kdf["P"].(uint32)
+		memory, _ := kdf["M"].(uint64)
+		iterations, _ := kdf["I"].(uint64)
+		secret, _ := kdf["K"].([]byte)
+		data, _ := kdf["A"].([]byte)
+		if lanes == 0 || lanes > 255 || iterations > kdbxMaxArgon2Iterations || memory > kdbxMaxArgon2Memory {
+			return nil, fmt.Errorf("unsupported Argon2 parameters")
+		}
+		return argon2Key(mode, composite, salt, secret, data, uint32(iterations), uint32(memory/1024), uint8(lanes), 32), nil
+	}
+	return nil, fmt.Errorf("unsupported KDBX key derivation %x", id)
+}
+
+// The encryption key and the base of the block HMAC keys
+func kdbxKeys(masterSeed, transformed []byte) (encKey, hmacKey []byte) {
+	e := sha256.New()
+	e.Write(masterSeed)
+	e.Write(transformed)
+	h := sha512.New()
+	h.Write(masterSeed)
+	h.Write(transformed)
+	h.Write([]byte{1})
+	return e.Sum(nil), h.Sum(nil)
+}
+
+// HMAC of a block, keyed by its index. The header uses the largest index.
+func kdbxBlockMAC(hmacKey []byte, index uint64, data ...[]byte) []byte {
+	var idx [8]byte
+	binary.LittleEndian.PutUint64(idx[:], index)
+	key := sha512.New()
+	key.Write(idx[:])
+	key.Write(hmacKey)
+
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	if index != math.MaxUint64 {
+		mac.Write(idx[:])
+	}
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func kdbxCrypt(cipherID string, key, iv, data []byte, encrypt bool) ([]byte, error) {
+	switch cipherID {
+	case kdbxCipherAES:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aes.BlockSize {
+			return nil, fmt.Errorf("%w: invalid IV", ErrKDBXFormat)
+		}
+		if encrypt {
+			pad := aes.BlockSize - len(data)%aes.BlockSize
+			data = append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+			out := make([]byte, len(data))
+			cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+			return out, nil
+		}
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("%w: invalid payload length", ErrKDBXFormat)
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		pad := int(out[len(out)-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, ErrInvalidPassword
+		}
+		for _, b := range out[len(out)-pad:] {
+			if int(b) != pad {
+				return nil, ErrInvalidPassword
+			}
+		}
+		return out[:len(out)-pad], nil
+	case kdbxCipherChaCha20:
+		c, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		c.XORKeyStream(out, data)
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported KDBX cipher %s", cipherID)
+}
+
+// The ChaCha20 stream protecting values inside the XML document
+func kdbxInnerStream(key []byte) cipher.Stream {
+	h := sha512.Sum512(key)
+	c, _ := chacha20.NewUnauthenticatedCipher(h[:32], h[32:32+chacha20.NonceSize])
+	return c
+}
+
+// The Salsa20 stream of older databases, with KeePass's fixed nonce
+type kdbxSalsa20 struct {
+	key     [32]byte
+	counter [16]byte // nonce and block counter
+	block   [64]byte
+	used    int
+}
+
+func newKDBXSalsa20(key []byte) *kdbxSalsa20 {
+	s := &kdbxSalsa20{key: sha256.Sum256(key), used: 64}
+	copy(s.counter[:], []byte{0xe8, 0x30, 0x09, 0x4b, 0x97, 0x20, 0x5d, 0x2a})
+	return s
+}
+
+func (s *kdbxSalsa20) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == len(s.block) {
+			var zero [64]byte
+			salsa.XORKeyStream(s.block[:], zero[:], &s.counter, &s.key)
+			binary.LittleEndian.PutUint64(s.counter[8:], binary.LittleEndian.Uint64(s.counter[8:])+1)
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.block[s.used]
+		s.used++
+	}
+}
+
+func readVariantDict(data []byte) (map[string]interface{}, error) {
+	invalid := fmt.Errorf("%w: invalid variant dictionary", ErrKDBXFormat)
+	if len(data) < 2 || data[1] != 1 {
+		return nil, invalid
+	}
+	data = data[2:]
+	dict := make(map[string]interface{})
+	for {
+		if len(data) < 1 {
+			return nil, invalid
+		}
+		kind := data[0]
+		if kind == 0 {
+			return dict, nil
+		}
+		if len(data) < 5 {
+			return nil, invalid
+		}
+		n := int(binary.LittleEndian.Uint32(data[1:]))
+		data = data[5:]
+		if n > len(data)-4 {
+			return nil, invalid
+		}
+		name := string(data[:n])
+		data = data[n:]
+		n = int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if n > len(data) {
+			return nil, invalid
+		}
+		value := data[:n]
+		data = data[n:]
+
+		switch {
+		case kind == kdbxVarUInt32 && n == 4:
+			dict[name] = binary.LittleEndian.Uint32(value)
+		case kind == kdbxVarUInt64 && n == 8:
+			dict[name] = binary.LittleEndian.Uint64(value)
+		case kind == kdbxVarBool && n == 1:
+			dict[name] = value[0] != 0
+		case kind == kdbxVarInt32 && n == 4:
+			dict[name] = int32(binary.LittleEndian.Uint32(value))
+		case kind == kdbxVarInt64 && n == 8:
+			dict[name] = int64(binary.LittleEndian.Uint64(value))
+		case kind == kdbxVarString:
+			dict[name] = string(value)
+		case kind == kdbxVarBytes:
+			dict[name] = append([]byte(nil), value...)
+		default:
+			return nil, invalid
+		}
+	}
+}
+
+func writeVariantDict(dict map[string]interface{}) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0, 1})
+	// KeePass expects the KDF UUID first
+	names := []string{"$UUID"}
+	for name := range dict {
+		if name != "$UUID" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	for _, name := range names {
+		var kind byte
+		var value []byte
+		switch v := dict[name].(type) {
+		case uint32:
+			kind, value = kdbxVarUInt32, le32(v)
+		case uint64:
+			kind, value = kdbxVarUInt64, make([]byte, 8)
+			binary.LittleEndian.PutUint64(value, v)
+		case bool:
+			kind, value = kdbxVarBool, []byte{0}
+			if v {
+				value[0] = 1
+			}
+		case string:
+			kind, value = kdbxVarString, []byte(v)
+		case []byte:
+			kind, value = kdbxVarBytes, v
+		default:
+			continue
+		}
+		b.WriteByte(kind)
+		b.Write(le32(uint32(len(name))))
+		b.WriteString(name)
+		b.Write(le32(uint32(len(value))))
+		b.Write(value)
+	}
+	b.WriteByte(0)
+	return b.Bytes()
+}
@@ -0,0 +1,165 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// Cheap parameters, the tests check the formats and not the cost
+var testKDBXOptions = KDBXOptions{Iterations: 2, Memory: 1 << 20, Parallelism: 2}
+
+func testKDBXSafe() *Safe {
+	created := time.Unix(1500000000, 0)
+	return &Safe{
+		Headers: Headers{Name: "Test"},
+		Records: []Record{{
+			UUID:            uuid.NewV4(),
+			Group:           "Web.Mail",
+			Title:           "example",
+			Username:        "bob",
+			Password:        "new secret",
+			Url:             "https://example.com",
+			Notes:           "first line\nsecond line",
+			Email:           "bob@example.com",
+			CreationTime:    created,
+			ModTime:         created.Add(2 * time.Hour),
+			AccessTime:      created.Add(3 * time.Hour),
+			ExpiryTime:      created.Add(24 * time.Hour),
+			PasswordModTime: created.Add(2 * time.Hour),
+			PasswordHistory: &PasswordHistory{Enabled: true, Max: 3, Entries: []PasswordHistoryEntry{
+				{created.Add(time.Hour), "old secret"},
+			}},
+		}},
+	}
+}
+
+func writeTestKDBX(t *testing.T, safe *Safe, password string, opts KDBXOptions) []byte {
+	var buf bytes.Buffer
+	if err := WriteKDBX(&buf, safe, password, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestKDBXRoundTrip(t *testing.T) {
+	for _, cipher := range []string{"aes", "chacha20"} {
+		for _, kdf := range []string{"argon2d", "argon2id", "aes"} {
+			t.Run(cipher+"/"+kdf, func(t *testing.T) {
+				opts := testKDBXOptions
+				opts.Cipher, opts.KDF = cipher, kdf
+				if kdf == "aes" {
+					opts.Iterations = 1000
+				}
+				safe := testKDBXSafe()
+				data := writeTestKDBX(t, safe, "password", opts)
+
+				read, err := ReadKDBX(bytes.NewReader(data), "password")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(read.Records) != 1 {
+					t.Fatalf("read %d records, want 1", len(read.Records))
+				}
+				want, got := safe.Records[0], read.Records[0]
+				if fields := ChangedFields(want, got); len(fields) > 0 {
+					t.Errorf("fields changed: %v\nwant %+v\ngot  %+v", fields, want, got)
+				}
+
+				if _, err := ReadKDBX(bytes.NewReader(data), "wrong"); err != ErrInvalidPassword {
+					t.Errorf("wrong password: %v, want ErrInvalidPassword", err)
+				}
+			})
+		}
+	}
+}
+
+func TestKDBXLimits(t *testing.T) {
+	data := writeTestKDBX(t, testKDBXSafe(), "password", testKDBXOptions)
+
+	// The first header field follows the signature
+	field := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(field[13:], 0xffffffff)
+	if _, err := ReadKDBX(bytes.NewReader(field), "password"); !errors.Is(err, ErrKDBXFormat) {
+		t.Errorf("huge header field: %v, want ErrKDBXFormat", err)
+	}
+
+	// The first block follows the header, its hash and its HMAC
+	offset := 12
+	for {
+		id := data[offset]
+		length := int(binary.LittleEndian.Uint32(data[offset+1:]))
+		offset += 5 + length
+		if id == kdbxHdrEnd {
+			break
+		}
+	}
+	block := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(block[offset+64+32:], 0x7fffffff)
+	if _, err := ReadKDBX(bytes.NewReader(block), "password"); !errors.Is(err, ErrKDBXFormat) {
+		t.Errorf("huge block: %v, want ErrKDBXFormat", err)
+	}
+
+	id, _ := hex.DecodeString(kdbxKDFArgon2d)
+	for _, kdf := range []map[string]interface{}{
+		{"$UUID": id, "P": uint32(1), "M": uint64(1) << 40, "I": uint64(1)},
+		{"$UUID": id, "P": uint32(1), "M": uint64(1) << 20, "I": uint64(1) << 40},
+		{"$UUID": id, "P": uint32(0), "M": uint64(1) << 20, "I": uint64(1)},
+	} {
+		if _, err := kdbxTransformKey(kdbxCompositeKey("password"), kdf); err == nil {
+			t.Errorf("accepted Argon2 parameters %v", kdf)
+		}
+	}
+}
+
+// The Salsa20 inner stream gives the same key stream however the values
+// it protects are split
+func TestKDBXSalsa20Stream(t *testing.T) {
+	key := []byte("inner stream key")
+	want := make([]byte, 300)
+	counter := [16]byte{0xe8, 0x30, 0x09, 0x4b, 0x97, 0x20, 0x5d, 0x2a}
+	hashed := sha256.Sum256(key)
+	salsa.XORKeyStream(want, make([]byte, len(want)), &counter, &hashed)
+
+	stream := newKDBXSalsa20(key)
+	got := make([]byte, 0, len(want))
+	for _, n := range []int{1, 63, 64, 65, 7, 100} {
+		chunk := make([]byte, n)
+		stream.XORKeyStream(chunk, chunk)
+		got = append(got, chunk...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("key stream differs from Salsa20")
+	}
+}
+
+func TestKDBXPadding(t *testing.T) {
+	key, iv := make([]byte, 32), make([]byte, 16)
+	for _, padding := range [][]byte{
+		{3, 3, 3},
+		{2, 3, 3},
+		{3, 3, 4},
+		{0},
+	} {
+		plain := append(bytes.Repeat([]byte{'x'}, 16-len(padding)), padding...)
+		block, _ := aes.NewCipher(key)
+		data := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, plain)
+		out, err := kdbxCrypt(kdbxCipherAES, key, iv, data, false)
+		valid := bytes.Equal(padding, []byte{3, 3, 3})
+		if valid && (err != nil || len(out) != 13) {
+			t.Errorf("padding %v: %d bytes, %v", padding, len(out), err)
+		} else if !valid && err != ErrInvalidPassword {
+			t.Errorf("padding %v: %v, want ErrInvalidPassword", padding, err)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// The XML document inside a KDBX database and its mapping to a Safe.
+//
+// Groups become group paths. The standard strings map to record fields,
+// Email and RunCommand strings to theirs and otpauth URIs in otp strings
+// to the two-factor fields. Any other strings are appended to the notes
+// as "key: value" lines so they are not lost. Old
+// versions of an entry become its password history.
+
+type kdbxFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    kdbxRoot `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator           string                `xml:"Generator"`
+	DatabaseName        string                `xml:"DatabaseName"`
+	DatabaseDescription string                `xml:"DatabaseDescription"`
+	MemoryProtection    *kdbxMemoryProtection `xml:"MemoryProtection,omitempty"`
+	RecycleBinEnabled   string                `xml:"RecycleBinEnabled,omitempty"`
+	RecycleBinUUID      string                `xml:"RecycleBinUUID,omitempty"`
+}
+
+type kdbxMemoryProtection struct {
+	ProtectTitle    string
+	ProtectUserName string
+	ProtectPassword string
+	ProtectURL      string
+	ProtectNotes    string
+}
+
+type kdbxRoot struct {
+	Group kdbxGroup `xml:"Group"`
+}
+
+type kdbxGroup struct {
+	UUID    string      `xml:"UUID"`
+	Name    string      `xml:"Name"`
+	Times   kdbxTimes   `xml:"Times"`
+	Entries []kdbxEntry `xml:"Entry"`
+	Groups  []kdbxGroup `xml:"Group"`
+}
+
+type kdbxEntry struct {
+	UUID     string        `xml:"UUID"`
+	Times    kdbxTimes     `xml:"Times"`
+	Strings  []kdbxString  `xml:"String"`
+	AutoType *kdbxAutoType `xml:"AutoType,omitempty"`
+	History  *kdbxHistory  `xml:"History,omitempty"`
+}
+
+type kdbxHistory struct {
+	Entries []kdbxEntry `xml:"Entry"`
+}
+
+type kdbxString struct {
+	Key   string    `xml:"Key"`
+	Value kdbxValue `xml:"Value"`
+}
+
+type kdbxValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+type kdbxAutoType struct {
+	Enabled         string `xml:"Enabled"`
+	DefaultSequence string `xml:"DefaultSequence,omitempty"`
+}
+
+type kdbxTimes struct {
+	LastModificationTime string `xml:"LastModificationTime,omitempty"`
+	CreationTime         string `xml:"CreationTime,omitempty"`
+	LastAccessTime       string `xml:"LastAccessTime,omitempty"`
+	ExpiryTime           string `xml:"ExpiryTime,omitempty"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged,omitempty"`
+}
+
+// Seconds from 0001-01-01, the KDBX 4 epoch, to the Unix epoch
+const kdbxEpochOffset = 62135596800
+
+func kdbxTime(s string) time.Time {
+	if data, err := base64.StdEncoding.DecodeString(s); err == nil && len(data) == 8 {
+		return time.Unix(int64(binary.LittleEndian.Uint64(data))-kdbxEpochOffset, 0)
+	}
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+func formatKDBXTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], uint64(t.Unix()+kdbxEpochOffset))
+	return base64.StdEncoding.EncodeToString(data[:])
+}
+
+func kdbxBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+func kdbxUUID(s string) uuid.UUID {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return uuid.Nil
+	}
+	id, err := uuid.FromBytes(data)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+func formatKDBXUUID(id uuid.UUID) string {
+	return base64.StdEncoding.EncodeToString(id.Bytes())
+}
+
+// Encrypt or decrypt the values marked Protected="True" in document order
+func kdbxProtect(doc []byte, stream cipher.Stream, encrypt bool) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+	protected := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			protected = false
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && strings.EqualFold(attr.Value, "True") {
+					protected = true
+				}
+			}
+		case xml.EndElement:
+			protected = false
+		case xml.CharData:
+			if protected {
+				var data []byte
+				if encrypt {
+					data = []byte(t)
+				} else if data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(t))); err != nil {
+					return nil, fmt.Errorf("protected value: %v", err)
+				}
+				stream.XORKeyStream(data, data)
+				if encrypt {
+					data = []byte(base64.StdEncoding.EncodeToString(data))
+				}
+				tok = xml.CharData(data)
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				// Written again below with the encoding we use
+				continue
+			}
+		}
+		if err := enc.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out.Bytes()...), nil
+}
+
+func kdbxToSafe(doc []byte) (*Safe, error) {
+	var file kdbxFile
+	if err := xml.Unmarshal(doc, &file); err != nil {
+		return nil, err
+	}
+
+	safe := &Safe{}
+	safe.Headers.Name = file.Meta.DatabaseName
+	safe.Headers.Description = file.Meta.DatabaseDescription
+
+	var walk func(group kdbxGroup, path []string)
+	walk = func(group kdbxGroup, path []string) {
+		if file.Meta.RecycleBinUUID != "" && group.UUID == file.Meta.RecycleBinUUID {
+			return
+		}
+		name := joinGroup(path)
+		if len(path) > 0 && len(group.Entries) == 0 && len(group.Groups) == 0 {
+			safe.Headers.EmptyGroups = append(safe.Headers.EmptyGroups, name)
+		}
+		for _, entry := range group.Entries {
+			safe.Records = append(safe.Records, kdbxRecord(entry, name))
+		}
+		for _, sub := range group.Groups {
+			walk(sub, append(path[:len(path):len(path)], sub.Name))
+		}
+	}
+	walk(file.Root.Group, nil)
+	return safe, nil
+}
+
+func kdbxRecord(entry kdbxEntry, group string) Record {
+	record := Record{
+		UUID:         kdbxUUID(entry.UUID),
+		Group:        group,
+		CreationTime: kdbxTime(entry.Times.CreationTime),
+		ModTime:      kdbxTime(entry.Times.LastModificationTime),
+		AccessTime:   kdbxTime(entry.Times.LastAccessTime),
+	}
+	if uuid.Equal(record.UUID, uuid.Nil) {
+		record.UUID = uuid.NewV4()
+	}
+	if strings.EqualFold(entry.Times.Expires, "True") {
+		record.ExpiryTime = kdbxTime(entry.Times.ExpiryTime)
+	}
+	if entry.AutoType != nil {
+		record.Autotype = entry.AutoType.DefaultSequence
+	}
+
+	var extra []NoteField
+	for _, s := range entry.Strings {
+		value := s.Value.Text
+		switch s.Key {
+		case "Title":
+			record.Title = value
+		case "UserName":
+			record.Username = value
+		case "Password":
+			record.Password = value
+		case "URL":
+			record.Url = value
+		case "Notes":
+			record.Notes = value
+		case "Email", "email", "E-mail":
+			record.Email = value
+		case "RunCommand":
+			record.RunCommand = value
+		case "otp":
+			if err := record.SetOTPAuth(value); err != nil && value != "" {
+				extra = append(extra, NoteField{s.Key, value})
+			}
+		default:
+			if value != "" {
+				extra = append(extra, NoteField{s.Key, value})
+			}
+		}
+	}
+	record.Notes = FormatNoteFields(record.Notes, extra)
+
+	// The old versions of the entry give the password history and the
+	// time the current password was set
+	record.PasswordModTime = record.CreationTime
+	if entry.History != nil && len(entry.History.Entries) > 0 {
+		versions := entry.History.Entries
+		sort.SliceStable(versions, func(i, j int) bool {
+			return kdbxTime(versions[i].Times.LastModificationTime).Before(kdbxTime(versions[j].Times.LastModificationTime))
+		})
+		history := &PasswordHistory{Enabled: true}
+		current := true
+		for _, version := range versions {
+			password := ""
+			for _, s := range version.Strings {
+				if s.Key == "Password" {
+					password = s.Value.Text
+				}
+			}
+			modTime := kdbxTime(version.Times.LastModificationTime)
+			if password == record.Password {
+				if !current {
+					record.PasswordModTime = modTime
+					current = true
+				}
+				continue
+			}
+			current = false
+			record.PasswordModTime = record.ModTime
+			if n := len(history.Entries); password != "" && (n == 0 || history.Entries[n-1].Password != password) {
+				history.Entries = append(history.Entries, PasswordHistoryEntry{modTime, password})
+			}
+		}
+		history.Max = len(history.Entries)
+		if history.Max < 3 {
+			history.Max = 3
+		}
+		if len(history.Entries) > 0 {
+			record.PasswordHistory = history
+		}
+	}
+	return record
+}
+
+func safeToKDBX(safe *Safe) ([]byte, error) {
+	now := formatKDBXTime(time.Now())
+	name := safe.Headers.Name
+	if name == "" {
+		name = "Root"
+	}
+	file := kdbxFile{
+		Meta: kdbxMeta{
+			Generator:           "pwsafe",
+			DatabaseName:        safe.Headers.Name,
+			DatabaseDescription: safe.Headers.Description,
+			MemoryProtection: &kdbxMemoryProtection{
+				ProtectTitle:    "False",
+				ProtectUserName: "False",
+				ProtectPassword: "True",
+				ProtectURL:      "False",
+				ProtectNotes:    "False",
+			},
+			RecycleBinEnabled: "False",
+		},
+	}
+	root := &file.Root.Group
+	root.UUID = formatKDBXUUID(uuid.NewV4())
+	root.Name = name
+	root.Times = kdbxTimes{LastModificationTime: now, CreationTime: now, Expires: "False"}
+
+	// Find or create the group for a path below root
+	var find func(group *kdbxGroup, levels []string) *kdbxGroup
+	find = func(group *kdbxGroup, levels []string) *kdbxGroup {
+		if len(levels) == 0 {
+			return group
+		}
+		for i := range group.Groups {
+			if group.Groups[i].Name == levels[0] {
+				return find(&group.Groups[i], levels[1:])
+			}
+		}
+		group.Groups = append(group.Groups, kdbxGroup{
+			UUID:  formatKDBXUUID(uuid.NewV4()),
+			Name:  levels[0],
+			Times: kdbxTimes{LastModificationTime: now, CreationTime: now, Expires: "False"},
+		})
+		return find(&group.Groups[len(group.Groups)-1], levels[1:])
+	}
+
+	for _, group := range safe.Headers.EmptyGroups {
+		find(root, splitGroup(group))
+	}
+	for _, record := range safe.Records {
+		group := find(root, splitGroup(record.Group))
+		group.Entries = append(group.Entries, kdbxEntryOf(record))
+	}
+
+	doc, err := xml.MarshalIndent(&file, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), doc...), nil
+}
+
+func kdbxEntryOf(record Record) kdbxEntry {
+	id := record.UUID
+	if uuid.Equal(id, uuid.Nil) {
+		id = uuid.NewV4()
+	}
+	modTime := record.ModTime
+	if modTime.IsZero() {
+		modTime = record.CreationTime
+	}
+	entry := kdbxEntry{
+		UUID: formatKDBXUUID(id),
+		Times: kdbxTimes{
+			LastModificationTime: formatKDBXTime(modTime),
+			CreationTime:         formatKDBXTime(record.CreationTime),
+			LastAccessTime:       formatKDBXTime(record.AccessTime),
+			ExpiryTime:           formatKDBXTime(record.ExpiryTime),
+			Expires:              kdbxBool(!record.ExpiryTime.IsZero()),
+		},
+	}
+	add := func(key, value string, protected bool) {
+		s := kdbxString{Key: key, Value: kdbxValue{Text: value}}
+		if protected {
+			s.Value.Protected = "True"
+		}
+		entry.Strings = append(entry.Strings, s)
+	}
+	add("Title", record.Title, false)
+	add("UserName", record.Username, false)
+	add("Password", record.Password, true)
+	add("URL", record.Url, false)
+	add("Notes", record.Notes, false)
+	if record.Email != "" {
+		add("Email", record.Email, false)
+	}
+	if record.RunCommand != "" {
+		add("RunCommand", record.RunCommand, false)
+	}
+	if record.HasTOTP() {
+		add("otp", record.OTPAuthURI(), true)
+	}
+	if record.Autotype != "" {
+		entry.AutoType = &kdbxAutoType{Enabled: "True", DefaultSequence: record.Autotype}
+	}
+
+	if record.PasswordHistory != nil && len(record.PasswordHistory.Entries) > 0 {
+		entry.History = &kdbxHistory{}
+		for _, old := range record.PasswordHistory.Entries {
+			version := record
+			version.Password = old.Password
+			version.ModTime = old.Time
+			version.PasswordHistory = nil
+			version.UUID = id
+			entry.History.Entries = append(entry.History.Entries, kdbxEntryOf(version))
+		}
+	}
+	return entry
+}
@@ -0,0 +1,105 @@
+package pwsafe
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+// ErrKeyMismatch is returned when a stretched key does not belong to a file,
+// usually because the file was saved with a new salt or password.
+var ErrKeyMismatch = errors.New("key does not match file")
+
+var (
+	ErrNoCachedKey = errors.New("no cached key")
+	ErrNoKeyring   = errors.New("kernel keyring not supported")
+)
+
+// A Key is the master password stretched with a file's salt.
+//
+// It unlocks the file without the password and without repeating the
+// key stretching.
+type Key struct {
+	Salt       [32]byte
+	Iterations uint32
+	Stretched  [32]byte
+}
+
+// Size of a marshaled Key
+const keySize = 32 + 4 + 32
+
+// Returns a key for password with a new random salt
+func NewKey(password string, iter uint32) (*Key, error) {
+	var salt [32]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return nil, err
+	}
+	return stretchKey(password, salt, iter), nil
+}
+
+func stretchKey(password string, salt [32]byte, iter uint32) *Key {
+	key := &Key{Salt: salt, Iterations: iter}
+	copy(key.Stretched[:], computeStretchKey(salt[:], []byte(password), int(iter)))
+	return key
+}
+
+// Stretch password with the salt of a psafe3 file and verify it
+func ReadKey(inputfile, password string) (*Key, error) {
+	header, err := readFileHeader(inputfile)
+	if err != nil {
+		return nil, err
+	}
+	key := stretchKey(password, header.Salt, header.Iter)
+	if key.match(header) != nil {
+		return nil, ErrInvalidPassword
+	}
+	return key, nil
+}
+
+// Check the key still matches the salt and password hash of a psafe3 file
+func (k *Key) Matches(inputfile string) error {
+	header, err := readFileHeader(inputfile)
+	if err != nil {
+		return err
+	}
+	return k.match(header)
+}
+
+func (k *Key) match(header *psv3Header) error {
+	hashsk := sha256.Sum256(k.Stretched[:])
+	if k.Salt != header.Salt || k.Iterations != header.Iter ||
+		subtle.ConstantTimeCompare(hashsk[:], header.HashPPrime[:]) != 1 {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+func (k *Key) MarshalBinary() ([]byte, error) {
+	data := make([]byte, keySize)
+	copy(data, k.Salt[:])
+	binary.LittleEndian.PutUint32(data[32:], k.Iterations)
+	copy(data[36:], k.Stretched[:])
+	return data, nil
+}
+
+func (k *Key) UnmarshalBinary(data []byte) error {
+	if len(data) != keySize {
+		return errors.New("invalid key length")
+	}
+	copy(k.Salt[:], data)
+	k.Iterations = binary.LittleEndian.Uint32(data[32:])
+	copy(k.Stretched[:], data[36:])
+	return nil
+}
+
+func readFileHeader(inputfile string) (*psv3Header, error) {
+	infile, err := os.Open(inputfile)
+	if err != nil {
+		return nil, err
+	}
+	defer infile.Close()
+	return readPsv3Header(infile)
+}
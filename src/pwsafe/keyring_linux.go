@@ -0,0 +1,119 @@
+//go:build linux
+// +build linux
+
+package pwsafe
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Special keyring id of the session keyring. A variable so it can be
+// passed to syscalls as a sign extended uintptr.
+var keySpecSessionKeyring = -3
+
+const (
+	keyctlGetKeyringID = 0
+	keyctlRevoke       = 3
+	keyctlSetPerm      = 5
+	keyctlSearch       = 10
+	keyctlRead         = 11
+	keyctlSetTimeout   = 15
+
+	// Possessor may do everything, nobody else anything
+	keyPermPossessorAll = 0x3f000000
+)
+
+// Store the key for the safe called name in the session keyring,
+// expiring after timeout. The name is usually the Storage's String.
+func CacheKey(name string, key *Key, timeout time.Duration) error {
+	desc := keyringDesc(name)
+	// Without create, a process outside any login session gets the
+	// user session keyring instead of a new keyring dying with it.
+	keyring, err := keyctl(keyctlGetKeyringID, uintptr(keySpecSessionKeyring), 0, 0)
+	if err != nil {
+		return err
+	}
+	payload, _ := key.MarshalBinary()
+	id, err := addKey("user", desc, payload, keyring)
+	if err != nil {
+		return err
+	}
+	if _, err := keyctl(keyctlSetPerm, id, keyPermPossessorAll, 0); err != nil {
+		return err
+	}
+	if timeout > 0 {
+		if _, err := keyctl(keyctlSetTimeout, id, uintptr(timeout/time.Second), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the key for the safe called name from the session keyring.
+//
+// ErrNoCachedKey is returned when no key is cached or it has expired.
+func CachedKey(name string) (*Key, error) {
+	id, err := searchKey(name)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, keySize)
+	n, err := keyctl(keyctlRead, id, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+	var key Key
+	if err := key.UnmarshalBinary(buf[:n]); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Remove the key for the safe called name from the session keyring
+func ForgetKey(name string) error {
+	id, err := searchKey(name)
+	if err != nil {
+		return err
+	}
+	_, err = keyctl(keyctlRevoke, id, 0, 0)
+	return err
+}
+
+func searchKey(name string) (uintptr, error) {
+	desc := keyringDesc(name)
+	ptype, _ := syscall.BytePtrFromString("user")
+	pdesc, _ := syscall.BytePtrFromString(desc)
+	id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(keySpecSessionKeyring),
+		uintptr(unsafe.Pointer(ptype)), uintptr(unsafe.Pointer(pdesc)), 0, 0)
+	if errno == syscall.ENOKEY || errno == syscall.EKEYEXPIRED || errno == syscall.EKEYREVOKED {
+		return 0, ErrNoCachedKey
+	} else if errno != 0 {
+		return 0, errno
+	}
+	return id, nil
+}
+
+func keyringDesc(name string) string {
+	return "pwsafe:" + name
+}
+
+func addKey(keyType, desc string, payload []byte, keyring int) (uintptr, error) {
+	ptype, _ := syscall.BytePtrFromString(keyType)
+	pdesc, _ := syscall.BytePtrFromString(desc)
+	id, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY, uintptr(unsafe.Pointer(ptype)), uintptr(unsafe.Pointer(pdesc)),
+		uintptr(unsafe.Pointer(&payload[0])), uintptr(len(payload)), uintptr(keyring), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return id, nil
+}
+
+func keyctl(cmd int, id, arg3, arg4 uintptr) (int, error) {
+	r, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, uintptr(cmd), id, arg3, arg4, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package pwsafe
+
+import "time"
+
+// The kernel keyring is only available on Linux.
+
+func CacheKey(name string, key *Key, timeout time.Duration) error {
+	return ErrNoKeyring
+}
+
+func CachedKey(name string) (*Key, error) {
+	return nil, ErrNoCachedKey
+}
+
+func ForgetKey(name string) error {
+	return ErrNoKeyring
+}
@@ -0,0 +1,144 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrReadOnly is returned when saving a vault opened read-only.
+var ErrReadOnly = errors.New("safe was opened read-only")
+
+// LockInfo describes who holds the lock on a safe.
+//
+// Password Safe clients create a lock file next to a safe they have open
+// for writing, named like the safe with the extension replaced by ".plk"
+// and containing "user@host:pid".
+type LockInfo struct {
+	User string
+	Host string
+	PID  int
+}
+
+func (l LockInfo) String() string {
+	return fmt.Sprintf("%s@%s:%d", l.User, l.Host, l.PID)
+}
+
+// LockedError is returned when a safe is locked by another client.
+type LockedError struct {
+	Info LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("safe is locked by %s@%s (pid %d)", e.Info.User, e.Info.Host, e.Info.PID)
+}
+
+// Path of the lock file for the safe in file
+func LockPath(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".plk"
+}
+
+// Lock information for this process
+func currentLockInfo() LockInfo {
+	info := LockInfo{PID: os.Getpid()}
+	if u, err := user.Current(); err == nil {
+		info.User = u.Username
+	}
+	info.Host, _ = os.Hostname()
+	return info
+}
+
+func parseLockInfo(data string) (LockInfo, error) {
+	var info LockInfo
+	data = strings.TrimSpace(data)
+	at := strings.LastIndex(data, "@")
+	colon := strings.LastIndex(data, ":")
+	if at < 0 || colon < at {
+		return info, fmt.Errorf("invalid lock file contents %q", data)
+	}
+	pid, err := strconv.Atoi(data[colon+1:])
+	if err != nil {
+		return info, fmt.Errorf("invalid lock file contents %q", data)
+	}
+	info.User = data[:at]
+	info.Host = data[at+1 : colon]
+	info.PID = pid
+	return info, nil
+}
+
+// Returns who holds the lock on the safe in file, or nil if it is not locked
+func ReadLock(file string) (*LockInfo, error) {
+	data, err := ioutil.ReadFile(LockPath(file))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info, err := parseLockInfo(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// A lock is stale when it was taken on this host by a process that no
+// longer exists.
+func (l LockInfo) stale() bool {
+	host, _ := os.Hostname()
+	return strings.EqualFold(l.Host, host) && !processExists(l.PID)
+}
+
+// Create the lock file for the safe in file, removing a stale one.
+//
+// A *LockedError is returned if another client holds the lock.
+func CreateLock(file string) error {
+	path := LockPath(file)
+	me := currentLockInfo()
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, werr := f.WriteString(me.String())
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				os.Remove(path)
+			}
+			return werr
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		holder, rerr := ReadLock(file)
+		if rerr != nil || holder == nil {
+			// Unreadable or vanished, try once more
+			continue
+		}
+		if *holder == me {
+			return nil
+		}
+		if !holder.stale() {
+			return &LockedError{Info: *holder}
+		}
+		os.Remove(path)
+	}
+	return fmt.Errorf("unable to create lock file %s", path)
+}
+
+// Remove the lock file for the safe in file if this process holds it
+func RemoveLock(file string) error {
+	holder, err := ReadLock(file)
+	if err != nil || holder == nil {
+		return err
+	}
+	if *holder != currentLockInfo() {
+		return nil
+	}
+	return os.Remove(LockPath(file))
+}
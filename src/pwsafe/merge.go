@@ -0,0 +1,74 @@
+package pwsafe
+
+import "github.com/satori/go.uuid"
+
+// Three-way merge of two safes derived from base, matching records by UUID.
+//
+// A record changed or removed on one side only takes that side's version.
+// Records changed on both sides keep ours and add theirs as a copy with a
+// new UUID; those copies are returned as conflicts. Headers are ours.
+func Merge(base, ours, theirs *Safe) (*Safe, []Record) {
+	baseRecords := recordsByUUID(base)
+	ourRecords := recordsByUUID(ours)
+	theirRecords := recordsByUUID(theirs)
+
+	merged := *ours
+	merged.Records = nil
+	var conflicts []Record
+
+	for _, record := range ours.Records {
+		old, inBase := baseRecords[record.UUID]
+		their, inTheirs := theirRecords[record.UUID]
+		switch {
+		case !inBase:
+			// Added by us
+			merged.Records = append(merged.Records, record)
+		case !inTheirs:
+			// Removed by them, unless we changed it
+			if !sameRecord(old, record) {
+				merged.Records = append(merged.Records, record)
+			}
+		case sameRecord(old, record):
+			merged.Records = append(merged.Records, their)
+		case sameRecord(old, their) || sameRecord(record, their):
+			merged.Records = append(merged.Records, record)
+		default:
+			merged.Records = append(merged.Records, record)
+			their.UUID = uuid.NewV4()
+			their.Title += " (conflict)"
+			merged.Records = append(merged.Records, their)
+			conflicts = append(conflicts, their)
+		}
+	}
+
+	for _, record := range theirs.Records {
+		if _, ok := ourRecords[record.UUID]; ok {
+			continue
+		}
+		old, inBase := baseRecords[record.UUID]
+		if !inBase || !sameRecord(old, record) {
+			// Added by them, or removed by us but changed by them
+			merged.Records = append(merged.Records, record)
+		}
+	}
+	return &merged, conflicts
+}
+
+func recordsByUUID(safe *Safe) map[uuid.UUID]Record {
+	records := make(map[uuid.UUID]Record, len(safe.Records))
+	for _, record := range safe.Records {
+		records[record.UUID] = record
+	}
+	return records
+}
+
+// Records are the same for merging unless they differ in more than their
+// access time, which merely reading a record may change
+func sameRecord(a, b Record) bool {
+	for _, field := range ChangedFields(a, b) {
+		if field != "access_time" {
+			return false
+		}
+	}
+	return true
+}
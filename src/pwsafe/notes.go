@@ -0,0 +1,82 @@
+package pwsafe
+
+import (
+	"strings"
+)
+
+// A NoteField is a named value kept in a record's notes.
+//
+// Fields other password managers have but Password Safe lacks are stored
+// as a block of "key: value" lines after the free text of the notes,
+// separated from it by a blank line. Lines of multi-line values after the
+// first are indented by two spaces.
+type NoteField struct {
+	Key   string
+	Value string
+}
+
+// Split notes into their free text and the trailing block of fields
+func ParseNoteFields(notes string) (text string, fields []NoteField) {
+	lines := strings.Split(strings.TrimRight(notes, "\n"), "\n")
+	start := len(lines)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i] == "" {
+			break
+		}
+		if !strings.HasPrefix(lines[i], "  ") && noteKey(lines[i]) == "" {
+			return notes, nil
+		}
+		start = i
+	}
+	if start == len(lines) || strings.HasPrefix(lines[start], "  ") {
+		return notes, nil
+	}
+
+	for _, line := range lines[start:] {
+		if strings.HasPrefix(line, "  ") {
+			fields[len(fields)-1].Value += "\n" + line[2:]
+			continue
+		}
+		key := noteKey(line)
+		fields = append(fields, NoteField{key, strings.TrimPrefix(line[len(key)+1:], " ")})
+	}
+	text = strings.TrimRight(strings.Join(lines[:start], "\n"), "\n")
+	return text, fields
+}
+
+// The key of a "key: value" line, or "" if it is not one
+func noteKey(line string) string {
+	i := strings.Index(line, ":")
+	if i <= 0 || strings.TrimSpace(line[:i]) != line[:i] || (i+1 < len(line) && line[i+1] != ' ') {
+		return ""
+	}
+	return line[:i]
+}
+
+// Append fields to the free text of notes
+func FormatNoteFields(text string, fields []NoteField) string {
+	var lines []string
+	for _, f := range fields {
+		lines = append(lines, f.Key+": "+strings.Replace(f.Value, "\n", "\n  ", -1))
+	}
+	if len(lines) == 0 {
+		return text
+	}
+	if text != "" {
+		text += "\n\n"
+	}
+	return text + strings.Join(lines, "\n")
+}
+
+// Set the named field in notes, replacing its value if present and
+// appending it otherwise
+func SetNoteField(notes, key, value string) string {
+	text, fields := ParseNoteFields(notes)
+	for i, f := range fields {
+		if f.Key == key {
+			fields[i].Value = value
+			return FormatNoteFields(text, fields)
+		}
+	}
+	return FormatNoteFields(text, append(fields, NoteField{key, value}))
+}
@@ -0,0 +1,60 @@
+package pwsafe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNoteFields(t *testing.T) {
+	for _, test := range []struct {
+		notes  string
+		text   string
+		fields []NoteField
+	}{
+		{"", "", nil},
+		{"just text", "just text", nil},
+		{"pin: 1234", "", []NoteField{{"pin", "1234"}}},
+		{"text\n\npin: 1234\nempty:\nurl: http://x", "text", []NoteField{{"pin", "1234"}, {"empty", ""}, {"url", "http://x"}}},
+		{"text\n\naddress: 1 Main St\n  Springfield\nzip: 12345\n", "text", []NoteField{{"address", "1 Main St\nSpringfield"}, {"zip", "12345"}}},
+		{"first\n\n\nsecond\n\nkey: value", "first\n\n\nsecond", []NoteField{{"key", "value"}}},
+
+		// Not a block of fields
+		{"text\npin: 1234", "text\npin: 1234", nil},
+		{"text\n\npin: 1234\nno field", "text\n\npin: 1234\nno field", nil},
+		{"text\n\n  indented: x", "text\n\n  indented: x", nil},
+		{"text\n\nhttp://example.com", "text\n\nhttp://example.com", nil},
+		{"text\n\n: value", "text\n\n: value", nil},
+	} {
+		text, fields := ParseNoteFields(test.notes)
+		if text != test.text || !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("ParseNoteFields(%q) = %q, %v, want %q, %v", test.notes, text, fields, test.text, test.fields)
+		}
+		if fields == nil {
+			continue
+		}
+		notes := FormatNoteFields(text, fields)
+		if text2, fields2 := ParseNoteFields(notes); text2 != text || !reflect.DeepEqual(fields2, fields) {
+			t.Errorf("FormatNoteFields(%q, %v) = %q, which parses as %q, %v", text, fields, notes, text2, fields2)
+		}
+	}
+}
+
+func TestSetNoteField(t *testing.T) {
+	for _, test := range []struct {
+		notes, key, value, want string
+	}{
+		{"", "pin", "1234", "pin: 1234"},
+		{"text", "pin", "1234", "text\n\npin: 1234"},
+		{"text\n\npin: 1234\nzip: 1", "pin", "4321", "text\n\npin: 4321\nzip: 1"},
+		{"text\n\npin: 1234", "zip", "a\nb", "text\n\npin: 1234\nzip: a\n  b"},
+		{"text\npin: 1234", "pin", "1", "text\npin: 1234\n\npin: 1"},
+	} {
+		got := SetNoteField(test.notes, test.key, test.value)
+		if got != test.want {
+			t.Errorf("SetNoteField(%q, %s, %q) = %q, want %q", test.notes, test.key, test.value, got, test.want)
+		}
+		if value, ok := (Record{Notes: got}).noteField(test.key); !ok || value != test.value {
+			t.Errorf("SetNoteField(%q, %s, %q) reads back as %q, %v", test.notes, test.key, test.value, value, ok)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package pwsafe
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One-time passwords from a record's two-factor key, as in RFC 4226
+// (HOTP) and RFC 6238 (TOTP).
+
+var (
+	ErrNoTwoFactorKey = errors.New("record has no two-factor key")
+	ErrInvalidOTPAuth = errors.New("invalid otpauth URI")
+)
+
+// Hash algorithms of the TOTP configuration
+const (
+	TOTPSHA1   = 0
+	TOTPSHA256 = 1
+	TOTPSHA512 = 2
+)
+
+// Defaults for records that do not set the TOTP fields
+const (
+	DefaultTOTPLength   = 6
+	DefaultTOTPTimeStep = 30
+)
+
+var totpAlgorithms = []string{"SHA1", "SHA256", "SHA512"}
+
+func otpHash(algorithm uint8) func() hash.Hash {
+	switch algorithm {
+	case TOTPSHA256:
+		return sha256.New
+	case TOTPSHA512:
+		return sha512.New
+	}
+	return sha1.New
+}
+
+// The HOTP code of key for counter with the given number of digits
+func HOTP(key []byte, counter uint64, digits int, algorithm uint8) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(otpHash(algorithm), key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+// Decode a base32 secret as shown by services, ignoring case, spaces and
+// padding
+func DecodeOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+// Encode a secret in base32 without padding
+func EncodeOTPSecret(key []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+}
+
+// Reports whether the record has a two-factor key
+func (r Record) HasTOTP() bool {
+	return len(r.TwoFactorKey) > 0
+}
+
+// The number of digits of the record's TOTP codes
+func (r Record) TOTPDigits() int {
+	if r.TOTPLength > 0 {
+		return r.TOTPLength
+	}
+	return DefaultTOTPLength
+}
+
+// The time step of the record's TOTP codes
+func (r Record) TOTPPeriod() time.Duration {
+	if r.TOTPTimeStep > 0 {
+		return time.Duration(r.TOTPTimeStep) * time.Second
+	}
+	return DefaultTOTPTimeStep * time.Second
+}
+
+// The record's TOTP code at time t and how long it stays valid
+func (r Record) TOTP(t time.Time) (code string, remaining time.Duration, err error) {
+	if !r.HasTOTP() {
+		return "", 0, ErrNoTwoFactorKey
+	}
+	step := int64(r.TOTPPeriod() / time.Second)
+	elapsed := t.Unix() - r.TOTPStartTime.Unix()
+	if r.TOTPStartTime.IsZero() {
+		elapsed = t.Unix()
+	}
+	counter := elapsed / step
+	remaining = time.Duration(step-elapsed%step) * time.Second
+	return HOTP(r.TwoFactorKey, uint64(counter), r.TOTPDigits(), r.TOTPConfig&0x03), remaining, nil
+}
+
+// Set the two-factor fields from an otpauth://totp/ URI.
+//
+// A bare base32 secret is accepted as well.
+func (r *Record) SetOTPAuth(uri string) error {
+	uri = strings.TrimSpace(uri)
+	if !strings.HasPrefix(strings.ToLower(uri), "otpauth:") {
+		key, err := DecodeOTPSecret(uri)
+		if err != nil || len(key) == 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidOTPAuth, "invalid secret")
+		}
+		r.TwoFactorKey, r.TOTPConfig, r.TOTPLength, r.TOTPTimeStep = key, TOTPSHA1, 0, 0
+		return nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOTPAuth, err)
+	}
+	if !strings.EqualFold(u.Host, "totp") {
+		return fmt.Errorf("%w: only totp is supported, not %s", ErrInvalidOTPAuth, u.Host)
+	}
+	q := u.Query()
+	key, err := DecodeOTPSecret(q.Get("secret"))
+	if err != nil || len(key) == 0 {
+		return fmt.Errorf("%w: invalid secret", ErrInvalidOTPAuth)
+	}
+	algorithm := -1
+	for i, name := range totpAlgorithms {
+		if strings.EqualFold(q.Get("algorithm"), name) || (q.Get("algorithm") == "" && i == TOTPSHA1) {
+			algorithm = i
+		}
+	}
+	if algorithm < 0 {
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidOTPAuth, q.Get("algorithm"))
+	}
+	digits, period := 0, 0
+	if s := q.Get("digits"); s != "" {
+		if digits, err = strconv.Atoi(s); err != nil || digits < 1 || digits > 10 {
+			return fmt.Errorf("%w: invalid digits %s", ErrInvalidOTPAuth, s)
+		}
+	}
+	if s := q.Get("period"); s != "" {
+		if period, err = strconv.Atoi(s); err != nil || period < 1 || period > 255 {
+			return fmt.Errorf("%w: invalid period %s", ErrInvalidOTPAuth, s)
+		}
+	}
+	if digits == DefaultTOTPLength {
+		digits = 0
+	}
+	if period == DefaultTOTPTimeStep {
+		period = 0
+	}
+	r.TwoFactorKey, r.TOTPConfig, r.TOTPLength, r.TOTPTimeStep = key, uint8(algorithm), digits, period
+	return nil
+}
+
+// The record's two-factor fields as an otpauth://totp/ URI
+func (r Record) OTPAuthURI() string {
+	if !r.HasTOTP() {
+		return ""
+	}
+	issuer := r.Title
+	label := issuer
+	if r.Username != "" {
+		label += ":" + r.Username
+	}
+	q := url.Values{}
+	q.Set("secret", EncodeOTPSecret(r.TwoFactorKey))
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	if algorithm := r.TOTPConfig & 0x03; algorithm != TOTPSHA1 && int(algorithm) < len(totpAlgorithms) {
+		q.Set("algorithm", totpAlgorithms[algorithm])
+	}
+	q.Set("digits", strconv.Itoa(r.TOTPDigits()))
+	q.Set("period", strconv.Itoa(int(r.TOTPPeriod()/time.Second)))
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
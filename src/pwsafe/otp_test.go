@@ -0,0 +1,116 @@
+package pwsafe
+
+import (
+	"testing"
+	"time"
+)
+
+// The test vectors of RFC 4226, appendix D
+func TestHOTPRFC4226(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for counter, want := range []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	} {
+		if got := HOTP(key, uint64(counter), 6, TOTPSHA1); got != want {
+			t.Errorf("HOTP(%d) = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+// The test vectors of RFC 6238, appendix B
+func TestTOTPRFC6238(t *testing.T) {
+	keys := map[uint8]string{
+		TOTPSHA1:   "12345678901234567890",
+		TOTPSHA256: "12345678901234567890123456789012",
+		TOTPSHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	for _, test := range []struct {
+		time      int64
+		algorithm uint8
+		code      string
+	}{
+		{59, TOTPSHA1, "94287082"},
+		{59, TOTPSHA256, "46119246"},
+		{59, TOTPSHA512, "90693936"},
+		{1111111109, TOTPSHA1, "07081804"},
+		{1111111109, TOTPSHA256, "68084774"},
+		{1111111109, TOTPSHA512, "25091201"},
+		{1111111111, TOTPSHA1, "14050471"},
+		{1111111111, TOTPSHA256, "67062674"},
+		{1111111111, TOTPSHA512, "99943326"},
+		{1234567890, TOTPSHA1, "89005924"},
+		{1234567890, TOTPSHA256, "91819424"},
+		{1234567890, TOTPSHA512, "93441116"},
+		{2000000000, TOTPSHA1, "69279037"},
+		{2000000000, TOTPSHA256, "90698825"},
+		{2000000000, TOTPSHA512, "38618901"},
+		{20000000000, TOTPSHA1, "65353130"},
+		{20000000000, TOTPSHA256, "77737706"},
+		{20000000000, TOTPSHA512, "47863826"},
+	} {
+		r := Record{TwoFactorKey: []byte(keys[test.algorithm]), TOTPConfig: test.algorithm, TOTPLength: 8}
+		code, remaining, err := r.TOTP(time.Unix(test.time, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("%s at %d = %s, want %s", totpAlgorithms[test.algorithm], test.time, code, test.code)
+		}
+		if want := time.Duration(30-test.time%30) * time.Second; remaining != want {
+			t.Errorf("%s at %d remaining %v, want %v", totpAlgorithms[test.algorithm], test.time, remaining, want)
+		}
+	}
+
+	if _, _, err := (Record{}).TOTP(time.Now()); err != ErrNoTwoFactorKey {
+		t.Errorf("no key: %v, want ErrNoTwoFactorKey", err)
+	}
+}
+
+func TestOTPAuth(t *testing.T) {
+	for _, test := range []struct {
+		uri    string
+		valid  bool
+		config uint8
+		digits int
+		period time.Duration
+	}{
+		{"JBSWY3DPEHPK3PXP", true, TOTPSHA1, 6, 30 * time.Second},
+		{"jbsw y3dp ehpk 3pxp", true, TOTPSHA1, 6, 30 * time.Second},
+		{"otpauth://totp/Example:bob?secret=JBSWY3DPEHPK3PXP&issuer=Example", true, TOTPSHA1, 6, 30 * time.Second},
+		{"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=SHA256&digits=8&period=60", true, TOTPSHA256, 8, time.Minute},
+		{"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=sha512", true, TOTPSHA512, 6, 30 * time.Second},
+		{"otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP&counter=1", false, 0, 0, 0},
+		{"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5", false, 0, 0, 0},
+		{"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=11", false, 0, 0, 0},
+		{"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&period=0", false, 0, 0, 0},
+		{"otpauth://totp/x", false, 0, 0, 0},
+		{"not base32!", false, 0, 0, 0},
+	} {
+		var r Record
+		err := r.SetOTPAuth(test.uri)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: accepted", test.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.uri, err)
+			continue
+		}
+		if EncodeOTPSecret(r.TwoFactorKey) != "JBSWY3DPEHPK3PXP" || r.TOTPConfig != test.config ||
+			r.TOTPDigits() != test.digits || r.TOTPPeriod() != test.period {
+			t.Errorf("%s: key %s, config %d, %d digits, period %v", test.uri,
+				EncodeOTPSecret(r.TwoFactorKey), r.TOTPConfig, r.TOTPDigits(), r.TOTPPeriod())
+		}
+
+		// The URI of the record sets the same fields
+		var back Record
+		if err := back.SetOTPAuth(r.OTPAuthURI()); err != nil {
+			t.Errorf("%s: %v", r.OTPAuthURI(), err)
+		} else if fields := ChangedFields(r, back); len(fields) > 0 {
+			t.Errorf("%s: fields changed: %v", r.OTPAuthURI(), fields)
+		}
+	}
+}
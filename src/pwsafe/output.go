@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"hash"
 	"io"
-	"log"
 	"os"
 	"os/user"
 	"time"
@@ -23,12 +22,27 @@ const iterations = 2048
 
 // Write the password safe to an encrypted psafe3 file
 func OutputFile(outputfile, password string, safe Safe) error {
+	key, err := NewKey(password, iterations)
+	if err != nil {
+		return err
+	}
+	return OutputFileKey(outputfile, key, safe)
+}
+
+// Write the password safe to an encrypted psafe3 file using an already
+// stretched key. The key's salt and iterations are stored in the file.
+func OutputFileKey(outputfile string, key *Key, safe Safe) error {
 	outfile, err := os.Create(outputfile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer outfile.Close()
 
+	return writeSafe(outfile, key, &safe)
+}
+
+// Encrypt safe to outfile, stamping the last save headers
+func writeSafe(outfile io.Writer, key *Key, safe *Safe) error {
 	safe.Headers.LastSave = time.Now()
 	safe.Headers.ProgramSave = "pwsafe 0.1"
 
@@ -43,19 +57,18 @@ func OutputFile(outputfile, password string, safe Safe) error {
 
 	fmt.Fprint(outfile, "PWS3")
 
-	var randbytes [112]byte
+	var randbytes [80]byte
 	if _, rerr := rand.Read(randbytes[:]); rerr != nil {
 		return rerr
 	}
-	salt := randbytes[:32]
-	iv := randbytes[32:48]
-	k := randbytes[48:80]
-	l := randbytes[80:]
+	iv := randbytes[:16]
+	k := randbytes[16:48]
+	l := randbytes[48:]
 
-	outfile.Write(salt)
-	binary.Write(outfile, binary.LittleEndian, uint32(iterations))
+	outfile.Write(key.Salt[:])
+	binary.Write(outfile, binary.LittleEndian, key.Iterations)
 
-	sk := computeStretchKey(salt[:], []byte(password), iterations)
+	sk := key.Stretched[:]
 	hashsk := sha256.Sum256(sk)
 	outfile.Write(hashsk[:])
 
@@ -89,11 +102,34 @@ func OutputFile(outputfile, password string, safe Safe) error {
 	writeField(outfile, engine, hmacEngine, 0x06, []byte(safe.Headers.ProgramSave))
 	writeField(outfile, engine, hmacEngine, 0x07, []byte(safe.Headers.User))
 	writeField(outfile, engine, hmacEngine, 0x08, []byte(safe.Headers.Host))
+	if !uuid.Equal(safe.Headers.UUID, uuid.Nil) {
+		writeField(outfile, engine, hmacEngine, 0x01, safe.Headers.UUID.Bytes())
+	}
+	writeField(outfile, engine, hmacEngine, 0x09, []byte(safe.Headers.Name))
+	writeField(outfile, engine, hmacEngine, 0x0a, []byte(safe.Headers.Description))
+	if len(safe.Headers.PasswordPolicies) > 0 {
+		writeField(outfile, engine, hmacEngine, 0x10, []byte(encodePasswordPolicies(safe.Headers.PasswordPolicies)))
+	}
+	for _, group := range safe.Headers.EmptyGroups {
+		writeField(outfile, engine, hmacEngine, 0x11, []byte(group))
+	}
+	if len(safe.Headers.Templates) > 0 {
+		writeField(outfile, engine, hmacEngine, 0xe0, []byte(FormatTemplates(safe.Headers.Templates)))
+	}
+	for _, field := range safe.Headers.Unknown {
+		if field.Type == HdrTypeRecordTemplates && len(safe.Headers.Templates) > 0 {
+			continue
+		}
+		writeField(outfile, engine, hmacEngine, uint8(field.Type), field.Data)
+	}
 	engine.CryptBlocks(blockData[:], endSection[:])
 	outfile.Write(blockData[:])
 
 	for _, record := range safe.Records {
-		id := uuid.NewV4()
+		id := record.UUID
+		if uuid.Equal(id, uuid.Nil) {
+			id = uuid.NewV4()
+		}
 		writeField(outfile, engine, hmacEngine, 0x01, id.Bytes())
 		writeField(outfile, engine, hmacEngine, 0x02, []byte(record.Group))
 		writeField(outfile, engine, hmacEngine, 0x03, []byte(record.Title))
@@ -108,6 +144,43 @@ func OutputFile(outputfile, password string, safe Safe) error {
 		writeField(outfile, engine, hmacEngine, 0x0d, []byte(record.Url))
 		writeField(outfile, engine, hmacEngine, 0x14, []byte(record.Email))
 
+		writeTime(outfile, engine, hmacEngine, 0x08, record.PasswordModTime)
+		writeTime(outfile, engine, hmacEngine, 0x09, record.AccessTime)
+		writeTime(outfile, engine, hmacEngine, 0x0a, record.ExpiryTime)
+		writeTime(outfile, engine, hmacEngine, 0x0c, record.ModTime)
+		if record.ExpiryInterval > 0 {
+			binary.Write(&buf, binary.LittleEndian, uint32(record.ExpiryInterval))
+			writeField(outfile, engine, hmacEngine, 0x11, buf.Bytes())
+			buf.Reset()
+		}
+		writeField(outfile, engine, hmacEngine, 0x0e, []byte(record.Autotype))
+		writeField(outfile, engine, hmacEngine, 0x12, []byte(record.RunCommand))
+		if record.PasswordHistory != nil {
+			writeField(outfile, engine, hmacEngine, 0x0f, []byte(record.PasswordHistory.encode()))
+		}
+		if record.PasswordPolicy != nil {
+			writeField(outfile, engine, hmacEngine, 0x10, []byte(record.PasswordPolicy.encode()))
+		}
+		writeField(outfile, engine, hmacEngine, 0x18, []byte(record.PolicyName))
+		writeField(outfile, engine, hmacEngine, 0x16, []byte(record.Symbols))
+		if record.Protected {
+			writeField(outfile, engine, hmacEngine, 0x15, []byte{1})
+		}
+		if record.HasTOTP() {
+			writeField(outfile, engine, hmacEngine, 0x1b, record.TwoFactorKey)
+			writeField(outfile, engine, hmacEngine, 0x1c, []byte{record.TOTPConfig})
+			if record.TOTPLength > 0 {
+				writeField(outfile, engine, hmacEngine, 0x1d, []byte{uint8(record.TOTPLength)})
+			}
+			if record.TOTPTimeStep > 0 {
+				writeField(outfile, engine, hmacEngine, 0x1e, []byte{uint8(record.TOTPTimeStep)})
+			}
+			writeTime(outfile, engine, hmacEngine, 0x1f, record.TOTPStartTime)
+		}
+		for _, field := range record.Unknown {
+			writeField(outfile, engine, hmacEngine, uint8(field.Type), field.Data)
+		}
+
 		engine.CryptBlocks(blockData[:], endSection[:])
 		outfile.Write(blockData[:])
 	}
@@ -118,6 +191,16 @@ func OutputFile(outputfile, password string, safe Safe) error {
 	return nil
 }
 
+// Write a time_t field unless t is zero
+func writeTime(w io.Writer, engine cipher.BlockMode, hmacEngine hash.Hash, ftype uint8, t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(t.Unix()))
+	return writeField(w, engine, hmacEngine, ftype, buf[:])
+}
+
 func writeField(w io.Writer, engine cipher.BlockMode, hmacEngine hash.Hash, ftype uint8, fdata []byte) error {
 	if len(fdata) < 1 {
 		return nil
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"time"
 
@@ -13,10 +13,9 @@ import (
 
 // Parse a psafe3 file
 func ParseFile(inputfile, password string) (*Safe, error) {
-	var safe Safe
 	infile, err := os.Open(inputfile)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer infile.Close()
 
@@ -24,7 +23,44 @@ func ParseFile(inputfile, password string) (*Safe, error) {
 	if rerr != nil {
 		return nil, rerr
 	}
+	return parse(r)
+}
+
+// Parse a psafe3 file using an already stretched key
+func ParseFileKey(inputfile string, key *Key) (*Safe, error) {
+	infile, err := os.Open(inputfile)
+	if err != nil {
+		return nil, err
+	}
+	defer infile.Close()
+
+	r, rerr := NewReaderKey(infile, key)
+	if rerr != nil {
+		return nil, rerr
+	}
+	return parse(r)
+}
+
+// Parse a psafe3 file from r
+func Parse(r io.Reader, password string) (*Safe, error) {
+	pr, err := NewReader(r, password)
+	if err != nil {
+		return nil, err
+	}
+	return parse(pr)
+}
+
+// Parse a psafe3 file from r using an already stretched key
+func ParseKey(r io.Reader, key *Key) (*Safe, error) {
+	pr, err := NewReaderKey(r, key)
+	if err != nil {
+		return nil, err
+	}
+	return parse(pr)
+}
 
+func parse(r *Reader) (*Safe, error) {
+	var safe Safe
 	headers, herr := readHeaders(r)
 	if herr != nil {
 		return nil, herr
@@ -67,8 +103,37 @@ func readHeaders(r *Reader) (Headers, error) {
 			headers.User = string(field.Data)
 		case HdrTypeLastSaveHost:
 			headers.Host = string(field.Data)
+		case HdrTypeUUID:
+			id, uerr := uuid.FromBytes(field.Data)
+			if uerr != nil {
+				return headers, uerr
+			}
+			headers.UUID = id
+		case HdrTypeDatabaseName:
+			headers.Name = string(field.Data)
+		case HdrTypeDatabaseDesc:
+			headers.Description = string(field.Data)
+		case HdrTypePasswordPolicies:
+			policies, perr := parsePasswordPolicies(string(field.Data))
+			if perr != nil {
+				return headers, perr
+			}
+			headers.PasswordPolicies = policies
+		case HdrTypeEmptyGroups:
+			headers.EmptyGroups = append(headers.EmptyGroups, string(field.Data))
+		case HdrTypeRecordTemplates:
+			templates, terr := ParseTemplates(string(field.Data))
+			if terr != nil {
+				// Written back as read unless templates replace it
+				headers.Unknown = append(headers.Unknown, copyField(field))
+				headers.Problems = append(headers.Problems, fmt.Sprintf("record templates: %v", terr))
+				break
+			}
+			headers.Templates = templates
 		case FldTypeEndOfEntry:
 			return headers, nil
+		default:
+			headers.Unknown = append(headers.Unknown, copyField(field))
 		}
 	}
 }
@@ -119,8 +184,65 @@ func readRecord(r *Reader) (Record, error) {
 			record.Url = string(field.Data)
 		case RecTypeEmail:
 			record.Email = string(field.Data)
+		case RecTypePasswordModTime:
+			record.PasswordModTime, _ = parseTimeT(field.Data)
+		case RecTypeAccessTime:
+			record.AccessTime, _ = parseTimeT(field.Data)
+		case RecTypeExpiryTime:
+			record.ExpiryTime, _ = parseTimeT(field.Data)
+		case RecTypeModTime:
+			record.ModTime, _ = parseTimeT(field.Data)
+		case RecTypeExpiryInterval:
+			if len(field.Data) == 4 {
+				record.ExpiryInterval = int(binary.LittleEndian.Uint32(field.Data))
+			}
+		case RecTypeAutotype:
+			record.Autotype = string(field.Data)
+		case RecTypeRunCommand:
+			record.RunCommand = string(field.Data)
+		case RecTypePasswordHistory:
+			history, herr := parsePasswordHistory(string(field.Data))
+			if herr != nil {
+				return record, herr
+			}
+			record.PasswordHistory = history
+		case RecTypePasswordPolicy:
+			policy, perr := parsePasswordPolicy(string(field.Data))
+			if perr != nil {
+				return record, perr
+			}
+			record.PasswordPolicy = policy
+		case RecTypePolicyName:
+			record.PolicyName = string(field.Data)
+		case RecTypeSymbols:
+			record.Symbols = string(field.Data)
+		case RecTypeProtected:
+			record.Protected = len(field.Data) > 0 && field.Data[0] != 0
+		case RecTypeTwoFactorKey:
+			record.TwoFactorKey = append([]byte(nil), field.Data...)
+		case RecTypeTOTPConfig:
+			if len(field.Data) == 1 {
+				record.TOTPConfig = field.Data[0]
+			}
+		case RecTypeTOTPLength:
+			if len(field.Data) == 1 {
+				record.TOTPLength = int(field.Data[0])
+			}
+		case RecTypeTOTPTimeStep:
+			if len(field.Data) == 1 {
+				record.TOTPTimeStep = int(field.Data[0])
+			}
+		case RecTypeTOTPStartTime:
+			record.TOTPStartTime, _ = parseTimeT(field.Data)
 		case FldTypeEndOfEntry:
 			return record, nil
+		default:
+			record.Unknown = append(record.Unknown, copyField(field))
 		}
 	}
 }
+
+// Copy of a field whose data may be reused by the reader
+func copyField(field Field) Field {
+	return Field{Type: field.Type, Data: append([]byte(nil), field.Data...)}
+}
@@ -0,0 +1,100 @@
+package pwsafe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Keys of "key: value" lines in pass entries and the fields they set
+var passFields = map[string]string{
+	"login":    "username",
+	"username": "username",
+	"user":     "username",
+	"url":      "url",
+	"website":  "url",
+	"email":    "email",
+	"e-mail":   "email",
+	"mail":     "email",
+}
+
+// Read the entries of a pass password store, decrypting each with gpg.
+//
+// Directories become groups and file names titles. The first line of an
+// entry is the password; "key: value" lines with a key such as login or
+// url set the matching field, an otpauth:// line as written by pass-otp
+// the two-factor fields, and the remaining lines become the notes.
+func ReadPassStore(dir, gpg string) ([]Record, error) {
+	var records []Record
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || filepath.Ext(path) != ".gpg" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, strings.TrimSuffix(path, ".gpg"))
+		if err != nil {
+			return err
+		}
+		levels := strings.Split(filepath.ToSlash(rel), "/")
+		data, err := passDecrypt(gpg, path)
+		if err != nil {
+			return err
+		}
+		record := parsePassEntry(string(data))
+		record.Group = joinGroup(levels[:len(levels)-1])
+		record.Title = levels[len(levels)-1]
+		record.ModTime = info.ModTime()
+		record.PasswordModTime = info.ModTime()
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func passDecrypt(gpg, path string) ([]byte, error) {
+	cmd := exec.Command(gpg, "--quiet", "--yes", "--decrypt", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func parsePassEntry(data string) Record {
+	lines := strings.Split(strings.TrimRight(data, "\n"), "\n")
+	record := Record{Password: lines[0]}
+	var notes []string
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "otpauth://") && !record.HasTOTP() {
+			if err := record.SetOTPAuth(line); err == nil {
+				continue
+			}
+		}
+		if key := noteKey(line); key != "" {
+			if field, ok := passFields[strings.ToLower(key)]; ok {
+				value := strings.TrimSpace(line[len(key)+1:])
+				if p, _ := record.fieldPtr(field); *p == "" {
+					*p = value
+					continue
+				}
+			}
+		}
+		notes = append(notes, line)
+	}
+	record.Notes = strings.TrimSpace(strings.Join(notes, "\n"))
+	return record
+}
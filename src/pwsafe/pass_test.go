@@ -0,0 +1,87 @@
+package pwsafe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParsePassEntry(t *testing.T) {
+	for _, test := range []struct {
+		entry string
+		want  Record
+	}{
+		{"secret\n", Record{Password: "secret"}},
+		{"secret\nlogin: bob\nURL: https://example.com\nE-Mail: bob@example.com\n",
+			Record{Password: "secret", Username: "bob", Url: "https://example.com", Email: "bob@example.com"}},
+		{"secret\nuser: bob\nusername: alice\npin: 1234\n\nfree text",
+			Record{Password: "secret", Username: "bob", Notes: "username: alice\npin: 1234\n\nfree text"}},
+		{"secret\notpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=8\notpauth://totp/y?secret=ABC",
+			Record{Password: "secret", TwoFactorKey: []byte("Hello!\xde\xad\xbe\xef"), TOTPLength: 8, Notes: "otpauth://totp/y?secret=ABC"}},
+		{"secret\notpauth://totp/x?secret=nope!", Record{Password: "secret", Notes: "otpauth://totp/x?secret=nope!"}},
+	} {
+		got := parsePassEntry(test.entry)
+		if fields := ChangedFields(test.want, got); len(fields) > 0 {
+			t.Errorf("%q: fields changed: %v\nwant %+v\ngot  %+v", test.entry, fields, test.want, got)
+		}
+	}
+}
+
+// Read a store with a stand-in for gpg that prints the files as they are
+func TestReadPassStore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the gpg stand-in is a shell script")
+	}
+	dir := t.TempDir()
+	gpg := filepath.Join(dir, "gpg")
+	if err := ioutil.WriteFile(gpg, []byte("#!/bin/sh\nfor f; do :; done\ncat \"$f\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(dir, "store")
+	for path, data := range map[string]string{
+		"top.gpg":               "one\n",
+		"web/mail/example.gpg":  "two\nlogin: bob\n",
+		"web/dot.name.gpg":      "three\n",
+		"web/.hidden.gpg":       "no\n",
+		".git/objects/x.gpg":    "no\n",
+		"web/readme.txt":        "no\n",
+		".gpg-id":               "key\n",
+		"web/mail/.gpg-id":      "key\n",
+		"web/empty/nothing.txt": "no\n",
+	} {
+		path = filepath.Join(store, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadPassStore(store, gpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Ref()+" "+r.Password+" "+r.Username)
+	}
+	want := []string{"/top one ", "web/dot.name three ", "web.mail/example two bob"}
+	if len(got) != len(want) {
+		t.Fatalf("records %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d = %q, want %q", i+1, got[i], want[i])
+		}
+	}
+	if records[0].ModTime.IsZero() || !records[0].PasswordModTime.Equal(records[0].ModTime) {
+		t.Errorf("times %v and %v, want the file's", records[0].ModTime, records[0].PasswordModTime)
+	}
+
+	if _, err := ReadPassStore(store, filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("read store without gpg")
+	}
+}
@@ -0,0 +1,210 @@
+package pwsafe
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrNoPassword is returned when a password source has no password to give.
+var ErrNoPassword = errors.New("no password available")
+
+// A PasswordSource supplies the master password for a safe.
+//
+// The prompt is a short human readable description of what is being asked
+// for. Sources that are not interactive ignore it.
+type PasswordSource interface {
+	Password(prompt string) (string, error)
+}
+
+// PasswordFunc adapts an ordinary function to a PasswordSource.
+type PasswordFunc func(prompt string) (string, error)
+
+func (f PasswordFunc) Password(prompt string) (string, error) {
+	return f(prompt)
+}
+
+// PasswordFD reads the password from the first line of an already open file descriptor.
+type PasswordFD int
+
+func (fd PasswordFD) Password(prompt string) (string, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", int(fd)))
+	if f == nil {
+		return "", fmt.Errorf("invalid password file descriptor %d", int(fd))
+	}
+	defer f.Close()
+	return readPasswordLine(f)
+}
+
+// PasswordFile reads the password from the first line of a file.
+type PasswordFile string
+
+func (name PasswordFile) Password(prompt string) (string, error) {
+	f, err := os.Open(string(name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readPasswordLine(f)
+}
+
+// PasswordEnv reads the password from the named environment variable.
+//
+// Environment variables are visible to other processes of the same user and
+// are inherited by children, so a warning is printed to stderr every time.
+type PasswordEnv string
+
+func (name PasswordEnv) Password(prompt string) (string, error) {
+	pw, ok := os.LookupEnv(string(name))
+	if !ok {
+		return "", ErrNoPassword
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: reading master password from environment variable %s.\n", string(name))
+	fmt.Fprintf(os.Stderr, "WARNING: the environment is visible to other processes and inherited by children. Do not use this interactively.\n")
+	return pw, nil
+}
+
+// AskPass runs an external program in the style of SSH_ASKPASS.
+//
+// The prompt is passed as the only argument and the password is read from
+// the program's standard output.
+type AskPass string
+
+func (prog AskPass) Password(prompt string) (string, error) {
+	cmd := exec.Command(string(prog), prompt)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("askpass %s: %v", string(prog), err)
+	}
+	return readPasswordLine(bytes.NewReader(out))
+}
+
+// Pinentry asks for the password through a pinentry program using the
+// Assuan protocol.
+type Pinentry struct {
+	Program     string // Defaults to "pinentry"
+	Title       string
+	Description string
+}
+
+func (p Pinentry) Password(prompt string) (string, error) {
+	prog := p.Program
+	if prog == "" {
+		prog = "pinentry"
+	}
+	cmd := exec.Command(prog)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("pinentry %s: %v", prog, err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	conn := &assuanConn{w: stdin, r: bufio.NewReader(stdout)}
+	if _, err := conn.response(); err != nil {
+		return "", err
+	}
+
+	var setup []string
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		setup = append(setup, "OPTION ttyname="+tty)
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		setup = append(setup, "OPTION ttytype="+term)
+	}
+	if p.Title != "" {
+		setup = append(setup, "SETTITLE "+assuanEscape(p.Title))
+	}
+	if p.Description != "" {
+		setup = append(setup, "SETDESC "+assuanEscape(p.Description))
+	}
+	if prompt != "" {
+		setup = append(setup, "SETPROMPT "+assuanEscape(strings.TrimSpace(prompt)))
+	}
+	for _, line := range setup {
+		if _, err := conn.command(line); err != nil {
+			return "", err
+		}
+	}
+
+	pin, err := conn.command("GETPIN")
+	if err != nil {
+		return "", err
+	}
+	conn.command("BYE")
+	return pin, nil
+}
+
+type assuanConn struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c *assuanConn) command(line string) (string, error) {
+	if _, err := fmt.Fprintf(c.w, "%s\n", line); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// Reads lines up to the final OK or ERR and returns the collected data lines.
+func (c *assuanConn) response() (string, error) {
+	var data bytes.Buffer
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("pinentry: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "ERR "):
+			return "", fmt.Errorf("pinentry: %s", line[4:])
+		case strings.HasPrefix(line, "D "):
+			d, derr := url.PathUnescape(line[2:])
+			if derr != nil {
+				return "", fmt.Errorf("pinentry: %v", derr)
+			}
+			data.WriteString(d)
+		}
+		// Status (S) and comment (#) lines are ignored
+	}
+}
+
+func assuanEscape(s string) string {
+	r := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	return r.Replace(s)
+}
+
+// Reads the first line of r, without the line ending.
+func readPasswordLine(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	line := string(data)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" {
+		return "", ErrNoPassword
+	}
+	return line, nil
+}
@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package pwsafe
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Verify the process on the other end of a Unix socket runs as the current user
+func checkPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var cerr error
+	err = raw.Control(func(fd uintptr) {
+		cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer pid %d runs as uid %d", cred.Pid, cred.Uid)
+	}
+	return nil
+}
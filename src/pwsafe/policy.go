@@ -0,0 +1,162 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Password policy flags as stored in psafe3 files
+const (
+	policyUseLowercase      = 0x8000
+	policyUseUppercase      = 0x4000
+	policyUseDigits         = 0x2000
+	policyUseSymbols        = 0x1000
+	policyUseHexDigits      = 0x0800
+	policyUseEasyVision     = 0x0400
+	policyMakePronounceable = 0x0200
+)
+
+// Reads the fixed width hex numbers and counted strings that psafe3
+// uses inside password history and policy fields.
+type hexFields struct {
+	s   []rune
+	err error
+}
+
+func (h *hexFields) int(width int) int {
+	if h.err != nil {
+		return 0
+	}
+	if len(h.s) < width {
+		h.err = fmt.Errorf("truncated field")
+		return 0
+	}
+	n, err := strconv.ParseUint(string(h.s[:width]), 16, 32)
+	if err != nil {
+		h.err = err
+		return 0
+	}
+	h.s = h.s[width:]
+	return int(n)
+}
+
+func (h *hexFields) string(length int) string {
+	if h.err != nil {
+		return ""
+	}
+	if len(h.s) < length {
+		h.err = fmt.Errorf("truncated field")
+		return ""
+	}
+	s := string(h.s[:length])
+	h.s = h.s[length:]
+	return s
+}
+
+// Parse a password history field: "fmmnn" followed by nn entries of a
+// time_t and a length in hex and the password.
+func parsePasswordHistory(data string) (*PasswordHistory, error) {
+	h := &hexFields{s: []rune(data)}
+	history := &PasswordHistory{Enabled: h.int(1) != 0, Max: h.int(2)}
+	n := h.int(2)
+	for i := 0; i < n && h.err == nil; i++ {
+		t := h.int(8)
+		password := h.string(h.int(4))
+		history.Entries = append(history.Entries, PasswordHistoryEntry{time.Unix(int64(t), 0), password})
+	}
+	if h.err != nil {
+		return nil, fmt.Errorf("password history: %v", h.err)
+	}
+	return history, nil
+}
+
+func (history *PasswordHistory) encode() string {
+	var b strings.Builder
+	enabled := 0
+	if history.Enabled {
+		enabled = 1
+	}
+	fmt.Fprintf(&b, "%01x%02x%02x", enabled, history.Max, len(history.Entries))
+	for _, entry := range history.Entries {
+		fmt.Fprintf(&b, "%08x%04x%s", uint32(entry.Time.Unix()), len([]rune(entry.Password)), entry.Password)
+	}
+	return b.String()
+}
+
+func (h *hexFields) policy() PasswordPolicy {
+	flags := h.int(4)
+	return PasswordPolicy{
+		UseLowercase:      flags&policyUseLowercase != 0,
+		UseUppercase:      flags&policyUseUppercase != 0,
+		UseDigits:         flags&policyUseDigits != 0,
+		UseSymbols:        flags&policyUseSymbols != 0,
+		UseHexDigits:      flags&policyUseHexDigits != 0,
+		UseEasyVision:     flags&policyUseEasyVision != 0,
+		MakePronounceable: flags&policyMakePronounceable != 0,
+		Length:            h.int(3),
+		MinLowercase:      h.int(3),
+		MinUppercase:      h.int(3),
+		MinDigits:         h.int(3),
+		MinSymbols:        h.int(3),
+	}
+}
+
+// Parse a record's password policy field: "ffffnnnllluuudddsss"
+func parsePasswordPolicy(data string) (*PasswordPolicy, error) {
+	h := &hexFields{s: []rune(data)}
+	policy := h.policy()
+	if h.err != nil {
+		return nil, fmt.Errorf("password policy: %v", h.err)
+	}
+	return &policy, nil
+}
+
+func (p PasswordPolicy) encode() string {
+	var flags int
+	for flag, set := range map[int]bool{
+		policyUseLowercase:      p.UseLowercase,
+		policyUseUppercase:      p.UseUppercase,
+		policyUseDigits:         p.UseDigits,
+		policyUseSymbols:        p.UseSymbols,
+		policyUseHexDigits:      p.UseHexDigits,
+		policyUseEasyVision:     p.UseEasyVision,
+		policyMakePronounceable: p.MakePronounceable,
+	} {
+		if set {
+			flags |= flag
+		}
+	}
+	return fmt.Sprintf("%04x%03x%03x%03x%03x%03x", flags, p.Length,
+		p.MinLowercase, p.MinUppercase, p.MinDigits, p.MinSymbols)
+}
+
+// Parse the named password policies header: a count followed by the
+// name, policy and symbols of each.
+func parsePasswordPolicies(data string) ([]NamedPasswordPolicy, error) {
+	h := &hexFields{s: []rune(data)}
+	n := h.int(2)
+	var policies []NamedPasswordPolicy
+	for i := 0; i < n && h.err == nil; i++ {
+		var policy NamedPasswordPolicy
+		policy.Name = h.string(h.int(2))
+		policy.PasswordPolicy = h.policy()
+		policy.Symbols = h.string(h.int(2))
+		policies = append(policies, policy)
+	}
+	if h.err != nil {
+		return nil, fmt.Errorf("password policies: %v", h.err)
+	}
+	return policies, nil
+}
+
+func encodePasswordPolicies(policies []NamedPasswordPolicy) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%02x", len(policies))
+	for _, policy := range policies {
+		fmt.Fprintf(&b, "%02x%s%s%02x%s", len([]rune(policy.Name)), policy.Name,
+			policy.PasswordPolicy.encode(), len([]rune(policy.Symbols)), policy.Symbols)
+	}
+	return b.String()
+}
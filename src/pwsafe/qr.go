@@ -0,0 +1,476 @@
+package pwsafe
+
+import (
+	"errors"
+)
+
+// QR codes as in ISO/IEC 18004, encoding data in byte mode. Used to move
+// secrets and otpauth:// URIs to phones without typing them.
+
+var ErrQRTooLong = errors.New("data too long for a QR code")
+
+// Error correction levels of QR codes
+type QRLevel int
+
+const (
+	QRLevelL QRLevel = iota // recovers 7% of the codewords
+	QRLevelM                // 15%
+	QRLevelQ                // 25%
+	QRLevelH                // 30%
+)
+
+// Error correction codewords per block and number of blocks by level and
+// version, version 0 being unused
+var (
+	qrECCPerBlock = [4][41]int{
+		{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	qrBlocks = [4][41]int{
+		{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+	// The level as written in the format information
+	qrLevelBits = [4]int{1, 0, 3, 2}
+)
+
+// A QR code, a square of dark and light modules
+type QRCode struct {
+	Size    int
+	modules []bool
+}
+
+// Reports whether the module at column x and row y is dark.
+//
+// Modules outside the code are light, as is the quiet zone around it.
+func (q *QRCode) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < q.Size && y < q.Size && q.modules[y*q.Size+x]
+}
+
+// Encode data as a QR code of the smallest version that holds it at the
+// given error correction level
+func EncodeQR(data []byte, level QRLevel) (*QRCode, error) {
+	for version := 1; version <= 40; version++ {
+		if 4+qrCountBits(version)+8*len(data) <= 8*qrDataCodewords(version, level) {
+			return encodeQR(data, level, version, -1), nil
+		}
+	}
+	return nil, ErrQRTooLong
+}
+
+// Encode data in a QR code of the given version. A negative mask selects
+// the mask with the lowest penalty.
+func encodeQR(data []byte, level QRLevel, version, mask int) *QRCode {
+	q := newQRMatrix(version)
+	codewords := qrAddECC(qrDataBits(data, level, version), level, version)
+	q.placeData(codewords)
+
+	if mask < 0 {
+		best := -1
+		for m := 0; m < 8; m++ {
+			q.applyMask(m)
+			q.drawFormat(level, m)
+			if penalty := q.penalty(); best < 0 || penalty < best {
+				best, mask = penalty, m
+			}
+			q.applyMask(m)
+		}
+	}
+	q.applyMask(mask)
+	q.drawFormat(level, mask)
+	return &q.QRCode
+}
+
+// Bits of the character count in byte mode
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// Number of codewords of a version, data and error correction together
+func qrCodewords(version int) int {
+	bits := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		bits -= (25*align-10)*align - 55
+		if version >= 7 {
+			bits -= 36
+		}
+	}
+	return bits / 8
+}
+
+func qrDataCodewords(version int, level QRLevel) int {
+	return qrCodewords(version) - qrECCPerBlock[level][version]*qrBlocks[level][version]
+}
+
+// The data codewords: mode, count, data, terminator and padding
+func qrDataBits(data []byte, level QRLevel, version int) []byte {
+	var bits []bool
+	put := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>uint(i)&1 == 1)
+		}
+	}
+	put(0x4, 4)
+	put(len(data), qrCountBits(version))
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	capacity := 8 * qrDataCodewords(version, level)
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	out := make([]byte, len(bits)/8, capacity/8)
+	for i, bit := range bits {
+		if bit {
+			out[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	for pad := byte(0xec); len(out) < cap(out); pad ^= 0xec ^ 0x11 {
+		out = append(out, pad)
+	}
+	return out
+}
+
+// Split the data into blocks, add Reed-Solomon error correction to each
+// and interleave them
+func qrAddECC(data []byte, level QRLevel, version int) []byte {
+	blocks := qrBlocks[level][version]
+	eccLen := qrECCPerBlock[level][version]
+	total := qrCodewords(version)
+	short := blocks - total%blocks
+	shortLen := total / blocks
+	divisor := rsDivisor(eccLen)
+
+	var dataBlocks, eccBlocks [][]byte
+	for i, k := 0, 0; i < blocks; i++ {
+		n := shortLen - eccLen
+		if i >= short {
+			n++
+		}
+		dataBlocks = append(dataBlocks, data[k:k+n])
+		eccBlocks = append(eccBlocks, rsRemainder(data[k:k+n], divisor))
+		k += n
+	}
+
+	out := make([]byte, 0, total)
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, block := range eccBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// Multiply in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// The generator polynomial of degree n, without its leading coefficient
+func rsDivisor(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < n {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// A QR code under construction, knowing which modules are function
+// patterns rather than data
+type qrMatrix struct {
+	QRCode
+	function []bool
+}
+
+func newQRMatrix(version int) *qrMatrix {
+	size := 17 + 4*version
+	q := &qrMatrix{QRCode{size, make([]bool, size*size)}, make([]bool, size*size)}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && y >= 0 && x < size && y < size {
+					d := qrDist(dx, dy)
+					q.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+
+	align := qrAlignment(version)
+	for i, ay := range align {
+		for j, ax := range align {
+			if i == 0 && j == 0 || i == 0 && j == len(align)-1 || i == len(align)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(ax+dx, ay+dy, qrDist(dx, dy) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format information until the mask is known
+	q.drawFormat(0, 0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1f25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bits>>uint(i)&1 == 1)
+			q.set(b, a, bits>>uint(i)&1 == 1)
+		}
+	}
+	return q
+}
+
+func qrDist(dx, dy int) int {
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// Centre coordinates of the alignment patterns
+func qrAlignment(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	result := make([]int, n)
+	result[0] = 6
+	for i, pos := n-1, 17+4*version-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// Set a function module
+func (q *qrMatrix) set(x, y int, dark bool) {
+	q.modules[y*q.Size+x] = dark
+	q.function[y*q.Size+x] = true
+}
+
+func (q *qrMatrix) drawFormat(level QRLevel, mask int) {
+	data := qrLevelBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+
+	size := q.Size
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, size-15+i, bit(i))
+	}
+	q.set(8, size-8, true)
+}
+
+// Place the codewords in the zigzag order of the standard
+func (q *qrMatrix) placeData(codewords []byte) {
+	size := q.Size
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = size - 1 - vert
+				}
+				if !q.function[y*size+x] && i < len(codewords)*8 {
+					q.modules[y*size+x] = codewords[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// Flip the data modules selected by mask; applying it twice undoes it
+func (q *qrMatrix) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y*q.Size+x] {
+				q.modules[y*q.Size+x] = !q.modules[y*q.Size+x]
+			}
+		}
+	}
+}
+
+// The penalty score used to choose the mask: long runs of one colour,
+// 2x2 blocks, patterns resembling finders and an unbalanced dark ratio
+func (q *qrMatrix) penalty() int {
+	size := q.Size
+	penalty := 0
+	line := make([]bool, size)
+	for dir := 0; dir < 2; dir++ {
+		for i := 0; i < size; i++ {
+			for j := 0; j < size; j++ {
+				if dir == 0 {
+					line[j] = q.Black(j, i)
+				} else {
+					line[j] = q.Black(i, j)
+				}
+			}
+			penalty += qrLinePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := q.Black(x, y)
+			if c {
+				dark++
+			}
+			if x+1 < size && y+1 < size && c == q.Black(x+1, y) && c == q.Black(x, y+1) && c == q.Black(x+1, y+1) {
+				penalty += 3
+			}
+		}
+	}
+	total := size * size
+	k := 0
+	for (dark*20 < (9-k)*total) || (dark*20 > (11+k)*total) {
+		k++
+	}
+	return penalty + 10*k
+}
+
+func qrLinePenalty(line []bool) int {
+	penalty := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			penalty += run - 2
+		}
+		run = 1
+	}
+
+	finder := []bool{true, false, true, true, true, false, true}
+	for i := 0; i+7 <= len(line); i++ {
+		match := true
+		for j, b := range finder {
+			if line[i+j] != b {
+				match = false
+				break
+			}
+		}
+		if match && (qrLight(line, i-4, i) || qrLight(line, i+7, i+11)) {
+			penalty += 40
+		}
+	}
+	return penalty
+}
+
+// Reports whether the modules from i up to j are light, counting those
+// beyond the edges of the code
+func qrLight(line []bool, i, j int) bool {
+	for ; i < j; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
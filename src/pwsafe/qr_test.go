@@ -0,0 +1,159 @@
+package pwsafe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeQRVersion(t *testing.T) {
+	for _, test := range []struct {
+		length int
+		level  QRLevel
+		size   int
+	}{
+		{0, QRLevelL, 21},
+		{17, QRLevelL, 21},
+		{18, QRLevelL, 25},
+		{14, QRLevelM, 21},
+		{15, QRLevelM, 25},
+		{7, QRLevelH, 21},
+		{8, QRLevelH, 25},
+		{271, QRLevelL, 57},
+		{272, QRLevelL, 61},
+		{2953, QRLevelL, 177},
+		{1273, QRLevelH, 177},
+	} {
+		q, err := EncodeQR(bytes.Repeat([]byte{'x'}, test.length), test.level)
+		if err != nil {
+			t.Errorf("%d bytes at level %d: %v", test.length, test.level, err)
+		} else if q.Size != test.size {
+			t.Errorf("%d bytes at level %d: size %d, want %d", test.length, test.level, q.Size, test.size)
+		}
+	}
+	for _, test := range []struct {
+		length int
+		level  QRLevel
+	}{
+		{2954, QRLevelL},
+		{1274, QRLevelH},
+	} {
+		if _, err := EncodeQR(make([]byte, test.length), test.level); err != ErrQRTooLong {
+			t.Errorf("%d bytes at level %d: %v, want ErrQRTooLong", test.length, test.level, err)
+		}
+	}
+}
+
+// The data and error correction codewords of the example of ISO/IEC
+// 18004, annex I, version 1-M
+func TestQRErrorCorrection(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	ecc := []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55}
+	if got, want := qrAddECC(data, QRLevelM, 1), append(append([]byte(nil), data...), ecc...); !bytes.Equal(got, want) {
+		t.Errorf("codewords = % x, want % x", got, want)
+	}
+
+	if got, want := qrDataBits([]byte("A"), QRLevelL, 1), []byte{0x40, 0x14, 0x10, 0xec, 0x11}; !bytes.Equal(got[:5], want) || len(got) != 19 {
+		t.Errorf("data codewords = % x, want % x and padding to 19", got, want)
+	}
+}
+
+func TestQRPatterns(t *testing.T) {
+	for _, level := range []QRLevel{QRLevelL, QRLevelM, QRLevelQ, QRLevelH} {
+		q, err := EncodeQR([]byte("otpauth://totp/Example:bob?secret=JBSWY3DPEHPK3PXP"), level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := q.Size
+
+		// Finder patterns in three corners, each in a light border
+		for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+			for dy := -4; dy <= 4; dy++ {
+				for dx := -4; dx <= 4; dx++ {
+					if x, y := c[0]+dx, c[1]+dy; x >= 0 && y >= 0 && x < size && y < size {
+						d := qrDist(dx, dy)
+						if want := d != 2 && d != 4; q.Black(x, y) != want {
+							t.Fatalf("level %d: module %d,%d of a finder pattern is %v", level, x, y, !want)
+						}
+					}
+				}
+			}
+		}
+		for i := 8; i < size-8; i++ {
+			if q.Black(i, 6) != (i%2 == 0) || q.Black(6, i) != (i%2 == 0) {
+				t.Fatalf("level %d: timing pattern broken at %d", level, i)
+			}
+		}
+		if !q.Black(8, size-8) {
+			t.Errorf("level %d: no dark module", level)
+		}
+		if q.Black(-1, 0) || q.Black(size, size-1) {
+			t.Errorf("level %d: quiet zone is dark", level)
+		}
+
+		// Both copies of the format information name the level and a mask
+		var first, second int
+		for i := 0; i < 15; i++ {
+			var a, b bool
+			switch {
+			case i <= 5:
+				a = q.Black(8, i)
+			case i == 6:
+				a = q.Black(8, 7)
+			case i == 7:
+				a = q.Black(8, 8)
+			case i == 8:
+				a = q.Black(7, 8)
+			default:
+				a = q.Black(14-i, 8)
+			}
+			if i < 8 {
+				b = q.Black(size-1-i, 8)
+			} else {
+				b = q.Black(8, size-15+i)
+			}
+			if a {
+				first |= 1 << uint(i)
+			}
+			if b {
+				second |= 1 << uint(i)
+			}
+		}
+		if first != second {
+			t.Errorf("level %d: format information %015b and %015b differ", level, first, second)
+		}
+		format := first ^ 0x5412
+		if got := format >> 13; got != qrLevelBits[level] {
+			t.Errorf("level %d: format information names level bits %02b", level, got)
+		}
+		rem := format >> 10
+		for i := 0; i < 10; i++ {
+			rem = rem<<1 ^ (rem>>9)*0x537
+		}
+		if rem != format&0x3ff {
+			t.Errorf("level %d: format information %015b has an invalid BCH code", level, first)
+		}
+	}
+}
+
+// The format information of mask 0 at each level, from ISO/IEC 18004,
+// table C.1
+func TestQRFormat(t *testing.T) {
+	for level, want := range []int{0x77c4, 0x5412, 0x355f, 0x1689} {
+		q := newQRMatrix(1)
+		q.drawFormat(QRLevel(level), 0)
+		got := 0
+		for i := 0; i < 8; i++ {
+			if q.Black(q.Size-1-i, 8) {
+				got |= 1 << uint(i)
+			}
+		}
+		for i := 8; i < 15; i++ {
+			if q.Black(8, q.Size-15+i) {
+				got |= 1 << uint(i)
+			}
+		}
+		if got != want {
+			t.Errorf("level %d: format information %015b, want %015b", level, got, want)
+		}
+	}
+}
@@ -33,16 +33,39 @@ const (
 	HdrTypeRecentlyUsed      FieldType = 0x0f
 	HdrTypePasswordPolicies  FieldType = 0x10
 	HdrTypeEmptyGroups       FieldType = 0x11
-
-	RecTypeUUID         FieldType = 0x01
-	RecTypeGroup        FieldType = 0x02
-	RecTypeTitle        FieldType = 0x03
-	RecTypeUsername     FieldType = 0x04
-	RecTypeNotes        FieldType = 0x05
-	RecTypePassword     FieldType = 0x06
-	RecTypeCreationTime FieldType = 0x07
-	RecTypeURL          FieldType = 0x0d
-	RecTypeEmail        FieldType = 0x14
+	// Not part of the format; Password Safe keeps header fields it does
+	// not know when saving
+	HdrTypeRecordTemplates FieldType = 0xe0
+
+	RecTypeUUID             FieldType = 0x01
+	RecTypeGroup            FieldType = 0x02
+	RecTypeTitle            FieldType = 0x03
+	RecTypeUsername         FieldType = 0x04
+	RecTypeNotes            FieldType = 0x05
+	RecTypePassword         FieldType = 0x06
+	RecTypeCreationTime     FieldType = 0x07
+	RecTypePasswordModTime  FieldType = 0x08
+	RecTypeAccessTime       FieldType = 0x09
+	RecTypeExpiryTime       FieldType = 0x0a
+	RecTypeModTime          FieldType = 0x0c
+	RecTypeURL              FieldType = 0x0d
+	RecTypeAutotype         FieldType = 0x0e
+	RecTypePasswordHistory  FieldType = 0x0f
+	RecTypePasswordPolicy   FieldType = 0x10
+	RecTypeExpiryInterval   FieldType = 0x11
+	RecTypeRunCommand       FieldType = 0x12
+	RecTypeDoubleClick      FieldType = 0x13
+	RecTypeEmail            FieldType = 0x14
+	RecTypeProtected        FieldType = 0x15
+	RecTypeSymbols          FieldType = 0x16
+	RecTypeShiftDoubleClick FieldType = 0x17
+	RecTypePolicyName       FieldType = 0x18
+	RecTypeKeyboardShortcut FieldType = 0x19
+	RecTypeTwoFactorKey     FieldType = 0x1b
+	RecTypeTOTPConfig       FieldType = 0x1c
+	RecTypeTOTPLength       FieldType = 0x1d
+	RecTypeTOTPTimeStep     FieldType = 0x1e
+	RecTypeTOTPStartTime    FieldType = 0x1f
 )
 
 // Field structure for read/write to file
@@ -81,12 +104,9 @@ type Reader struct {
 //
 // All reads from this reader will return unencrypted data.
 func NewReader(r io.Reader, password string) (*Reader, error) {
-	reader := &Reader{r: r, password: password}
-
-	var header psv3Header
-	binary.Read(r, binary.LittleEndian, &header)
-	if string(header.Tag[:]) != "PWS3" {
-		return nil, ErrBadFileType
+	header, err := readPsv3Header(r)
+	if err != nil {
+		return nil, err
 	}
 
 	sk := computeStretchKey(header.Salt[:], []byte(password), int(header.Iter))
@@ -96,6 +116,39 @@ func NewReader(r io.Reader, password string) (*Reader, error) {
 		return nil, ErrInvalidPassword
 	}
 
+	reader := &Reader{r: r, password: password}
+	reader.init(header, sk)
+	return reader, nil
+}
+
+// Returns a new Reader that reads from r using an already stretched key.
+//
+// ErrKeyMismatch is returned if the key does not belong to the file.
+func NewReaderKey(r io.Reader, key *Key) (*Reader, error) {
+	header, err := readPsv3Header(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.match(header); err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{r: r}
+	reader.init(header, key.Stretched[:])
+	return reader, nil
+}
+
+func readPsv3Header(r io.Reader) (*psv3Header, error) {
+	var header psv3Header
+	binary.Read(r, binary.LittleEndian, &header)
+	if string(header.Tag[:]) != "PWS3" {
+		return nil, ErrBadFileType
+	}
+	return &header, nil
+}
+
+// Set up decryption from the header and stretched key
+func (reader *Reader) init(header *psv3Header, sk []byte) {
 	var key, hmacKey [32]byte
 	tfish, _ := twofish.NewCipher(sk)
 	tfish.Decrypt(key[:16], header.B1[:])
@@ -106,8 +159,6 @@ func NewReader(r io.Reader, password string) (*Reader, error) {
 	tfish, _ = twofish.NewCipher(key[:])
 	reader.tfishDecrypter = cipher.NewCBCDecrypter(tfish, header.IV[:])
 	reader.hmacHash = hmac.New(sha256.New, hmacKey[:])
-
-	return reader, nil
 }
 
 // Read one field from r
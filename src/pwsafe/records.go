@@ -0,0 +1,161 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+var (
+	ErrNotFound     = errors.New("record not found")
+	ErrAmbiguous    = errors.New("more than one record matches")
+	ErrUnknownField = errors.New("unknown record field")
+)
+
+// Names of the record fields that can be addressed by name
+var FieldNames = []string{"group", "title", "username", "password", "notes", "url", "email"}
+
+// Split a record reference of the form "Group/Title" at the last slash.
+//
+// A reference without a slash only names a title.
+func ParseRef(ref string) (group, title string) {
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// Split a field reference of the form "Group/Title#field" at the last '#'.
+//
+// The field defaults to the password.
+func ParseFieldRef(ref string) (record, field string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "password"
+}
+
+// Copy of the record sharing no history, policy, key or unknown fields
+// with it, so the copy can be changed in place
+func (r Record) Copy() Record {
+	if r.PasswordHistory != nil {
+		h := *r.PasswordHistory
+		h.Entries = append([]PasswordHistoryEntry(nil), h.Entries...)
+		r.PasswordHistory = &h
+	}
+	if r.PasswordPolicy != nil {
+		p := *r.PasswordPolicy
+		r.PasswordPolicy = &p
+	}
+	r.TwoFactorKey = append([]byte(nil), r.TwoFactorKey...)
+	if r.Unknown != nil {
+		unknown := make([]Field, len(r.Unknown))
+		for i, f := range r.Unknown {
+			unknown[i] = Field{f.Type, append([]byte(nil), f.Data...)}
+		}
+		r.Unknown = unknown
+	}
+	return r
+}
+
+// Reference to the record in the form accepted by ParseRef
+func (r Record) Ref() string {
+	return r.Group + "/" + r.Title
+}
+
+// Returns the value of the named field, which may also be one of the
+// fields kept in the notes
+func (r Record) Field(name string) (string, error) {
+	p, err := r.fieldPtr(name)
+	if err != nil {
+		if value, ok := r.noteField(name); ok {
+			return value, nil
+		}
+		return "", err
+	}
+	return *p, nil
+}
+
+// Sets the value of the named field. Fields kept in the notes can be set
+// if they exist or the record has a template.
+func (r *Record) SetField(name, value string) error {
+	p, err := r.fieldPtr(name)
+	if err != nil {
+		_, ok := r.noteField(name)
+		valid := noteKey(name+":") == name && !strings.Contains(name, "\n")
+		if !ok && (r.Template() == "" || !valid) {
+			return err
+		}
+		r.Notes = SetNoteField(r.Notes, name, value)
+		return nil
+	}
+	*p = value
+	return nil
+}
+
+func (r *Record) fieldPtr(name string) (*string, error) {
+	switch strings.ToLower(name) {
+	case "group":
+		return &r.Group, nil
+	case "title":
+		return &r.Title, nil
+	case "username", "user":
+		return &r.Username, nil
+	case "password", "pass":
+		return &r.Password, nil
+	case "notes":
+		return &r.Notes, nil
+	case "url":
+		return &r.Url, nil
+	case "email":
+		return &r.Email, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+}
+
+// Find the record matching a "Group/Title" reference.
+//
+// When the reference has no group, the title must be unique in the safe.
+func (s *Safe) Find(ref string) (*Record, error) {
+	group, title := ParseRef(ref)
+	hasGroup := strings.Contains(ref, "/")
+
+	var found *Record
+	for i := range s.Records {
+		rec := &s.Records[i]
+		if rec.Title != title || (hasGroup && rec.Group != group) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%w: %s", ErrAmbiguous, ref)
+		}
+		found = rec
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	return found, nil
+}
+
+// Find the record with the given UUID
+func (s *Safe) FindUUID(id uuid.UUID) (*Record, error) {
+	for i := range s.Records {
+		if uuid.Equal(s.Records[i].UUID, id) {
+			return &s.Records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Remove the record with the given UUID
+func (s *Safe) Delete(id uuid.UUID) error {
+	for i := range s.Records {
+		if uuid.Equal(s.Records[i].UUID, id) {
+			s.Records = append(s.Records[:i], s.Records[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
@@ -0,0 +1,133 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRevisionChanged is returned when saving over a safe that was modified
+// since it was opened.
+var ErrRevisionChanged = errors.New("safe was modified by someone else")
+
+// A Revision identifies one version of a stored safe.
+//
+// Revisions are opaque and only compared for equality. The empty
+// revision stands for a safe that does not exist yet.
+type Revision string
+
+// A Storage holds the encrypted contents of a safe.
+type Storage interface {
+	// Open the stored safe for reading, returning its current revision
+	Open() (io.ReadCloser, Revision, error)
+
+	// Revision of the stored safe without reading it
+	Stat() (Revision, error)
+
+	// Replace the stored safe with data if it is still at revision rev,
+	// otherwise fail with ErrRevisionChanged. Returns the new revision.
+	Save(data []byte, rev Revision) (Revision, error)
+
+	// Name identifying the safe, such as an absolute path or a URL
+	String() string
+}
+
+// Returns the storage for name, which is a local path or a
+// webdav://, webdavs:// or webdav+http:// URL.
+func OpenStorage(name string) (Storage, error) {
+	if i := strings.Index(name, "://"); i > 0 {
+		switch name[:i] {
+		case "webdav", "webdavs", "webdav+https", "webdav+http":
+			return NewWebDAVStorage(name)
+		}
+		return nil, fmt.Errorf("unsupported storage %q", name)
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	return FileStorage(abs), nil
+}
+
+// FileStorage stores a safe in a local file.
+//
+// The revision is derived from the file's size and modification time, and
+// saving writes a temporary file that is renamed over the old one.
+type FileStorage string
+
+func (f FileStorage) String() string {
+	return string(f)
+}
+
+func (f FileStorage) Open() (io.ReadCloser, Revision, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, "", err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, "", err
+	}
+	return file, fileRevision(fi), nil
+}
+
+func (f FileStorage) Stat() (Revision, error) {
+	fi, err := os.Stat(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return fileRevision(fi), nil
+}
+
+func (f FileStorage) Save(data []byte, rev Revision) (Revision, error) {
+	current, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if current != rev {
+		return current, ErrRevisionChanged
+	}
+
+	name := string(f)
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(name); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", err
+	}
+	return f.Stat()
+}
+
+func fileRevision(fi os.FileInfo) Revision {
+	return Revision(fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size()))
+}
@@ -0,0 +1,178 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Record templates give structure to records Password Safe has no fields
+// for, such as servers, credit cards or licences. A template names fields
+// that are kept in the notes as "key: value" lines (see NoteField), after a
+// "template" line naming the template.
+//
+// Templates are written one per line as "Name: field, field, ...", both in
+// configuration files and in the safe's header. Lines starting with '#' are
+// comments.
+
+var ErrTemplateFormat = errors.New("invalid template")
+
+// The note field naming a record's template
+const TemplateField = "template"
+
+// A Template names the fields of a kind of record
+type Template struct {
+	Name   string
+	Fields []string
+}
+
+// Templates available in every safe, matching Bitwarden's cards and
+// identities
+var BuiltinTemplates = []Template{
+	{CardTemplate, bitwardenCardFields},
+	{IdentityTemplate, bitwardenIdentityFields},
+}
+
+// The template in the form read by ParseTemplates
+func (t Template) String() string {
+	return t.Name + ": " + strings.Join(t.Fields, ", ")
+}
+
+// Parse templates written one per line
+func ParseTemplates(data string) ([]Template, error) {
+	var templates []Template
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("%w: line %d: missing ':'", ErrTemplateFormat, i+1)
+		}
+		t := Template{Name: strings.TrimSpace(line[:colon])}
+		for _, field := range strings.Split(line[colon+1:], ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				t.Fields = append(t.Fields, field)
+			}
+		}
+		if err := t.check(); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrTemplateFormat, i+1, err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// Write templates one per line
+func FormatTemplates(templates []Template) string {
+	lines := make([]string, len(templates))
+	for i, t := range templates {
+		lines[i] = t.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t Template) check() error {
+	if t.Name == "" {
+		return errors.New("empty name")
+	}
+	if len(t.Fields) == 0 {
+		return fmt.Errorf("%s has no fields", t.Name)
+	}
+	seen := make(map[string]bool)
+	for _, field := range t.Fields {
+		switch {
+		case field == TemplateField:
+			return fmt.Errorf("%s: %q is reserved", t.Name, field)
+		case strings.ContainsAny(field, ":,\n") || noteKey(field+":") != field:
+			return fmt.Errorf("%s: invalid field name %q", t.Name, field)
+		case seen[field]:
+			return fmt.Errorf("%s: duplicate field %q", t.Name, field)
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
+// Combine lists of templates; a template replaces any of the same name in
+// an earlier list
+func MergeTemplates(lists ...[]Template) []Template {
+	var merged []Template
+	index := make(map[string]int)
+	for _, list := range lists {
+		for _, t := range list {
+			if i, ok := index[t.Name]; ok {
+				merged[i] = t
+				continue
+			}
+			index[t.Name] = len(merged)
+			merged = append(merged, t)
+		}
+	}
+	return merged
+}
+
+// Find the named template, ignoring case
+func FindTemplate(templates []Template, name string) (Template, error) {
+	for _, t := range templates {
+		if strings.EqualFold(t.Name, name) {
+			return t, nil
+		}
+	}
+	return Template{}, fmt.Errorf("unknown template %q", name)
+}
+
+// The name of the record's template, or "" if it has none
+func (r Record) Template() string {
+	value, _ := r.noteField(TemplateField)
+	return value
+}
+
+// Make the record one of the template's kind, adding its missing fields to
+// the notes with empty values
+func (r *Record) ApplyTemplate(t Template) {
+	r.Notes = SetNoteField(r.Notes, TemplateField, t.Name)
+	for _, field := range t.Fields {
+		if _, ok := r.noteField(field); !ok {
+			r.Notes = SetNoteField(r.Notes, field, "")
+		}
+	}
+}
+
+// The fields of a record made from a template: those the template lists, in
+// its order, then any others in the notes. Fields missing from the notes
+// are empty. The template's own entry is not included.
+func (r Record) TemplateFields(templates []Template) []NoteField {
+	name := r.Template()
+	if name == "" {
+		return nil
+	}
+	_, notes := ParseNoteFields(r.Notes)
+	var fields []NoteField
+	listed := map[string]bool{TemplateField: true}
+	if t, err := FindTemplate(templates, name); err == nil {
+		for _, key := range t.Fields {
+			value, _ := r.noteField(key)
+			fields = append(fields, NoteField{key, value})
+			listed[key] = true
+		}
+	}
+	for _, f := range notes {
+		if !listed[f.Key] {
+			fields = append(fields, f)
+			listed[f.Key] = true
+		}
+	}
+	return fields
+}
+
+func (r Record) noteField(key string) (string, bool) {
+	_, fields := ParseNoteFields(r.Notes)
+	for _, f := range fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,122 @@
+package pwsafe
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTemplates(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want []Template
+	}{
+		{"", nil},
+		{"# comment\n\n", nil},
+		{"Server: host, port,user", []Template{{"Server", []string{"host", "port", "user"}}}},
+		{" Server :host\n# more\nWifi: ssid, ,key,\n", []Template{{"Server", []string{"host"}}, {"Wifi", []string{"ssid", "key"}}}},
+		{"Licence: licensed to, serial number", []Template{{"Licence", []string{"licensed to", "serial number"}}}},
+	} {
+		got, err := ParseTemplates(test.data)
+		if err != nil {
+			t.Errorf("%q: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q = %v, want %v", test.data, got, test.want)
+		}
+		if again, err := ParseTemplates(FormatTemplates(got)); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%q formats as %q, which parses as %v, %v", test.data, FormatTemplates(got), again, err)
+		}
+	}
+
+	for _, data := range []string{
+		"Server host",
+		": host",
+		"Server:",
+		"Server: , ,",
+		"Server: template",
+		"Server: host, host",
+		"Server: a:b",
+		"Server: host\nbroken",
+	} {
+		if _, err := ParseTemplates(data); !errors.Is(err, ErrTemplateFormat) {
+			t.Errorf("%q: %v, want ErrTemplateFormat", data, err)
+		}
+	}
+}
+
+func TestMergeTemplates(t *testing.T) {
+	a := Template{"A", []string{"x"}}
+	b := Template{"B", []string{"y"}}
+	b2 := Template{"B", []string{"z"}}
+	c := Template{"C", []string{"w"}}
+	got := MergeTemplates([]Template{a, b}, nil, []Template{c, b2})
+	if want := []Template{a, b2, c}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+
+	if found, err := FindTemplate(got, "b"); err != nil || !reflect.DeepEqual(found, b2) {
+		t.Errorf("FindTemplate(b) = %v, %v, want %v", found, err, b2)
+	}
+	if _, err := FindTemplate(got, "D"); err == nil {
+		t.Errorf("found missing template")
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	templates := []Template{{"Server", []string{"host", "port", "user"}}}
+	r := Record{Notes: "free text\n\nport: 22\nextra: kept"}
+	r.ApplyTemplate(templates[0])
+	if want := "free text\n\nport: 22\nextra: kept\ntemplate: Server\nhost: \nuser: "; r.Notes != want {
+		t.Errorf("notes = %q, want %q", r.Notes, want)
+	}
+	if r.Template() != "Server" {
+		t.Errorf("template = %q, want Server", r.Template())
+	}
+	want := []NoteField{{"host", ""}, {"port", "22"}, {"user", ""}, {"extra", "kept"}}
+	if fields := r.TemplateFields(templates); !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+
+	// Fields of unknown templates are those of the notes
+	want = []NoteField{{"port", "22"}, {"extra", "kept"}, {"host", ""}, {"user", ""}}
+	if fields := r.TemplateFields(nil); !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields without templates = %v, want %v", fields, want)
+	}
+	if fields := (Record{Notes: "port: 22"}).TemplateFields(templates); fields != nil {
+		t.Errorf("fields of a record without template = %v", fields)
+	}
+}
+
+// Safes whose templates header is invalid still open, keeping the field
+func TestTemplatesHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.psafe3")
+	safe := Safe{Headers: Headers{Unknown: []Field{{HdrTypeRecordTemplates, []byte("not a template")}}}}
+	if err := OutputFile(path, "password", safe); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ParseFile(path, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Headers.Problems) != 1 || len(read.Headers.Templates) != 0 {
+		t.Errorf("problems %v, templates %v", read.Headers.Problems, read.Headers.Templates)
+	}
+	if !sameFields(read.Headers.Unknown, safe.Headers.Unknown) {
+		t.Errorf("unknown fields = %v, want %v", read.Headers.Unknown, safe.Headers.Unknown)
+	}
+
+	// Setting templates replaces the invalid field
+	read.Headers.Templates = []Template{{"Server", []string{"host"}}}
+	if err := OutputFile(path, "password", *read); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ParseFile(path, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Headers.Problems) != 0 || len(read.Headers.Unknown) != 0 || len(read.Headers.Templates) != 1 {
+		t.Errorf("problems %v, unknown %v, templates %v", read.Headers.Problems, read.Headers.Unknown, read.Headers.Templates)
+	}
+}
@@ -12,6 +12,17 @@ type Headers struct {
 	ProgramSave                string
 	User                       string
 	Host                       string
+	UUID                       uuid.UUID
+	Name                       string
+	Description                string
+	PasswordPolicies           []NamedPasswordPolicy
+	EmptyGroups                []string
+	Templates                  []Template
+	Unknown                    []Field // Fields kept as read, to be written back
+
+	// Problems with header fields that were kept in Unknown rather than
+	// failing the open
+	Problems []string
 }
 
 type Record struct {
@@ -24,6 +35,62 @@ type Record struct {
 	CreationTime time.Time
 	Url          string
 	Email        string
+
+	PasswordModTime time.Time
+	AccessTime      time.Time
+	ExpiryTime      time.Time
+	ModTime         time.Time
+	ExpiryInterval  int // Days between password expiries, 0 if none
+	Autotype        string
+	RunCommand      string
+	PasswordHistory *PasswordHistory
+	PasswordPolicy  *PasswordPolicy
+	PolicyName      string // Named policy used instead of PasswordPolicy
+	Symbols         string // Symbols allowed by PasswordPolicy
+	Protected       bool
+
+	TwoFactorKey  []byte    // Raw key of the TOTP codes, nil if none
+	TOTPConfig    uint8     // Hash algorithm in the low two bits
+	TOTPLength    int       // Digits of a code, 0 for the default of 6
+	TOTPTimeStep  int       // Seconds a code is valid, 0 for the default of 30
+	TOTPStartTime time.Time // Start of the first time step, zero for the Unix epoch
+
+	Unknown []Field // Fields kept as read, to be written back
+}
+
+// Previous passwords of a record, newest last
+type PasswordHistory struct {
+	Enabled bool
+	Max     int
+	Entries []PasswordHistoryEntry
+}
+
+type PasswordHistoryEntry struct {
+	Time     time.Time
+	Password string
+}
+
+// Rules for generating a password
+type PasswordPolicy struct {
+	UseLowercase      bool
+	UseUppercase      bool
+	UseDigits         bool
+	UseSymbols        bool
+	UseHexDigits      bool
+	UseEasyVision     bool
+	MakePronounceable bool
+	Length            int
+	MinLowercase      int
+	MinUppercase      int
+	MinDigits         int
+	MinSymbols        int
+}
+
+// A password policy stored by name in the safe's headers
+type NamedPasswordPolicy struct {
+	Name string
+	PasswordPolicy
+	Symbols string
 }
 
 type Safe struct {
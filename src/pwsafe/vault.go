@@ -0,0 +1,349 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// A Vault is an open safe together with its storage and key.
+//
+// It remembers the revision and fingerprint of the safe it read, so Save
+// fails with ErrRevisionChanged instead of overwriting changes made by
+// others. Reload and Merge bring in those changes.
+//
+// Safes in local files honour the lock files of Password Safe clients.
+// Save takes the lock for the duration of the write unless the vault
+// already holds it through LockFile.
+type Vault struct {
+	Storage     Storage
+	Key         *Key
+	Safe        *Safe
+	Revision    Revision
+	Fingerprint Fingerprint
+	ReadOnly    bool
+
+	// Called when committing a save to the git history fails; the save
+	// itself succeeded
+	OnHistoryError func(error)
+
+	base   *Safe // The stored safe as last read or saved, for merging
+	locked bool
+}
+
+// A Fingerprint identifies the contents of a stored safe. The hash covers
+// the encrypted headers too, so a new LastSave changes it and the header
+// needs no comparison of its own.
+type Fingerprint struct {
+	Hash [sha256.Size]byte
+}
+
+func fingerprint(data []byte) Fingerprint {
+	return Fingerprint{Hash: sha256.Sum256(data)}
+}
+
+// Copy of a safe that does not share records with it
+func copySafe(safe *Safe) *Safe {
+	c := *safe
+	c.Records = append([]Record(nil), safe.Records...)
+	return &c
+}
+
+// Open the safe in storage with the master password
+func OpenVault(storage Storage, password string) (*Vault, error) {
+	data, rev, err := readStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+	header, err := readPsv3Header(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	key := stretchKey(password, header.Salt, header.Iter)
+	if key.match(header) != nil {
+		return nil, ErrInvalidPassword
+	}
+	return openVault(storage, key, data, rev)
+}
+
+// Open the safe in storage with an already stretched key.
+//
+// ErrKeyMismatch is returned if the key does not belong to the safe.
+func OpenVaultKey(storage Storage, key *Key) (*Vault, error) {
+	data, rev, err := readStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+	return openVault(storage, key, data, rev)
+}
+
+// Returns a vault for a safe that does not exist yet in storage
+func NewVault(storage Storage, password string, safe *Safe) (*Vault, error) {
+	key, err := NewKey(password, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{Storage: storage, Key: key, Safe: safe, base: &Safe{}}, nil
+}
+
+func openVault(storage Storage, key *Key, data []byte, rev Revision) (*Vault, error) {
+	safe, err := ParseKey(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	v := &Vault{Storage: storage, Key: key}
+	v.loaded(safe, data, rev)
+	return v, nil
+}
+
+// Remember safe as the stored version read or written as data at rev
+func (v *Vault) loaded(safe *Safe, data []byte, rev Revision) {
+	v.Safe = safe
+	v.Revision = rev
+	v.Fingerprint = fingerprint(data)
+	v.base = copySafe(safe)
+}
+
+func readStorage(storage Storage) ([]byte, Revision, error) {
+	r, rev, err := storage.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	return data, rev, err
+}
+
+// Encrypt and store the safe.
+//
+// Fails with ErrRevisionChanged if the stored safe changed since it was
+// opened or last saved.
+func (v *Vault) Save() error {
+	if v.ReadOnly {
+		return ErrReadOnly
+	}
+	if !v.locked {
+		if err := v.LockFile(); err != nil {
+			return err
+		}
+		defer v.UnlockFile()
+	}
+
+	if v.Revision != "" {
+		changed, err := v.Changed()
+		if err != nil {
+			return err
+		} else if changed {
+			return ErrRevisionChanged
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeSafe(&buf, v.Key, v.Safe); err != nil {
+		return err
+	}
+	rev, err := v.Storage.Save(buf.Bytes(), v.Revision)
+	if err != nil {
+		return err
+	}
+	v.loaded(v.Safe, buf.Bytes(), rev)
+
+	// A failed commit does not undo the save, so it is only reported
+	if history, herr := v.History(); herr == nil {
+		if cerr := history.Commit(saveMessage(v.Safe)); cerr != nil && v.OnHistoryError != nil {
+			v.OnHistoryError(cerr)
+		}
+	}
+	return nil
+}
+
+// Reports whether the stored safe differs from the one the vault read or
+// last saved, comparing the contents and not just the revision.
+func (v *Vault) Changed() (bool, error) {
+	data, _, err := readStorage(v.Storage)
+	if os.IsNotExist(err) {
+		return v.Revision != "", nil
+	} else if err != nil {
+		return false, err
+	}
+	return sha256.Sum256(data) != v.Fingerprint.Hash, nil
+}
+
+// Replace the safe with the stored one, discarding unsaved changes
+func (v *Vault) Reload() error {
+	data, rev, err := readStorage(v.Storage)
+	if err != nil {
+		return err
+	}
+	safe, err := ParseKey(bytes.NewReader(data), v.Key)
+	if err != nil {
+		return err
+	}
+	v.loaded(safe, data, rev)
+	return nil
+}
+
+// Merge the changes made to the stored safe since it was read into the
+// vault's safe, matching records by UUID. The merged safe still has to be
+// saved.
+//
+// Records changed on both sides keep the vault's version. The stored
+// version is added as a copy and returned as a conflict.
+func (v *Vault) Merge() ([]Record, error) {
+	data, rev, err := readStorage(v.Storage)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := ParseKey(bytes.NewReader(data), v.Key)
+	if err != nil {
+		return nil, err
+	}
+	merged, conflicts := Merge(v.base, v.Safe, theirs)
+	v.loaded(theirs, data, rev)
+	v.Safe = merged
+	return conflicts, nil
+}
+
+// Save the safe to storage that does not exist yet and keep using it. A
+// vault holding the lock of its file moves the lock to the new file. Fails
+// with an error wrapping os.ErrExist, or with ErrRevisionChanged if another
+// client got there first, when the storage exists; the vault then keeps
+// its old storage.
+func (v *Vault) SaveAs(storage Storage) error {
+	if rev, err := storage.Stat(); err != nil {
+		return err
+	} else if rev != "" {
+		return fmt.Errorf("%s: %w", storage, os.ErrExist)
+	}
+	oldStorage, oldRevision, locked := v.Storage, v.Revision, v.locked
+	v.UnlockFile()
+	v.Storage = storage
+	v.Revision = ""
+	var err error
+	if locked {
+		err = v.LockFile()
+	}
+	if err == nil {
+		err = v.Save()
+	}
+	if err != nil {
+		v.UnlockFile()
+		v.Storage = oldStorage
+		v.Revision = oldRevision
+		if locked {
+			// Best effort; the error of the new storage matters more
+			v.LockFile()
+		}
+	}
+	return err
+}
+
+// Take the Password Safe lock file of a safe in a local file and hold
+// it until UnlockFile. Returns a *LockedError if another client has the
+// safe open for writing.
+func (v *Vault) LockFile() error {
+	file, ok := v.Storage.(FileStorage)
+	if !ok || v.locked {
+		return nil
+	}
+	if err := CreateLock(string(file)); err != nil {
+		return err
+	}
+	v.locked = true
+	return nil
+}
+
+// Release the lock file taken by LockFile
+func (v *Vault) UnlockFile() error {
+	file, ok := v.Storage.(FileStorage)
+	if !ok || !v.locked {
+		return nil
+	}
+	v.locked = false
+	return RemoveLock(string(file))
+}
+
+// Name of the vault's storage
+func (v *Vault) String() string {
+	return v.Storage.String()
+}
+
+// Returns the git history of a vault stored in a local file tracked in a
+// git work tree, or ErrNoHistory.
+func (v *Vault) History() (*GitHistory, error) {
+	file, ok := v.Storage.(FileStorage)
+	if !ok {
+		return nil, ErrNoHistory
+	}
+	return NewGitHistory(string(file))
+}
+
+// Decrypt an older version of the vault's safe.
+//
+// The vault's key is tried first. Versions saved with another salt or
+// password need the password, which is asked for through source.
+func (v *Vault) OpenVersion(data []byte, source PasswordSource) (*Safe, error) {
+	safe, _, err := v.openVersion(data, source)
+	return safe, err
+}
+
+func (v *Vault) openVersion(data []byte, source PasswordSource) (*Safe, *Key, error) {
+	safe, err := ParseKey(bytes.NewReader(data), v.Key)
+	if err != ErrKeyMismatch || source == nil {
+		return safe, v.Key, err
+	}
+	header, err := readPsv3Header(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	pw, err := source.Password("Password for older version: ")
+	if err != nil {
+		return nil, nil, err
+	}
+	key := stretchKey(pw, header.Salt, header.Iter)
+	safe, err = ParseKey(bytes.NewReader(data), key)
+	if err == ErrKeyMismatch {
+		err = ErrInvalidPassword
+	}
+	return safe, key, err
+}
+
+// Replace the stored safe with the version at rev from its git history
+// and commit the result.
+func (v *Vault) Restore(rev string, source PasswordSource) error {
+	history, err := v.History()
+	if err != nil {
+		return err
+	}
+	data, err := history.Read(rev)
+	if err != nil {
+		return err
+	}
+	safe, key, err := v.openVersion(data, source)
+	if err != nil {
+		return err
+	}
+	if v.ReadOnly {
+		return ErrReadOnly
+	}
+	if !v.locked {
+		if err := v.LockFile(); err != nil {
+			return err
+		}
+		defer v.UnlockFile()
+	}
+	newRev, err := v.Storage.Save(data, v.Revision)
+	if err != nil {
+		return err
+	}
+	v.loaded(safe, data, newRev)
+	v.Key = key
+
+	short := rev
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	return history.Commit("Restore " + short)
+}
@@ -0,0 +1,36 @@
+package pwsafe
+
+import "time"
+
+// Poll storage every interval and send its revision whenever it differs
+// from the last one seen, starting from rev, until stop is closed.
+//
+// Polling works the same for local files and remote storage. Callers
+// compare the revisions sent with their own to tell their saves apart
+// from changes made by others.
+func WatchStorage(storage Storage, rev Revision, interval time.Duration, stop <-chan struct{}) <-chan Revision {
+	ch := make(chan Revision)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			current, err := storage.Stat()
+			if err != nil || current == rev {
+				continue
+			}
+			rev = current
+			select {
+			case ch <- rev:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,233 @@
+package pwsafe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// WebDAVStorage stores a safe as a file on a WebDAV server.
+//
+// The revision is the server's ETag for the file, or its Last-Modified
+// time when the server sends no ETag or only a weak one, which If-Match
+// never matches. Saves refuse to overwrite a file changed since it was
+// read.
+type WebDAVStorage struct {
+	URL      string // http or https URL of the file
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+// Returns the storage for a webdav:// URL.
+//
+// webdav://, webdavs:// and webdav+https:// use HTTPS, webdav+http:// plain
+// HTTP. Credentials come from the URL or the PWSAFE_WEBDAV_USER and
+// PWSAFE_WEBDAV_PASSWORD environment variables.
+func NewWebDAVStorage(name string) (*WebDAVStorage, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "webdav", "webdavs", "webdav+https":
+		u.Scheme = "https"
+	case "webdav+http":
+		u.Scheme = "http"
+	default:
+		return nil, fmt.Errorf("not a webdav url: %s", name)
+	}
+
+	s := &WebDAVStorage{
+		Username: os.Getenv("PWSAFE_WEBDAV_USER"),
+		Password: os.Getenv("PWSAFE_WEBDAV_PASSWORD"),
+		Client:   http.DefaultClient,
+	}
+	if u.User != nil {
+		s.Username = u.User.Username()
+		if pw, ok := u.User.Password(); ok {
+			s.Password = pw
+		}
+		u.User = nil
+	}
+	s.URL = u.String()
+	return s, nil
+}
+
+func (s *WebDAVStorage) String() string {
+	return s.URL
+}
+
+func (s *WebDAVStorage) request(method string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, s.URL, r)
+	if err != nil {
+		return nil, err
+	}
+	if s.Username != "" || s.Password != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	return req, nil
+}
+
+func (s *WebDAVStorage) Open() (io.ReadCloser, Revision, error) {
+	req, err := s.request("GET", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("webdav GET %s: %s", s.URL, resp.Status)
+	}
+	return resp.Body, webdavRevision(resp), nil
+}
+
+func (s *WebDAVStorage) Stat() (Revision, error) {
+	req, err := s.request("HEAD", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return webdavRevision(resp), nil
+	case http.StatusNotFound:
+		return "", nil
+	}
+	return "", fmt.Errorf("webdav HEAD %s: %s", s.URL, resp.Status)
+}
+
+// Save locks the file while comparing revisions so the check and the write
+// are atomic even on servers that ignore If-Match.
+func (s *WebDAVStorage) Save(data []byte, rev Revision) (Revision, error) {
+	var token string
+	if rev != "" {
+		var err error
+		if token, err = s.lock(); err != nil {
+			return "", err
+		}
+		defer s.unlock(token)
+	}
+
+	current, err := s.Stat()
+	if err != nil {
+		return "", err
+	}
+	if current != rev {
+		return current, ErrRevisionChanged
+	}
+
+	req, err := s.request("PUT", data)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token != "" {
+		req.Header.Set("If", "(<"+token+">)")
+	}
+	switch {
+	case rev == "":
+		req.Header.Set("If-None-Match", "*")
+	case strings.HasPrefix(string(rev), "etag:"):
+		req.Header.Set("If-Match", strings.TrimPrefix(string(rev), "etag:"))
+	case strings.HasPrefix(string(rev), "mtime:"):
+		req.Header.Set("If-Unmodified-Since", strings.TrimPrefix(string(rev), "mtime:"))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return "", ErrRevisionChanged
+	default:
+		return "", fmt.Errorf("webdav PUT %s: %s", s.URL, resp.Status)
+	}
+	if newRev := webdavRevision(resp); newRev != "" {
+		return newRev, nil
+	}
+	return s.Stat()
+}
+
+const webdavLockInfo = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>pwsafe</D:owner></D:lockinfo>`
+
+// Take a short exclusive write lock on the file. Returns an empty token
+// if the server does not support locking.
+func (s *WebDAVStorage) lock() (string, error) {
+	req, err := s.request("LOCK", []byte(webdavLockInfo))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Timeout", "Second-60")
+	req.Header.Set("Depth", "0")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return strings.Trim(resp.Header.Get("Lock-Token"), "<>"), nil
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return "", nil
+	case http.StatusLocked:
+		return "", fmt.Errorf("webdav %s is locked by another client", s.URL)
+	}
+	return "", fmt.Errorf("webdav LOCK %s: %s", s.URL, resp.Status)
+}
+
+func (s *WebDAVStorage) unlock(token string) {
+	if token == "" {
+		return
+	}
+	req, err := s.request("UNLOCK", nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Lock-Token", "<"+token+">")
+	if resp, err := s.Client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func webdavRevision(resp *http.Response) Revision {
+	etag := resp.Header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return Revision("etag:" + etag)
+	}
+	if mtime := resp.Header.Get("Last-Modified"); mtime != "" {
+		return Revision("mtime:" + mtime)
+	}
+	if etag != "" {
+		// Without a condition to send, only the comparison under the
+		// lock protects the save
+		return Revision("weak:" + etag)
+	}
+	return ""
+}
@@ -0,0 +1,269 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// A WebDAV server for one file, in front of golang.org/x/net/webdav, which
+// ignores conditional PUT headers. It evaluates them itself, with validators
+// derived from a version number so tests control exactly when they change.
+type testDAV struct {
+	handler    *webdav.Handler
+	validators string // "strong", "weak" or "mtime"
+	noLock     bool   // answer LOCK and UNLOCK with 405, like servers without locking
+
+	mu      sync.Mutex
+	exists  bool
+	version int
+	race    func() // run before the next PUT checks its conditions
+}
+
+func newTestDAV(validators string) *testDAV {
+	return &testDAV{
+		handler:    &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()},
+		validators: validators,
+	}
+}
+
+func (d *testDAV) etag() string {
+	return fmt.Sprintf(`"v%d"`, d.version)
+}
+
+func (d *testDAV) lastModified() time.Time {
+	return time.Unix(1500000000, 0).Add(time.Duration(d.version) * time.Minute).UTC()
+}
+
+func (d *testDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.noLock && (r.Method == "LOCK" || r.Method == "UNLOCK") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == "PUT" {
+		d.mu.Lock()
+		race := d.race
+		d.race = nil
+		d.mu.Unlock()
+		if race != nil {
+			race()
+		}
+	}
+
+	d.mu.Lock()
+	if r.Method == "PUT" {
+		if status := d.checkConditions(r); status != 0 {
+			d.mu.Unlock()
+			w.WriteHeader(status)
+			return
+		}
+		d.exists = true
+		d.version++
+	}
+	hw := &validatorWriter{ResponseWriter: w, d: d}
+	d.mu.Unlock()
+	d.handler.ServeHTTP(hw, r)
+}
+
+// Returns the status refusing the PUT, or 0 to let it through
+func (d *testDAV) checkConditions(r *http.Request) int {
+	if match := r.Header.Get("If-Match"); match != "" {
+		// Strong comparison; weak validators never match
+		if !d.exists || d.validators != "strong" || match != d.etag() {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if r.Header.Get("If-None-Match") == "*" && d.exists {
+		return http.StatusPreconditionFailed
+	}
+	if since := r.Header.Get("If-Unmodified-Since"); since != "" && d.exists {
+		t, err := http.ParseTime(since)
+		if err != nil || d.lastModified().After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+// Replaces the validators of the wrapped handler with those of testDAV
+type validatorWriter struct {
+	http.ResponseWriter
+	d       *testDAV
+	written bool
+}
+
+func (w *validatorWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		h := w.Header()
+		h.Del("ETag")
+		h.Del("Last-Modified")
+		w.d.mu.Lock()
+		if w.d.exists {
+			switch w.d.validators {
+			case "strong":
+				h.Set("ETag", w.d.etag())
+			case "weak":
+				h.Set("ETag", "W/"+w.d.etag())
+			}
+			h.Set("Last-Modified", w.d.lastModified().Format(http.TimeFormat))
+		}
+		w.d.mu.Unlock()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *validatorWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func startTestDAV(t *testing.T, d *testDAV) *WebDAVStorage {
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+	s, err := NewWebDAVStorage("webdav+http://" + srv.Listener.Addr().String() + "/safe.psafe3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readDAV(t *testing.T, s *WebDAVStorage) ([]byte, Revision) {
+	body, rev, err := s.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, rev
+}
+
+func TestWebDAVSaveAndOpen(t *testing.T) {
+	for _, validators := range []string{"strong", "weak", "mtime"} {
+		t.Run(validators, func(t *testing.T) {
+			s := startTestDAV(t, newTestDAV(validators))
+			if rev, err := s.Stat(); err != nil || rev != "" {
+				t.Fatalf("Stat of missing file = %q, %v", rev, err)
+			}
+			rev, err := s.Save([]byte("one"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, openRev := readDAV(t, s)
+			if string(data) != "one" || openRev != rev {
+				t.Fatalf("Open = %q at %q, want %q at %q", data, openRev, "one", rev)
+			}
+			rev2, err := s.Save([]byte("two"), rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev2 == rev {
+				t.Errorf("revision did not change: %q", rev2)
+			}
+			if data, _ := readDAV(t, s); string(data) != "two" {
+				t.Errorf("Open = %q, want %q", data, "two")
+			}
+		})
+	}
+}
+
+func TestWebDAVRevisionPrefix(t *testing.T) {
+	for validators, prefix := range map[string]string{"strong": "etag:", "weak": "mtime:", "mtime": "mtime:"} {
+		s := startTestDAV(t, newTestDAV(validators))
+		rev, err := s.Save([]byte("one"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix([]byte(rev), []byte(prefix)) {
+			t.Errorf("%s validators: revision %q, want prefix %q", validators, rev, prefix)
+		}
+	}
+}
+
+// A save from a client that read an older version is refused by the
+// comparison under the server's lock
+func TestWebDAVStaleSave(t *testing.T) {
+	for _, validators := range []string{"strong", "weak", "mtime"} {
+		t.Run(validators, func(t *testing.T) {
+			d := newTestDAV(validators)
+			s := startTestDAV(t, d)
+			rev, err := s.Save([]byte("one"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Save([]byte("theirs"), rev); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Save([]byte("ours"), rev); !errors.Is(err, ErrRevisionChanged) {
+				t.Fatalf("stale Save = %v, want ErrRevisionChanged", err)
+			}
+			if _, err := s.Save([]byte("new"), ""); !errors.Is(err, ErrRevisionChanged) {
+				t.Fatalf("Save over existing file = %v, want ErrRevisionChanged", err)
+			}
+			if data, _ := readDAV(t, s); string(data) != "theirs" {
+				t.Errorf("file holds %q, want %q", data, "theirs")
+			}
+		})
+	}
+}
+
+// On servers without locking, a write between the revision check and the
+// PUT is caught by the conditional headers
+func TestWebDAVConditionalPut(t *testing.T) {
+	for _, validators := range []string{"strong", "mtime"} {
+		t.Run(validators, func(t *testing.T) {
+			d := newTestDAV(validators)
+			d.noLock = true
+			s := startTestDAV(t, d)
+			other := *s
+			rev, err := s.Save([]byte("one"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.race = func() {
+				if _, err := other.Save([]byte("theirs"), rev); err != nil {
+					t.Error(err)
+				}
+			}
+			if _, err := s.Save([]byte("ours"), rev); !errors.Is(err, ErrRevisionChanged) {
+				t.Fatalf("racing Save = %v, want ErrRevisionChanged", err)
+			}
+			if data, _ := readDAV(t, s); string(data) != "theirs" {
+				t.Errorf("file holds %q, want %q", data, "theirs")
+			}
+		})
+	}
+}
+
+// A file created between the check and the PUT is not overwritten
+func TestWebDAVConditionalCreate(t *testing.T) {
+	d := newTestDAV("strong")
+	d.noLock = true
+	s := startTestDAV(t, d)
+	other := *s
+	d.race = func() {
+		if _, err := other.Save([]byte("theirs"), ""); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := s.Save([]byte("ours"), ""); !errors.Is(err, ErrRevisionChanged) {
+		t.Fatalf("racing create = %v, want ErrRevisionChanged", err)
+	}
+	if data, _ := readDAV(t, s); string(data) != "theirs" {
+		t.Errorf("file holds %q, want %q", data, "theirs")
+	}
+}
@@ -0,0 +1,358 @@
+package pwsafe
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// The XML format of the Password Safe desktop client, as described by
+// passwordsafe.xsd. Newlines in notes are replaced by the delimiter.
+
+const (
+	xmlDelimiter  = "»"
+	xmlTimeFormat = "2006-01-02T15:04:05"
+)
+
+type xmlSafe struct {
+	XMLName              xml.Name        `xml:"passwordsafe"`
+	Delimiter            string          `xml:"delimiter,attr"`
+	Database             string          `xml:"Database,attr,omitempty"`
+	ExportTimeStamp      string          `xml:"ExportTimeStamp,attr,omitempty"`
+	FromDatabaseFormat   string          `xml:"FromDatabaseFormat,attr,omitempty"`
+	NumberHashIterations int             `xml:"NumberHashIterations,omitempty"`
+	Policies             *xmlPolicies    `xml:"Password_Policies,omitempty"`
+	EmptyGroups          *xmlEmptyGroups `xml:"EmptyGroups,omitempty"`
+	Entries              []xmlEntry      `xml:"entry"`
+}
+
+type xmlPolicies struct {
+	Policy []xmlPolicy `xml:"Policy"`
+}
+
+type xmlEmptyGroups struct {
+	Name []string `xml:"EGName"`
+}
+
+type xmlPolicy struct {
+	Name string `xml:"PWName"`
+	xmlPolicyRules
+	Symbols string `xml:"symbols,omitempty"`
+}
+
+type xmlPolicyRules struct {
+	DefaultLength     int     `xml:"PWDefaultLength,omitempty"`
+	Length            int     `xml:"PWLength,omitempty"`
+	UseDigits         xmlBool `xml:"PWUseDigits,omitempty"`
+	UseEasyVision     xmlBool `xml:"PWUseEasyVision,omitempty"`
+	UseHexDigits      xmlBool `xml:"PWUseHexDigits,omitempty"`
+	UseLowercase      xmlBool `xml:"PWUseLowercase,omitempty"`
+	UseSymbols        xmlBool `xml:"PWUseSymbols,omitempty"`
+	UseUppercase      xmlBool `xml:"PWUseUppercase,omitempty"`
+	MakePronounceable xmlBool `xml:"PWMakePronounceable,omitempty"`
+	MinLowercase      int     `xml:"PWLowercaseMinLength,omitempty"`
+	MinUppercase      int     `xml:"PWUppercaseMinLength,omitempty"`
+	MinDigits         int     `xml:"PWDigitMinLength,omitempty"`
+	MinSymbols        int     `xml:"PWSymbolMinLength,omitempty"`
+}
+
+type xmlEntry struct {
+	ID            int             `xml:"id,attr,omitempty"`
+	Group         string          `xml:"group,omitempty"`
+	Title         string          `xml:"title"`
+	Username      string          `xml:"username,omitempty"`
+	Password      string          `xml:"password,omitempty"`
+	URL           string          `xml:"url,omitempty"`
+	Autotype      string          `xml:"autotype,omitempty"`
+	Notes         string          `xml:"notes,omitempty"`
+	UUID          string          `xml:"uuid,omitempty"`
+	CTime         string          `xml:"ctimex,omitempty"`
+	ATime         string          `xml:"atimex,omitempty"`
+	XTime         string          `xml:"xtimex,omitempty"`
+	PMTime        string          `xml:"pmtimex,omitempty"`
+	RMTime        string          `xml:"rmtimex,omitempty"`
+	XTimeInterval int             `xml:"xtime_interval,omitempty"`
+	History       *xmlHistory     `xml:"pwhistory,omitempty"`
+	PolicyName    string          `xml:"PasswordPolicyName,omitempty"`
+	Policy        *xmlPolicyRules `xml:"PasswordPolicy,omitempty"`
+	Symbols       string          `xml:"symbols,omitempty"`
+	RunCommand    string          `xml:"runcommand,omitempty"`
+	Email         string          `xml:"email,omitempty"`
+	Protected     xmlBool         `xml:"protected,omitempty"`
+	TwoFactorKey  string          `xml:"twofactorkey,omitempty"` // base32
+	TOTPConfig    uint8           `xml:"totpconfig,omitempty"`
+	TOTPLength    int             `xml:"totplength,omitempty"`
+	TOTPTimeStep  int             `xml:"totptimestep,omitempty"`
+	TOTPStartTime string          `xml:"totpstarttime,omitempty"`
+}
+
+type xmlHistory struct {
+	Status  xmlBool           `xml:"status"`
+	Max     int               `xml:"max"`
+	Num     int               `xml:"num"`
+	Entries []xmlHistoryEntry `xml:"history_entries>history_entry,omitempty"`
+}
+
+type xmlHistoryEntry struct {
+	Num         int    `xml:"num,attr"`
+	Changed     string `xml:"changedx"`
+	OldPassword string `xml:"oldpassword"`
+}
+
+// A boolean written as 1 or 0
+type xmlBool bool
+
+func (b xmlBool) MarshalText() ([]byte, error) {
+	if b {
+		return []byte("1"), nil
+	}
+	return []byte("0"), nil
+}
+
+func (b *xmlBool) UnmarshalText(text []byte) error {
+	switch strings.TrimSpace(string(text)) {
+	case "1", "true":
+		*b = true
+	case "0", "false", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %q", text)
+	}
+	return nil
+}
+
+func formatXMLTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(xmlTimeFormat)
+}
+
+func parseXMLTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(xmlTimeFormat, s, time.Local)
+}
+
+func toXMLPolicy(p PasswordPolicy) xmlPolicyRules {
+	return xmlPolicyRules{
+		UseDigits:         xmlBool(p.UseDigits),
+		UseEasyVision:     xmlBool(p.UseEasyVision),
+		UseHexDigits:      xmlBool(p.UseHexDigits),
+		UseLowercase:      xmlBool(p.UseLowercase),
+		UseSymbols:        xmlBool(p.UseSymbols),
+		UseUppercase:      xmlBool(p.UseUppercase),
+		MakePronounceable: xmlBool(p.MakePronounceable),
+		MinLowercase:      p.MinLowercase,
+		MinUppercase:      p.MinUppercase,
+		MinDigits:         p.MinDigits,
+		MinSymbols:        p.MinSymbols,
+	}
+}
+
+func (x xmlPolicyRules) policy(length int) PasswordPolicy {
+	return PasswordPolicy{
+		UseLowercase:      bool(x.UseLowercase),
+		UseUppercase:      bool(x.UseUppercase),
+		UseDigits:         bool(x.UseDigits),
+		UseSymbols:        bool(x.UseSymbols),
+		UseHexDigits:      bool(x.UseHexDigits),
+		UseEasyVision:     bool(x.UseEasyVision),
+		MakePronounceable: bool(x.MakePronounceable),
+		Length:            length,
+		MinLowercase:      x.MinLowercase,
+		MinUppercase:      x.MinUppercase,
+		MinDigits:         x.MinDigits,
+		MinSymbols:        x.MinSymbols,
+	}
+}
+
+// Write the safe in the XML format of Password Safe.
+//
+// The name is stored as the database the export was made from.
+func WriteXML(w io.Writer, safe *Safe, name string) error {
+	doc := xmlSafe{
+		Delimiter:          xmlDelimiter,
+		Database:           name,
+		ExportTimeStamp:    formatXMLTime(time.Now()),
+		FromDatabaseFormat: fmt.Sprintf("%d.%02d", safe.Headers.VersionMajor, safe.Headers.VersionMinor),
+	}
+	if len(safe.Headers.EmptyGroups) > 0 {
+		doc.EmptyGroups = &xmlEmptyGroups{safe.Headers.EmptyGroups}
+	}
+	if len(safe.Headers.PasswordPolicies) > 0 {
+		doc.Policies = &xmlPolicies{}
+	}
+	for _, p := range safe.Headers.PasswordPolicies {
+		policy := xmlPolicy{Name: p.Name, xmlPolicyRules: toXMLPolicy(p.PasswordPolicy), Symbols: p.Symbols}
+		policy.DefaultLength = p.Length
+		doc.Policies.Policy = append(doc.Policies.Policy, policy)
+	}
+
+	for i, r := range safe.Records {
+		entry := xmlEntry{
+			ID:            i + 1,
+			Group:         r.Group,
+			Title:         r.Title,
+			Username:      r.Username,
+			Password:      r.Password,
+			URL:           r.Url,
+			Autotype:      r.Autotype,
+			Notes:         strings.Replace(strings.Replace(r.Notes, "\r\n", "\n", -1), "\n", xmlDelimiter, -1),
+			CTime:         formatXMLTime(r.CreationTime),
+			ATime:         formatXMLTime(r.AccessTime),
+			XTime:         formatXMLTime(r.ExpiryTime),
+			PMTime:        formatXMLTime(r.PasswordModTime),
+			RMTime:        formatXMLTime(r.ModTime),
+			XTimeInterval: r.ExpiryInterval,
+			PolicyName:    r.PolicyName,
+			Symbols:       r.Symbols,
+			RunCommand:    r.RunCommand,
+			Email:         r.Email,
+			Protected:     xmlBool(r.Protected),
+			TOTPConfig:    r.TOTPConfig,
+			TOTPLength:    r.TOTPLength,
+			TOTPTimeStep:  r.TOTPTimeStep,
+			TOTPStartTime: formatXMLTime(r.TOTPStartTime),
+		}
+		if r.HasTOTP() {
+			entry.TwoFactorKey = EncodeOTPSecret(r.TwoFactorKey)
+		}
+		if !uuid.Equal(r.UUID, uuid.Nil) {
+			entry.UUID = strings.Replace(r.UUID.String(), "-", "", -1)
+		}
+		if h := r.PasswordHistory; h != nil {
+			entry.History = &xmlHistory{Status: xmlBool(h.Enabled), Max: h.Max, Num: len(h.Entries)}
+			for j, e := range h.Entries {
+				entry.History.Entries = append(entry.History.Entries,
+					xmlHistoryEntry{Num: j + 1, Changed: formatXMLTime(e.Time), OldPassword: e.Password})
+			}
+		}
+		if p := r.PasswordPolicy; p != nil {
+			policy := toXMLPolicy(*p)
+			policy.Length = p.Length
+			entry.Policy = &policy
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Read a safe in the XML format of Password Safe.
+//
+// Only the records, password policies and empty groups are read.
+func ReadXML(r io.Reader) (*Safe, error) {
+	var doc xmlSafe
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var safe Safe
+	if doc.EmptyGroups != nil {
+		safe.Headers.EmptyGroups = doc.EmptyGroups.Name
+	}
+	if doc.Policies != nil {
+		for _, p := range doc.Policies.Policy {
+			safe.Headers.PasswordPolicies = append(safe.Headers.PasswordPolicies, NamedPasswordPolicy{
+				Name:           p.Name,
+				PasswordPolicy: p.policy(p.DefaultLength),
+				Symbols:        p.Symbols,
+			})
+		}
+	}
+
+	for _, entry := range doc.Entries {
+		record := Record{
+			Group:          entry.Group,
+			Title:          entry.Title,
+			Username:       entry.Username,
+			Password:       entry.Password,
+			Url:            entry.URL,
+			Autotype:       entry.Autotype,
+			ExpiryInterval: entry.XTimeInterval,
+			PolicyName:     entry.PolicyName,
+			Symbols:        entry.Symbols,
+			RunCommand:     entry.RunCommand,
+			Email:          entry.Email,
+			Protected:      bool(entry.Protected),
+			TOTPConfig:     entry.TOTPConfig,
+			TOTPLength:     entry.TOTPLength,
+			TOTPTimeStep:   entry.TOTPTimeStep,
+		}
+		if doc.Delimiter != "" {
+			record.Notes = strings.Replace(entry.Notes, doc.Delimiter, "\n", -1)
+		} else {
+			record.Notes = entry.Notes
+		}
+		if entry.UUID != "" {
+			id, err := uuid.FromString(entry.UUID)
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: %v", entry.Title, err)
+			}
+			record.UUID = id
+		}
+		if entry.TwoFactorKey != "" {
+			key, err := DecodeOTPSecret(entry.TwoFactorKey)
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: invalid twofactorkey: %v", entry.Title, err)
+			}
+			record.TwoFactorKey = key
+		}
+
+		times := []struct {
+			s string
+			t *time.Time
+		}{
+			{entry.CTime, &record.CreationTime},
+			{entry.ATime, &record.AccessTime},
+			{entry.XTime, &record.ExpiryTime},
+			{entry.PMTime, &record.PasswordModTime},
+			{entry.RMTime, &record.ModTime},
+			{entry.TOTPStartTime, &record.TOTPStartTime},
+		}
+		for _, t := range times {
+			parsed, err := parseXMLTime(t.s)
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: %v", entry.Title, err)
+			}
+			*t.t = parsed
+		}
+
+		if h := entry.History; h != nil {
+			history := &PasswordHistory{Enabled: bool(h.Status), Max: h.Max}
+			for _, e := range h.Entries {
+				changed, err := parseXMLTime(e.Changed)
+				if err != nil {
+					return nil, fmt.Errorf("entry %q: %v", entry.Title, err)
+				}
+				history.Entries = append(history.Entries, PasswordHistoryEntry{changed, e.OldPassword})
+			}
+			record.PasswordHistory = history
+		}
+		if p := entry.Policy; p != nil {
+			policy := p.policy(p.Length)
+			record.PasswordPolicy = &policy
+		}
+		safe.Records = append(safe.Records, record)
+	}
+	return &safe, nil
+}
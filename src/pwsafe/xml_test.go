@@ -0,0 +1,98 @@
+package pwsafe
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXMLRoundTrip(t *testing.T) {
+	safe := testDumpSafe()
+	var buf bytes.Buffer
+	if err := WriteXML(&buf, safe, "test.psafe3"); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadXML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Headers.PasswordPolicies, safe.Headers.PasswordPolicies) {
+		t.Errorf("policies = %+v, want %+v", read.Headers.PasswordPolicies, safe.Headers.PasswordPolicies)
+	}
+	if !reflect.DeepEqual(read.Headers.EmptyGroups, safe.Headers.EmptyGroups) {
+		t.Errorf("empty groups = %v, want %v", read.Headers.EmptyGroups, safe.Headers.EmptyGroups)
+	}
+	if len(read.Records) != 1 {
+		t.Fatalf("read %d records, want 1", len(read.Records))
+	}
+
+	// Fields Password Safe does not know are not exported
+	want := safe.Records[0]
+	want.Unknown = nil
+	if fields := ChangedFields(want, read.Records[0]); len(fields) > 0 {
+		t.Errorf("fields changed: %v\nwant %+v\ngot  %+v", fields, want, read.Records[0])
+	}
+}
+
+// An export of the desktop client
+func TestReadXML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<passwordsafe delimiter="»" Database="x.psafe3" ExportTimeStamp="2020-01-02T03:04:05" FromDatabaseFormat="3.13">
+  <NumberHashIterations>2048</NumberHashIterations>
+  <entry id="1">
+    <group>Web</group>
+    <title>example</title>
+    <username>bob</username>
+    <password>secret &amp; more</password>
+    <notes>line one»line two</notes>
+    <uuid>0f3a5d5e9b2c4e1d8f6a7b8c9d0e1f2a</uuid>
+    <ctimex>2020-01-02T03:04:05</ctimex>
+    <pwhistory>
+      <status>1</status>
+      <max>3</max>
+      <num>1</num>
+      <history_entries>
+        <history_entry num="1">
+          <changedx>2019-12-01T00:00:00</changedx>
+          <oldpassword>old</oldpassword>
+        </history_entry>
+      </history_entries>
+    </pwhistory>
+  </entry>
+</passwordsafe>
+`
+	safe, err := ReadXML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(safe.Records) != 1 {
+		t.Fatalf("read %d records, want 1", len(safe.Records))
+	}
+	r := safe.Records[0]
+	for _, test := range []struct{ name, got, want string }{
+		{"group", r.Group, "Web"},
+		{"password", r.Password, "secret & more"},
+		{"notes", r.Notes, "line one\nline two"},
+		{"uuid", r.UUID.String(), "0f3a5d5e-9b2c-4e1d-8f6a-7b8c9d0e1f2a"},
+		{"ctime", r.CreationTime.Format(xmlTimeFormat), "2020-01-02T03:04:05"},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s = %q, want %q", test.name, test.got, test.want)
+		}
+	}
+	if h := r.PasswordHistory; h == nil || !h.Enabled || h.Max != 3 || len(h.Entries) != 1 || h.Entries[0].Password != "old" {
+		t.Errorf("history = %+v", h)
+	}
+
+	for _, bad := range []string{
+		`<passwordsafe><entry><title>x</title><uuid>nope</uuid></entry></passwordsafe>`,
+		`<passwordsafe><entry><title>x</title><ctimex>yesterday</ctimex></entry></passwordsafe>`,
+		`<passwordsafe><entry><title>x</title><protected>maybe</protected></entry></passwordsafe>`,
+		`<passwordsafe><entry>`,
+	} {
+		if _, err := ReadXML(strings.NewReader(bad)); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}